@@ -0,0 +1,11 @@
+// Package webserver bundles the default site's static files and templates
+// into the binary, so the server can run without the source tree
+package webserver
+
+import "embed"
+
+// Assets holds public/ and templates/ as they were at build time
+// Serve it with handler.SiteConfig{Files: webserver.Assets, ...}.
+//
+//go:embed public templates
+var Assets embed.FS
@@ -1,20 +1,80 @@
 package main
 
 import (
+	"flag"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"webserver"
+	"webserver/internal/handler"
 	"webserver/internal/protocol"
 	"webserver/internal/server"
+	"webserver/internal/throttle"
 )
 
 func main() {
+	// Subcommands: "precompress" prepares .gz/.zst sidecars for static files
+	if len(os.Args) > 1 && os.Args[1] == "precompress" {
+		runPrecompress(os.Args[2:])
+		return
+	}
+
+	// -embedded serves public/ and templates/ from the binary, so it runs from any directory
+	embedded := flag.Bool("embedded", false, "serve the site files built into the binary instead of ./public and ./templates")
+	// -file-cache keeps hot static files in memory, invalidated through inotify
+	fileCacheMB := flag.Int64("file-cache", 0, "memory budget in MB for hot static files (0 = read every hit from disk)")
+	// -uploads user:password enables authenticated PUT/DELETE/MKCOL under /static/
+	uploads := flag.String("uploads", "", "enable authenticated uploads under /static/ with these Basic credentials (user:password)")
+	// -webdav additionally serves /static/ as a WebDAV share (needs -uploads)
+	webdav := flag.Bool("webdav", false, "serve /static/ as a WebDAV share for the -uploads credentials")
+	// -archives lets /static/ directories be downloaded with ?download=zip or tar.gz
+	archives := flag.Bool("archives", false, "allow /static/ directories to be downloaded as zip or tar.gz archives")
+	// -download-rate and friends cap the bandwidth of GET /static/ in KB/s;
+	// ranges under 1MB are exempt so seeking in a video stays quick
+	downloadRate := flag.Int64("download-rate", 0, "bandwidth limit in KB/s for each /static/ download (0 = unlimited)")
+	clientRate := flag.Int64("download-rate-client", 0, "bandwidth limit in KB/s for all /static/ downloads of one client IP (0 = unlimited)")
+	totalRate := flag.Int64("download-rate-total", 0, "bandwidth limit in KB/s for all /static/ downloads together (0 = unlimited)")
+	// -images serves resized copies of static images under /img/, cached in -image-cache
+	images := flag.Bool("images", false, "serve resized static images under /img/ (e.g. /img/static/images/image.jpg?w=320)")
+	imageCache := flag.String("image-cache", filepath.Join(os.TempDir(), "webserver-images"), "directory for resized images (empty = resize on every request)")
+	flag.Parse()
+
 	addr := "127.0.0.1:8080"
 
 	config := protocol.NewHTTP11Config()
 
 	srv := server.NewServerWithVersion(addr, config)
+	if *embedded || *fileCacheMB > 0 || *uploads != "" || *webdav || *archives ||
+		*downloadRate > 0 || *clientRate > 0 || *totalRate > 0 || *images {
+		site := handler.DefaultSiteConfig()
+		if *embedded {
+			site.Files = webserver.Assets
+		}
+		site.FileCacheSize = *fileCacheMB * 1024 * 1024
+		if user, password, ok := strings.Cut(*uploads, ":"); ok {
+			site.Uploads = &handler.WriteOptions{Authorize: handler.BasicAuth(user, password)}
+		} else if *uploads != "" {
+			log.Fatalf("-uploads expects user:password")
+		}
+		site.WebDAV = *webdav
+		site.ArchiveDownloads = *archives
+		if *downloadRate > 0 || *clientRate > 0 || *totalRate > 0 {
+			site.DownloadLimit = &throttle.Config{
+				PerConnection:     throttle.Rate{BytesPerSecond: *downloadRate * 1024},
+				PerClient:         throttle.Rate{BytesPerSecond: *clientRate * 1024},
+				Global:            throttle.NewBucket(throttle.Rate{BytesPerSecond: *totalRate * 1024}),
+				ExemptRangesBelow: 1024 * 1024,
+			}
+		}
+		if *images {
+			site.Images = &handler.ImageOptions{CacheDir: *imageCache}
+		}
+		srv.Hosts().SetDefault(handler.NewSiteHandler(site))
+	}
 
 	log.Printf("Starting server on %s with %s", addr, config.Version)
 	if err := srv.Start(); err != nil {
 		log.Fatalf("Server failed to start: %v", err)
 	}
-}
\ No newline at end of file
+}
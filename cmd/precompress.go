@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"webserver/internal/handler"
+)
+
+// runPrecompress implements "precompress [-root dir]"
+// Writes .gz/.zst sidecars next to every compressible static file so the file
+// server can send them without compressing on each request. Run it as a build
+// step after the static tree changes.
+func runPrecompress(args []string) {
+	flags := flag.NewFlagSet("precompress", flag.ExitOnError)
+	root := flags.String("root", handler.DefaultSiteConfig().StaticRoot, "static directory to precompress")
+	flags.Parse(args)
+
+	stats, err := handler.Precompress(*root)
+	if err != nil {
+		log.Fatalf("Precompress failed: %v", err)
+	}
+
+	log.Printf("Precompressed %s: %d files, %d sidecars written, %d skipped, %d bytes saved",
+		*root, stats.Files, stats.Written, stats.Skipped, stats.Saved)
+}
@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+)
+
+// archiveFormats maps ?download= values to the archive they produce
+var archiveFormats = map[string]struct {
+	ext, contentType string
+}{
+	"zip":    {".zip", "application/zip"},
+	"tar.gz": {".tar.gz", "application/gzip"},
+	"tgz":    {".tar.gz", "application/gzip"},
+}
+
+// SetArchiveDownloads lets clients download a directory tree as one archive:
+// GET /static/builds/123/?download=zip (or tar.gz). The archive is built
+// while it is sent, so it is never held in memory or written to disk. It holds
+// exactly what GET could fetch: hidden files, blocked extensions and symlinks
+// leaving the root are skipped, as are directory symlinks that form a loop.
+func (fs *FileServer) SetArchiveDownloads(enabled bool) {
+	fs.archives = enabled
+}
+
+// archiveRequested returns the ?download= value of a request ("" if none)
+func archiveRequested(req *protocol.Request) string {
+	_, rawQuery, _ := strings.Cut(req.Path, "?")
+	query, _ := url.ParseQuery(rawQuery)
+	return strings.ToLower(query.Get("download"))
+}
+
+// archiveWriter adds entries to a zip or tar.gz stream
+type archiveWriter interface {
+	addDir(name string, info os.FileInfo) error
+	addFile(name string, info os.FileInfo, contentType string, file io.Reader) error
+	Close() error
+}
+
+// serveArchive streams dir (at rel) as an archive with chunked encoding
+// HTTP/1.0 clients get the same stream delimited by closing the connection.
+func (fs *FileServer) serveArchive(req *protocol.Request, conn *tcp.TCPConn, dir staticFile, info os.FileInfo, rel, format string, keepAlive bool, remainingRequests int) error {
+	spec, ok := archiveFormats[format]
+	if !ok {
+		return fs.sendError(conn, req, 400, "Unsupported archive format", keepAlive, remainingRequests)
+	}
+
+	name := path.Base("/" + rel)
+	if name == "/" {
+		name = "archive"
+	}
+	chunked := req.Version == protocol.HTTP11
+	if !chunked {
+		keepAlive = false
+	}
+
+	resp := protocol.NewResponse(200, "OK", req.Version, "")
+
+	// Set headers
+	resp.Headers["Content-Type"] = spec.contentType
+	resp.Headers["X-Content-Type-Options"] = "nosniff"
+	resp.Headers["Content-Disposition"] = fmt.Sprintf(`attachment; filename="%s"`, strings.ReplaceAll(name, `"`, "_")+spec.ext)
+	resp.Headers["Cache-Control"] = "no-store" // Rebuilt on every request
+	if chunked {
+		resp.Headers["Transfer-Encoding"] = "chunked"
+	}
+
+	// Set Connection headers for keep-alive
+	if keepAlive {
+		resp.Headers["Connection"] = "keep-alive"
+		resp.Headers["Keep-Alive"] = fmt.Sprintf("timeout=30, max=%d", remainingRequests)
+	} else {
+		resp.Headers["Connection"] = "close"
+	}
+
+	if err := protocol.WriteHeaders(conn, resp); err != nil {
+		return err
+	}
+	if req.Method == "HEAD" {
+		return nil
+	}
+
+	// archive -> buffer -> chunked -> connection
+	var out io.Writer = conn
+	var chunkWriter *protocol.ChunkedWriter
+	if chunked {
+		chunkWriter = protocol.NewChunkedWriter(conn)
+		out = chunkWriter
+	}
+	buffered := bufio.NewWriterSize(out, streamChunkSize)
+
+	var archive archiveWriter
+	if format == "zip" {
+		archive = &zipArchive{w: zip.NewWriter(buffered)}
+	} else {
+		gz := gzip.NewWriter(buffered)
+		archive = &tarArchive{w: tar.NewWriter(gz), gz: gz}
+	}
+
+	// A failure midway can't be reported in the status line any more; the
+	// connection is closed instead, leaving the client a truncated archive
+	if err := fs.archiveTree(archive, dir, rel, name, []os.FileInfo{info}); err != nil {
+		return err
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	if chunkWriter != nil {
+		return chunkWriter.Close()
+	}
+	return nil
+}
+
+// archiveTree adds the members of dir to archive under prefix, in name order
+// Members are opened exactly as GET opens them, so the access policy applies
+// to every entry. ancestors detects directory symlinks that loop.
+func (fs *FileServer) archiveTree(archive archiveWriter, dir staticFile, rel, prefix string, ancestors []os.FileInfo) error {
+	readDir, ok := dir.(iofs.ReadDirFile)
+	if !ok {
+		return errIsDirectory
+	}
+	entries, err := readDir.ReadDir(-1)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		childRel := path.Join(rel, entry.Name())
+		child, info, err := fs.open(childRel)
+		if err != nil {
+			continue // Hidden, blocked, escaping the root, or gone
+		}
+
+		name := prefix + "/" + entry.Name()
+		switch {
+		case info.IsDir():
+			loop := false
+			for _, ancestor := range ancestors {
+				if os.SameFile(ancestor, info) {
+					loop = true
+					break
+				}
+			}
+			if !loop {
+				if err = archive.addDir(name, info); err == nil {
+					err = fs.archiveTree(archive, child, childRel, name, append(ancestors, info))
+				}
+			}
+		case info.Mode().IsRegular():
+			err = archive.addFile(name, info, contentTypeOf(childRel, child), io.NewSectionReader(child, 0, info.Size()))
+		}
+		child.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// zipArchive writes a zip stream; sizes and CRCs follow each entry in a data
+// descriptor, so nothing has to be known in advance
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (z *zipArchive) addDir(name string, info os.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name + "/"
+	_, err = z.w.CreateHeader(header)
+	return err
+}
+
+func (z *zipArchive) addFile(name string, info os.FileInfo, contentType string, file io.Reader) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	// Text is deflated; images, video and archives are stored as they are
+	header.Method = zip.Store
+	if shouldCompress(contentType) {
+		header.Method = zip.Deflate
+	}
+
+	w, err := z.w.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, file)
+	return err
+}
+
+func (z *zipArchive) Close() error {
+	return z.w.Close()
+}
+
+// tarArchive writes a gzip-compressed tar stream
+type tarArchive struct {
+	w  *tar.Writer
+	gz *gzip.Writer
+}
+
+func (t *tarArchive) addDir(name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name + "/"
+	return t.w.WriteHeader(header)
+}
+
+func (t *tarArchive) addFile(name string, info os.FileInfo, contentType string, file io.Reader) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	header.Name = name
+
+	if err := t.w.WriteHeader(header); err != nil {
+		return err
+	}
+	// The header promised info.Size() bytes; a file that shrank meanwhile
+	// can't be archived consistently
+	_, err = io.CopyN(t.w, file, info.Size())
+	return err
+}
+
+func (t *tarArchive) Close() error {
+	if err := t.w.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultCompressionCacheSize is the memory budget used by DefaultSiteConfig
+const DefaultCompressionCacheSize = 16 * 1024 * 1024 // 16MB
+
+// cacheKey identifies one compressed variant of one version of a file
+// Including mtime and size means an edited file never hits an old entry.
+type cacheKey struct {
+	path     string
+	modTime  int64 // UnixNano
+	size     int64
+	encoding string
+}
+
+// cacheEntry is a cached compressed body
+type cacheEntry struct {
+	key  cacheKey
+	data []byte
+}
+
+// CacheStats is a snapshot of cache metrics
+type CacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+	Bytes         int64  `json:"bytes"`
+	Budget        int64  `json:"budget"`
+}
+
+// CompressionCache is an LRU cache of compressed file bodies bounded by a byte budget
+// It lets serveSmallFile skip both the disk read and the compression for hot
+// files that have no precompressed sidecar. Safe for concurrent use.
+type CompressionCache struct {
+	mu      sync.Mutex
+	budget  int64
+	used    int64
+	order   *list.List                   // Front = most recently used
+	entries map[cacheKey]*list.Element   // Key -> element holding *cacheEntry
+	byPath  map[string]map[cacheKey]bool // Path -> its cached keys, for invalidation
+	stats   CacheStats
+}
+
+// NewCompressionCache creates a cache holding at most budget bytes of compressed data
+func NewCompressionCache(budget int64) *CompressionCache {
+	return &CompressionCache{
+		budget:  budget,
+		order:   list.New(),
+		entries: make(map[cacheKey]*list.Element),
+		byPath:  make(map[string]map[cacheKey]bool),
+	}
+}
+
+// Get returns the compressed body for key and marks it recently used
+func (c *CompressionCache) Get(key cacheKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, found := c.entries[key]
+	if !found {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).data, true
+}
+
+// Put stores a compressed body, evicting least recently used entries to stay
+// within budget. Entries for older versions of the same file are dropped.
+// Bodies larger than the whole budget are not cached.
+func (c *CompressionCache) Put(key cacheKey, data []byte) {
+	size := int64(len(data))
+	if size > c.budget {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The file changed: anything cached for a different mtime/size is stale
+	for old := range c.byPath[key.path] {
+		if old.modTime != key.modTime || old.size != key.size {
+			c.remove(old)
+			c.stats.Invalidations++
+		}
+	}
+
+	if elem, found := c.entries[key]; found {
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	for c.used+size > c.budget {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.remove(oldest.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, data: data})
+	if c.byPath[key.path] == nil {
+		c.byPath[key.path] = make(map[cacheKey]bool)
+	}
+	c.byPath[key.path][key] = true
+	c.used += size
+}
+
+// Invalidate drops every cached variant of path
+func (c *CompressionCache) Invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key := range c.byPath[path] {
+		c.remove(key)
+		c.stats.Invalidations++
+	}
+}
+
+// Stats returns the current metrics
+func (c *CompressionCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	stats.Bytes = c.used
+	stats.Budget = c.budget
+	return stats
+}
+
+// remove deletes one entry (caller holds the lock)
+func (c *CompressionCache) remove(key cacheKey) {
+	elem, found := c.entries[key]
+	if !found {
+		return
+	}
+
+	c.order.Remove(elem)
+	delete(c.entries, key)
+	c.used -= int64(len(elem.Value.(*cacheEntry).data))
+
+	delete(c.byPath[key.path], key)
+	if len(c.byPath[key.path]) == 0 {
+		delete(c.byPath, key.path)
+	}
+}
+
+// newCacheKey builds the key for a file version and encoding
+func newCacheKey(path string, modTime time.Time, size int64, encoding string) cacheKey {
+	return cacheKey{path: path, modTime: modTime.UnixNano(), size: size, encoding: encoding}
+}
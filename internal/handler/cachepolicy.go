@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"webserver/internal/protocol"
+)
+
+// CacheRule sets the caching headers of the static files it matches
+// A rule matches when every criterion it sets matches; a rule with no
+// criteria matches everything (use it last, as the default).
+type CacheRule struct {
+	Pattern       string   // Glob on the path relative to the root ("static/js/*.js"), or on the file name if it has no "/" ("*.html"); "dir/**" matches everything under dir
+	Extensions    []string // File extensions (".html", ".css"), case-insensitive
+	Fingerprinted bool     // Only file names carrying a content hash ("app.3f9a1c.js", "logo-9b2e44f1.png")
+
+	CacheControl string            // Cache-Control value ("" = none)
+	Expires      bool              // Also send Expires, derived from max-age, for HTTP/1.0 caches
+	Headers      map[string]string // Extra response headers
+}
+
+// CachePolicy is an ordered list of rules; the first matching rule applies
+// It is used for 200, 206 and 304 responses alike, so revalidations refresh
+// the same headers the original response had.
+type CachePolicy struct {
+	Rules []CacheRule
+}
+
+// DefaultCachePolicy caches fingerprinted assets forever, makes HTML
+// revalidate on every use and caches everything else for an hour
+func DefaultCachePolicy() CachePolicy {
+	return CachePolicy{Rules: []CacheRule{
+		{Fingerprinted: true, CacheControl: "public, max-age=31536000, immutable"},
+		{Extensions: []string{".html", ".htm"}, CacheControl: "no-cache"},
+		{Extensions: []string{".ico"}, CacheControl: "public, max-age=86400"},
+		{CacheControl: "public, max-age=3600"},
+	}}
+}
+
+// httpTimeFormat is the format of every date header the handlers set
+const httpTimeFormat = protocol.TimeFormat
+
+// fingerprintPattern finds a content hash between the name and the extension:
+// at least 6 hex digits after a "." or "-"
+var fingerprintPattern = regexp.MustCompile(`[.-]([0-9a-fA-F]{6,})\.[^.]+$`)
+
+// isFingerprinted reports whether a file name carries a content hash
+// The hash must mix digits and the letters a-f, so date and build stamps
+// ("report-20261018.log", "build-1234567.zip") are not mistaken for one:
+// such files are overwritten in place and must not be cached as immutable.
+func isFingerprinted(name string) bool {
+	m := fingerprintPattern.FindStringSubmatch(name)
+	return m != nil && strings.ContainsAny(m[1], "0123456789") && strings.ContainsAny(m[1], "abcdefABCDEF")
+}
+
+// SetCachePolicy replaces the rules for Cache-Control, Expires and extra headers
+func (fs *FileServer) SetCachePolicy(policy CachePolicy) {
+	fs.cachePolicy = policy
+}
+
+// matches reports whether the rule applies to rel
+func (r CacheRule) matches(rel string) bool {
+	name := path.Base(rel)
+
+	if r.Pattern != "" {
+		switch {
+		case strings.HasSuffix(r.Pattern, "/**"):
+			if dir := strings.TrimSuffix(r.Pattern, "/**"); !strings.HasPrefix(rel, dir+"/") {
+				return false
+			}
+		case strings.Contains(r.Pattern, "/"):
+			if ok, _ := path.Match(r.Pattern, rel); !ok {
+				return false
+			}
+		default:
+			if ok, _ := path.Match(r.Pattern, name); !ok {
+				return false
+			}
+		}
+	}
+
+	if len(r.Extensions) > 0 {
+		ext := strings.ToLower(path.Ext(name))
+		found := false
+		for _, candidate := range r.Extensions {
+			if ext == strings.ToLower(candidate) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return !r.Fingerprinted || isFingerprinted(name)
+}
+
+// apply sets the headers of the first rule matching rel
+func (p CachePolicy) apply(headers map[string]string, rel string) {
+	for _, rule := range p.Rules {
+		if !rule.matches(rel) {
+			continue
+		}
+
+		if rule.CacheControl != "" {
+			headers["Cache-Control"] = rule.CacheControl
+		}
+		if rule.Expires {
+			headers["Expires"] = expiresFor(rule.CacheControl, time.Now())
+		}
+		for name, value := range rule.Headers {
+			headers[name] = value
+		}
+		return
+	}
+}
+
+// expiresFor derives an Expires date from a Cache-Control value
+// no-cache, no-store and a missing max-age mean "already expired".
+func expiresFor(cacheControl string, now time.Time) string {
+	maxAge := -1
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-cache", "no-store":
+			return time.Unix(0, 0).UTC().Format(httpTimeFormat)
+		case "max-age":
+			if seconds, err := strconv.Atoi(value); err == nil {
+				maxAge = seconds
+			}
+		}
+	}
+
+	if maxAge < 0 {
+		return time.Unix(0, 0).UTC().Format(httpTimeFormat)
+	}
+	return now.Add(time.Duration(maxAge) * time.Second).UTC().Format(httpTimeFormat)
+}
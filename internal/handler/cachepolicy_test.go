@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsFingerprinted(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"app.3f9a1c.js", true},
+		{"logo-9b2e44f1.png", true},
+		{"vendor.0123456789abcdef.css", true},
+		{"APP.3F9A1C.JS", true},
+		{"report-20261018.log", false}, // Date stamp
+		{"build-1234567.zip", false},   // Build number
+		{"backup.20261018123000.tar", false},
+		{"app.deadbeef.js", false}, // No digit
+		{"app.3f9a1.js", false},    // Too short
+		{"app.js", false},
+		{"3f9a1c.js", false}, // No separator before the hash
+		{"app.3f9a1c", false},
+	}
+	for _, tt := range tests {
+		if got := isFingerprinted(tt.name); got != tt.want {
+			t.Errorf("isFingerprinted(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDefaultCachePolicyDateStamps(t *testing.T) {
+	headers := map[string]string{}
+	DefaultCachePolicy().apply(headers, "static/report-20261018.log")
+	if got := headers["Cache-Control"]; got != "public, max-age=3600" {
+		t.Errorf("Cache-Control = %q, want the default max-age", got)
+	}
+}
+
+func TestExpiresFor(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.FixedZone("CEST", 2*3600))
+	tests := []struct {
+		cacheControl string
+		want         string
+	}{
+		{"public, max-age=3600", "Sun, 18 Oct 2026 11:00:00 GMT"},
+		{"max-age=0", "Sun, 18 Oct 2026 10:00:00 GMT"},
+		{"no-cache", "Thu, 01 Jan 1970 00:00:00 GMT"},
+		{"public, no-store", "Thu, 01 Jan 1970 00:00:00 GMT"},
+		{"public", "Thu, 01 Jan 1970 00:00:00 GMT"},
+	}
+	for _, tt := range tests {
+		got := expiresFor(tt.cacheControl, now)
+		if got != tt.want {
+			t.Errorf("expiresFor(%q) = %q, want %q", tt.cacheControl, got, tt.want)
+		}
+		if _, err := parseHTTPTime(got); err != nil {
+			t.Errorf("expiresFor(%q) = %q does not parse as an HTTP date: %v", tt.cacheControl, got, err)
+		}
+	}
+}
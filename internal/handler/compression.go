@@ -3,9 +3,13 @@ package handler
 import (
 	"bytes"
 	"compress/gzip"
+	"compress/zlib"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"webserver/internal/protocol"
+	"webserver/internal/zstd"
 )
 
 // minSizeForCompression is the minimum size to bother compressing
@@ -42,49 +46,187 @@ func shouldCompress(contentType string) bool {
 	return false
 }
 
-// acceptsGzip checks if client accepts gzip encoding
-// Parses the Accept-Encoding header to look for "gzip"
-func acceptsGzip(acceptEncoding string) bool {
-	if acceptEncoding == "" {
-		return false
+// Encoder is a content-coding the server can apply to response bodies
+// Name is the token used in Accept-Encoding/Content-Encoding ("gzip", "zstd").
+// NewWriter returns a writer that compresses into w; Close must flush the stream.
+type Encoder interface {
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+type gzipEncoder struct{}
+
+func (gzipEncoder) Name() string { return "gzip" }
+
+func (gzipEncoder) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+type deflateEncoder struct{}
+
+// Name is "deflate", which HTTP defines as zlib-wrapped DEFLATE (RFC 9110 section 8.4.1.2)
+func (deflateEncoder) Name() string { return "deflate" }
+
+func (deflateEncoder) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriter(w), nil
+}
+
+type zstdEncoder struct{}
+
+func (zstdEncoder) Name() string { return "zstd" }
+
+func (zstdEncoder) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w), nil
+}
+
+// encoders lists the available encodings in server preference order
+// When the client rates several encodings equally, the earliest one wins.
+// gzip comes first: it is universally supported and our zstd encoder trades
+// ratio for simplicity.
+var encoders = []Encoder{gzipEncoder{}, zstdEncoder{}, deflateEncoder{}}
+
+// RegisterEncoder adds an encoding, or replaces the one with the same name
+// New encodings are appended, so they are preferred last on ties.
+func RegisterEncoder(enc Encoder) {
+	for i, existing := range encoders {
+		if existing.Name() == enc.Name() {
+			encoders[i] = enc
+			return
+		}
 	}
+	encoders = append(encoders, enc)
+}
 
-	// Accept-Encoding: gzip, deflate, br
-	encodings := strings.Split(strings.ToLower(acceptEncoding), ",")
-	for _, encoding := range encodings {
-		if strings.TrimSpace(encoding) == "gzip" || strings.HasPrefix(strings.TrimSpace(encoding), "gzip;") {
-			return true
+// acceptEncoding is one coding from an Accept-Encoding header with its q-value
+type acceptEncoding struct {
+	name string
+	q    float64
+}
+
+// parseAcceptEncoding parses "gzip;q=1.0, zstd, *;q=0" into codings and q-values
+// Missing or malformed q-values count as 1. "x-gzip" is treated as "gzip".
+func parseAcceptEncoding(header string) []acceptEncoding {
+	var result []acceptEncoding
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name == "" {
+			continue
+		}
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && v >= 0 && v <= 1 {
+				q = v
+			}
 		}
+
+		result = append(result, acceptEncoding{name: name, q: q})
 	}
+	return result
+}
 
-	return false
+// negotiateEncoder picks the encoding to use for a request's Accept-Encoding header
+// The coding with the highest q-value wins, ties going to server preference order.
+// Returns nil when the response should be sent uncompressed: no header, nothing
+// acceptable, or "identity" rated higher than every supported coding.
+func negotiateEncoder(header string) Encoder {
+	return negotiate(header, encoders)
+}
+
+// negotiate is negotiateEncoder restricted to candidates (in preference order)
+func negotiate(header string, candidates []Encoder) Encoder {
+	if strings.TrimSpace(header) == "" {
+		return nil
+	}
+
+	accepted := parseAcceptEncoding(header)
+	qualityOf := func(name string) float64 {
+		wildcard := -1.0
+		for _, a := range accepted {
+			if a.name == name {
+				return a.q
+			}
+			if a.name == "*" {
+				wildcard = a.q
+			}
+		}
+		if wildcard >= 0 {
+			return wildcard
+		}
+		if name == "identity" {
+			return 1 // Acceptable unless explicitly excluded
+		}
+		return 0
+	}
+
+	var best Encoder
+	bestQ := 0.0
+	for _, enc := range candidates {
+		if q := qualityOf(enc.Name()); q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+
+	if best != nil && qualityOf("identity") > bestQ {
+		return nil
+	}
+	return best
+}
+
+// streamingEncoder returns the encoder for compressing a streamed file, or nil
+// Chunked transfer coding is HTTP/1.1 only, so HTTP/1.0 clients get the file as-is.
+func streamingEncoder(req *protocol.Request, contentType string) Encoder {
+	if req.Version != protocol.HTTP11 || !shouldCompress(contentType) {
+		return nil
+	}
+	return negotiateEncoder(req.Headers["Accept-Encoding"])
+}
+
+// representationETag returns the tag a full response would carry: weak when
+// the body is going to be compressed for this client
+func representationETag(req *protocol.Request, etag, contentType string, size int64) string {
+	if req.Headers["Range"] == "" && shouldCompress(contentType) && size >= minSizeForCompression &&
+		negotiateEncoder(req.Headers["Accept-Encoding"]) != nil {
+		return weakETag(etag)
+	}
+	return etag
 }
 
-// compressContent compresses content using gzip
+// compressContent compresses content with the given encoder
 // Returns the compressed bytes or an error
-func compressContent(content []byte) ([]byte, error) {
+func compressContent(enc Encoder, content []byte) ([]byte, error) {
 	var buf bytes.Buffer
 
-	// Create gzip writer with default compression level
-	gz := gzip.NewWriter(&buf)
+	w, err := enc.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
 
 	// Write content
-	if _, err := gz.Write(content); err != nil {
-		gz.Close()
+	if _, err := w.Write(content); err != nil {
+		w.Close()
 		return nil, err
 	}
 
 	// Close to flush remaining data
-	if err := gz.Close(); err != nil {
+	if err := w.Close(); err != nil {
 		return nil, err
 	}
 
 	return buf.Bytes(), nil
 }
 
-// CompressResponse applies gzip compression to a response if appropriate
+// CompressResponse applies the negotiated compression to a response if appropriate
 // This is a reusable middleware-style function that:
-// 1. Checks if client accepts gzip
+// 1. Picks an encoding from the client's Accept-Encoding (q-values honoured)
 // 2. Checks if content type is compressible
 // 3. Checks if content is large enough to benefit from compression
 // 4. Compresses and updates the response headers
@@ -114,11 +256,9 @@ func CompressResponse(resp *protocol.Response, req *protocol.Request) {
 
 	// Check compression criteria
 	bodyBytes := []byte(resp.Body)
-	shouldGzip := acceptsGzip(req.Headers["Accept-Encoding"]) &&
-		shouldCompress(contentType) &&
-		len(bodyBytes) >= minSizeForCompression
+	enc := negotiateEncoder(req.Headers["Accept-Encoding"])
 
-	if !shouldGzip {
+	if enc == nil || !shouldCompress(contentType) || len(bodyBytes) < minSizeForCompression {
 		// Set Vary header even if not compressing (for cache correctness)
 		if shouldCompress(contentType) {
 			resp.Headers["Vary"] = "Accept-Encoding"
@@ -127,7 +267,7 @@ func CompressResponse(resp *protocol.Response, req *protocol.Request) {
 	}
 
 	// Compress the content
-	compressed, err := compressContent(bodyBytes)
+	compressed, err := compressContent(enc, bodyBytes)
 	if err != nil || len(compressed) >= len(bodyBytes) {
 		// Compression failed or didn't reduce size
 		// Set Vary header for cache correctness
@@ -137,7 +277,12 @@ func CompressResponse(resp *protocol.Response, req *protocol.Request) {
 
 	// Update response with compressed content
 	resp.Body = string(compressed)
-	resp.Headers["Content-Encoding"] = "gzip"
+	resp.Headers["Content-Encoding"] = enc.Name()
 	resp.Headers["Content-Length"] = fmt.Sprintf("%d", len(compressed))
 	resp.Headers["Vary"] = "Accept-Encoding"
+
+	// The compressed bytes differ from the identity body, so a strong tag would lie
+	if etag := resp.Headers["ETag"]; etag != "" {
+		resp.Headers["ETag"] = weakETag(etag)
+	}
 }
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"webserver/internal/protocol"
+)
+
+// ETagMode selects how FileServer derives strong ETags
+type ETagMode int
+
+const (
+	// ETagFileInfo builds the tag from size, mtime (nanoseconds) and inode: free
+	// to compute, and changes even when a file is rewritten within one second
+	ETagFileInfo ETagMode = iota
+
+	// ETagContentHash hashes the file contents (SHA-256), so identical files on
+	// different servers or after a redeploy get the same tag. Hashes are cached
+	// per file version.
+	ETagContentHash
+)
+
+// maxHashCacheEntries bounds the content-hash cache (it is reset when full)
+const maxHashCacheEntries = 4096
+
+var (
+	hashCacheMu sync.Mutex
+	hashCache   = make(map[cacheKey]string)
+)
+
+// fileETag returns the strong ETag for an open file, including the quotes
+// name identifies the file in the content-hash cache.
+func fileETag(file io.ReaderAt, name string, info os.FileInfo, mode ETagMode) (string, error) {
+	if mode == ETagContentHash {
+		return contentHashETag(file, name, info)
+	}
+
+	var inode uint64
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		inode = stat.Ino
+	}
+	return fmt.Sprintf(`"%x-%x-%x"`, info.Size(), info.ModTime().UnixNano(), inode), nil
+}
+
+// contentHashETag hashes the file, reusing the hash while mtime and size are unchanged
+func contentHashETag(file io.ReaderAt, name string, info os.FileInfo) (string, error) {
+	key := newCacheKey(name, info.ModTime(), info.Size(), "")
+
+	hashCacheMu.Lock()
+	tag, found := hashCache[key]
+	hashCacheMu.Unlock()
+	if found {
+		return tag, nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, 0, info.Size())); err != nil {
+		return "", err
+	}
+	tag = `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+
+	hashCacheMu.Lock()
+	if len(hashCache) >= maxHashCacheEntries {
+		hashCache = make(map[cacheKey]string)
+	}
+	hashCache[key] = tag
+	hashCacheMu.Unlock()
+
+	return tag, nil
+}
+
+// contentETag returns a strong ETag for an in-memory body
+func contentETag(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// weakETag marks a tag as weak, used for compressed variants: the bytes differ
+// from the identity representation but the content is equivalent
+func weakETag(tag string) string {
+	if tag == "" || strings.HasPrefix(tag, "W/") {
+		return tag
+	}
+	return "W/" + tag
+}
+
+// etagMatches compares two entity tags (RFC 9110 section 8.8.3.2)
+// Strong comparison requires both tags to be strong; weak comparison ignores W/.
+func etagMatches(a, b string, strong bool) bool {
+	aWeak, bWeak := strings.HasPrefix(a, "W/"), strings.HasPrefix(b, "W/")
+	if strong && (aWeak || bWeak) {
+		return false
+	}
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// etagListMatches reports whether tag matches any entry of an If-Match or
+// If-None-Match header ("*" matches any current representation)
+func etagListMatches(header, tag string, strong bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return tag != ""
+	}
+	if tag == "" {
+		return false
+	}
+	for _, candidate := range splitETags(header) {
+		if etagMatches(candidate, tag, strong) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitETags splits a comma-separated entity-tag list (commas may appear inside quotes)
+func splitETags(header string) []string {
+	var tags []string
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(header); i++ {
+		switch header[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				if tag := strings.TrimSpace(header[start:i]); tag != "" {
+					tags = append(tags, tag)
+				}
+				start = i + 1
+			}
+		}
+	}
+	if tag := strings.TrimSpace(header[start:]); tag != "" {
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// EvaluatePreconditions applies conditional request headers (RFC 9110 section 13.2.2)
+// etag is the current strong or weak tag ("" if none) and lastModified the
+// modification time (zero if unknown). Returns 0 to serve the request normally,
+// 304 (Not Modified) or 412 (Precondition Failed). The order is:
+//
+//  1. If-Match (strong comparison), else If-Unmodified-Since
+//  2. If-None-Match (weak comparison), else If-Modified-Since for GET/HEAD
+func EvaluatePreconditions(req *protocol.Request, etag string, lastModified time.Time) int {
+	lastModified = lastModified.Truncate(time.Second) // HTTP dates have one-second precision
+
+	if ifMatch, ok := req.Headers["If-Match"]; ok {
+		if !etagListMatches(ifMatch, etag, true) {
+			return 412
+		}
+	} else if since := req.Headers["If-Unmodified-Since"]; since != "" && !lastModified.IsZero() {
+		if t, err := parseHTTPTime(since); err == nil && lastModified.After(t) {
+			return 412
+		}
+	}
+
+	safe := req.Method == "GET" || req.Method == "HEAD"
+
+	if ifNoneMatch, ok := req.Headers["If-None-Match"]; ok {
+		if etagListMatches(ifNoneMatch, etag, false) {
+			if safe {
+				return 304
+			}
+			return 412
+		}
+	} else if since := req.Headers["If-Modified-Since"]; since != "" && safe && !lastModified.IsZero() {
+		if t, err := parseHTTPTime(since); err == nil && !lastModified.After(t) {
+			return 304
+		}
+	}
+
+	return 0
+}
+
+// ConditionalResponse adds an ETag to a buffered 200 response and applies the
+// request's preconditions, turning it into a 304 or 412 when they say so.
+// Call it before CompressResponse: the tag is computed from the identity body
+// and made weak if the body is compressed afterwards.
+//
+// Usage:
+//
+//	resp := protocol.NewResponse(200, "OK", req.Version, body)
+//	ConditionalResponse(resp, req)
+//	CompressResponse(resp, req)
+//	return resp
+func ConditionalResponse(resp *protocol.Response, req *protocol.Request) {
+	if resp.StatusCode != 200 {
+		return
+	}
+
+	etag := resp.Headers["ETag"]
+	if etag == "" {
+		etag = contentETag(resp.Body)
+		resp.Headers["ETag"] = etag
+	}
+
+	var lastModified time.Time
+	if header := resp.Headers["Last-Modified"]; header != "" {
+		lastModified, _ = parseHTTPTime(header)
+	}
+
+	switch EvaluatePreconditions(req, etag, lastModified) {
+	case 304:
+		resp.StatusCode, resp.Status = 304, "Not Modified"
+		resp.Body = ""
+		delete(resp.Headers, "Content-Type")
+	case 412:
+		resp.StatusCode, resp.Status = 412, "Precondition Failed"
+		resp.Body = ""
+		delete(resp.Headers, "ETag")
+	}
+}
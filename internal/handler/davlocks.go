@@ -0,0 +1,477 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+)
+
+// davLock is a WebDAV write lock (RFC 4918 section 6)
+type davLock struct {
+	token    string
+	root     string // Locked path relative to the FileServer root
+	infinite bool   // Depth: infinity also locks everything below root
+	shared   bool
+	owner    string // Inner XML of <owner>, returned as is
+	timeout  time.Duration
+	expires  time.Time
+}
+
+// covers reports whether the lock applies to rel
+func (l *davLock) covers(rel string) bool {
+	return l.root == rel || (l.infinite && isBelow(rel, l.root))
+}
+
+// isBelow reports whether rel lies strictly inside dir
+func isBelow(rel, dir string) bool {
+	return (dir == "" && rel != "") || strings.HasPrefix(rel, dir+"/")
+}
+
+// lockStore holds the active locks in memory
+type lockStore struct {
+	mu    sync.Mutex
+	locks map[string]*davLock // By token
+}
+
+func newLockStore() *lockStore {
+	return &lockStore{locks: make(map[string]*davLock)}
+}
+
+// expireLocked drops timed-out locks; s.mu must be held
+func (s *lockStore) expireLocked(now time.Time) {
+	for token, l := range s.locks {
+		if now.After(l.expires) {
+			delete(s.locks, token)
+		}
+	}
+}
+
+// covering returns the locks on rel, on collections above it (depth
+// infinity) and, with below, on anything inside it
+func (s *lockStore) covering(rel string, below bool) []*davLock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked(time.Now())
+
+	var locks []*davLock
+	for _, l := range s.locks {
+		if l.covers(rel) || (below && isBelow(l.root, rel)) {
+			locks = append(locks, l)
+		}
+	}
+	return locks
+}
+
+// create adds l unless it conflicts with an existing lock: an exclusive lock
+// conflicts with any lock it overlaps, shared locks only with exclusive ones
+func (s *lockStore) create(l *davLock) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked(time.Now())
+
+	for _, existing := range s.locks {
+		overlaps := existing.covers(l.root) || (l.infinite && isBelow(existing.root, l.root))
+		if overlaps && (!l.shared || !existing.shared) {
+			return false
+		}
+	}
+	l.expires = time.Now().Add(l.timeout)
+	s.locks[l.token] = l
+	return true
+}
+
+// refresh restarts the timeout of the lock token if it covers rel
+func (s *lockStore) refresh(token, rel string, timeout time.Duration) (davLock, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireLocked(time.Now())
+
+	l, ok := s.locks[token]
+	if !ok || !l.covers(rel) {
+		return davLock{}, false
+	}
+	l.timeout = timeout
+	l.expires = time.Now().Add(timeout)
+	return *l, true
+}
+
+// remove deletes the lock token if it covers rel
+func (s *lockStore) remove(token, rel string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.locks[token]
+	if !ok || !l.covers(rel) {
+		return false
+	}
+	delete(s.locks, token)
+	return true
+}
+
+// removeUnder deletes the locks rooted at rel or inside it
+func (s *lockStore) removeUnder(rel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for token, l := range s.locks {
+		if l.root == rel || isBelow(l.root, rel) {
+			delete(s.locks, token)
+		}
+	}
+}
+
+// holds reports whether token is a lock covering rel
+func (s *lockStore) holds(token, rel string) bool {
+	for _, l := range s.covering(rel, false) {
+		if l.token == token {
+			return true
+		}
+	}
+	return false
+}
+
+// lockTarget is a resource a request modifies
+type lockTarget struct {
+	rel       string
+	recursive bool // Its members are modified too (DELETE, MOVE, COPY over a collection)
+	members   bool // It is created or removed, which changes its parent collection
+}
+
+// checkLocks evaluates the If header and makes sure the request submitted
+// the token of every lock protecting targets: 0 if it may proceed, 412 if
+// the If header is false, 423 if a lock token is missing
+func (fs *FileServer) checkLocks(req *protocol.Request, targets ...lockTarget) (int, string) {
+	if fs.dav == nil {
+		return 0, ""
+	}
+
+	lists, err := parseIfHeader(req.Headers["If"])
+	if err != nil {
+		return 400, "Bad Request"
+	}
+	if len(lists) > 0 && !fs.evaluateIf(req, lists) {
+		return 412, "Precondition Failed"
+	}
+
+	// Every token named in the If header counts as submitted (RFC 4918 section 10.4.1)
+	submitted := make(map[string]bool)
+	for _, list := range lists {
+		for _, cond := range list.conditions {
+			if cond.token != "" {
+				submitted[cond.token] = true
+			}
+		}
+	}
+
+	for _, target := range targets {
+		locks := fs.dav.locks.covering(target.rel, target.recursive)
+		if parent := path.Dir(target.rel); target.members && parent != target.rel {
+			// A depth 0 lock on the parent protects its list of members
+			for _, l := range fs.dav.locks.covering(parent, false) {
+				if l.root == parent && !l.infinite {
+					locks = append(locks, l)
+				}
+			}
+		}
+		for _, l := range locks {
+			if !submitted[l.token] {
+				return 423, "Locked"
+			}
+		}
+	}
+	return 0, ""
+}
+
+// ifCondition is one condition of an If header list
+type ifCondition struct {
+	not   bool
+	token string // State token ("opaquelocktoken:..."), or
+	etag  string // entity tag
+}
+
+// ifList is a parenthesized list of conditions, all of which must hold
+type ifList struct {
+	resource   string // Tagged resource URL ("" = the request URL)
+	conditions []ifCondition
+}
+
+// parseIfHeader parses the WebDAV If header (RFC 4918 section 10.4):
+//
+//	If: (<opaquelocktoken:a> ["etag"]) (Not <DAV:no-lock>)
+//	If: </static/a.txt> (<opaquelocktoken:a>)
+func parseIfHeader(header string) ([]ifList, error) {
+	var lists []ifList
+	resource := ""
+	s := strings.TrimSpace(header)
+
+	for s != "" {
+		switch s[0] {
+		case '<':
+			end := strings.IndexByte(s, '>')
+			if end < 0 {
+				return nil, errBadIfHeader
+			}
+			resource, s = s[1:end], s[end+1:]
+
+		case '(':
+			end := strings.IndexByte(s, ')')
+			if end < 0 {
+				return nil, errBadIfHeader
+			}
+			list := ifList{resource: resource}
+			conds := strings.TrimSpace(s[1:end])
+			s = s[end+1:]
+
+			for conds != "" {
+				var cond ifCondition
+				if len(conds) > 3 && strings.EqualFold(conds[:3], "Not") && (conds[3] == ' ' || conds[3] == '<' || conds[3] == '[') {
+					cond.not = true
+					conds = strings.TrimSpace(conds[3:])
+				}
+				var closing byte
+				switch {
+				case strings.HasPrefix(conds, "<"):
+					closing = '>'
+				case strings.HasPrefix(conds, "["):
+					closing = ']'
+				default:
+					return nil, errBadIfHeader
+				}
+				end := strings.IndexByte(conds, closing)
+				if end < 0 {
+					return nil, errBadIfHeader
+				}
+				if closing == '>' {
+					cond.token = conds[1:end]
+				} else {
+					cond.etag = conds[1:end]
+				}
+				conds = strings.TrimSpace(conds[end+1:])
+				list.conditions = append(list.conditions, cond)
+			}
+			if len(list.conditions) == 0 {
+				return nil, errBadIfHeader
+			}
+			lists = append(lists, list)
+
+		default:
+			return nil, errBadIfHeader
+		}
+		s = strings.TrimSpace(s)
+	}
+	return lists, nil
+}
+
+// errBadIfHeader means the If header couldn't be parsed (400)
+var errBadIfHeader = errors.New("malformed If header")
+
+// evaluateIf reports whether any list of the If header holds
+func (fs *FileServer) evaluateIf(req *protocol.Request, lists []ifList) bool {
+	for _, list := range lists {
+		rawPath := req.Path
+		if list.resource != "" {
+			u, err := url.Parse(list.resource)
+			if err != nil {
+				continue
+			}
+			rawPath = u.EscapedPath()
+		}
+		rel, err := cleanRequestPath(rawPath)
+		if err != nil {
+			continue
+		}
+
+		etag, _, _, _ := fs.currentVersion(rel)
+		holds := true
+		for _, cond := range list.conditions {
+			var match bool
+			if cond.token != "" {
+				match = fs.dav.locks.holds(cond.token, rel)
+			} else {
+				match = etag != "" && etagMatches(cond.etag, etag, true)
+			}
+			if match == cond.not {
+				holds = false
+				break
+			}
+		}
+		if holds {
+			return true
+		}
+	}
+	return false
+}
+
+// davLockInfo is a LOCK request body
+type davLockInfo struct {
+	XMLName   xml.Name `xml:"DAV: lockinfo"`
+	LockScope struct {
+		Exclusive *struct{} `xml:"DAV: exclusive"`
+		Shared    *struct{} `xml:"DAV: shared"`
+	} `xml:"DAV: lockscope"`
+	LockType struct {
+		Write *struct{} `xml:"DAV: write"`
+	} `xml:"DAV: locktype"`
+	Owner *struct {
+		InnerXML string `xml:",innerxml"`
+	} `xml:"DAV: owner"`
+}
+
+// serveLock creates a lock, or refreshes one when the body is empty
+func (fs *FileServer) serveLock(req *protocol.Request, conn *tcp.TCPConn, rel string, keepAlive bool, remainingRequests int) error {
+	timeout := fs.lockTimeout(req.Headers["Timeout"])
+
+	if strings.TrimSpace(req.Body) == "" {
+		// Refresh: the lock is named in the If header
+		lists, err := parseIfHeader(req.Headers["If"])
+		if err != nil || len(lists) == 0 {
+			return fs.sendError(conn, req, 400, "Bad Request", keepAlive, remainingRequests)
+		}
+		for _, list := range lists {
+			for _, cond := range list.conditions {
+				if cond.token == "" || cond.not {
+					continue
+				}
+				if l, ok := fs.dav.locks.refresh(cond.token, rel, timeout); ok {
+					return sendLockResponse(conn, req, &l, 200, "OK", keepAlive, remainingRequests)
+				}
+			}
+		}
+		return fs.sendError(conn, req, 412, "Precondition Failed", keepAlive, remainingRequests)
+	}
+
+	var info davLockInfo
+	if err := xml.Unmarshal([]byte(req.Body), &info); err != nil || info.LockType.Write == nil ||
+		(info.LockScope.Exclusive == nil) == (info.LockScope.Shared == nil) {
+		return fs.sendError(conn, req, 400, "Bad Request", keepAlive, remainingRequests)
+	}
+
+	l := &davLock{
+		token:    newLockToken(),
+		root:     rel,
+		infinite: true,
+		shared:   info.LockScope.Shared != nil,
+		timeout:  timeout,
+	}
+	if info.Owner != nil {
+		l.owner = info.Owner.InnerXML
+	}
+	switch depth := req.Headers["Depth"]; {
+	case depth == "0":
+		l.infinite = false
+	case depth != "" && !strings.EqualFold(depth, "infinity"):
+		return fs.sendError(conn, req, 400, "Bad Request", keepAlive, remainingRequests)
+	}
+
+	fs.writes.mu.Lock()
+	defer fs.writes.mu.Unlock()
+
+	// Locking an unmapped URL creates an empty file (RFC 4918 section 7.3)
+	_, _, _, err := fs.currentVersion(rel)
+	created := err != nil
+	if code, status := fs.checkLocks(req, lockTarget{rel: rel, members: created}); code == 400 || code == 412 {
+		return fs.sendError(conn, req, code, status, keepAlive, remainingRequests)
+	}
+	if !fs.dav.locks.create(l) {
+		return fs.sendDAVError(conn, req, 423, "Locked", "no-conflicting-lock", keepAlive, remainingRequests)
+	}
+
+	if created {
+		parent, err := openBeneath(fs.root, path.Dir(rel), fs.access.FollowSymlinks)
+		if err != nil {
+			fs.dav.locks.remove(l.token, rel)
+			return fs.sendError(conn, req, 409, "Conflict", keepAlive, remainingRequests)
+		}
+		file, err := createAt(parent, path.Base(rel))
+		parent.Close()
+		if err != nil {
+			fs.dav.locks.remove(l.token, rel)
+			return fs.sendError(conn, req, 409, "Conflict", keepAlive, remainingRequests)
+		}
+		file.Close()
+		return sendLockResponse(conn, req, l, 201, "Created", keepAlive, remainingRequests)
+	}
+	return sendLockResponse(conn, req, l, 200, "OK", keepAlive, remainingRequests)
+}
+
+// serveUnlock removes the lock named in the Lock-Token header
+func (fs *FileServer) serveUnlock(req *protocol.Request, conn *tcp.TCPConn, rel string, keepAlive bool, remainingRequests int) error {
+	token := strings.TrimSpace(req.Headers["Lock-Token"])
+	if !strings.HasPrefix(token, "<") || !strings.HasSuffix(token, ">") {
+		return fs.sendError(conn, req, 400, "Bad Request", keepAlive, remainingRequests)
+	}
+	if !fs.dav.locks.remove(token[1:len(token)-1], rel) {
+		return fs.sendDAVError(conn, req, 409, "Conflict", "lock-token-matches-request-uri", keepAlive, remainingRequests)
+	}
+	return sendWithConnection(conn, protocol.NewResponse(204, "No Content", req.Version, ""), keepAlive, remainingRequests)
+}
+
+// lockTimeout picks the first acceptable value of a Timeout header
+// ("Second-600, Infinite"), capped at the configured maximum
+func (fs *FileServer) lockTimeout(header string) time.Duration {
+	limit := fs.dav.opts.LockTimeout
+	for _, value := range strings.Split(header, ",") {
+		value = strings.TrimSpace(value)
+		if len(value) > 7 && strings.EqualFold(value[:7], "Second-") {
+			if seconds, err := strconv.ParseInt(value[7:], 10, 64); err == nil && seconds > 0 {
+				if timeout := time.Duration(seconds) * time.Second; timeout < limit {
+					return timeout
+				}
+				return limit
+			}
+		}
+	}
+	return limit
+}
+
+// newLockToken returns a random opaquelocktoken URI (a version 4 UUID)
+func newLockToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("opaquelocktoken:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// writeActiveLock writes the <activelock> element describing l
+func writeActiveLock(b *strings.Builder, l *davLock) {
+	scope, depth := "exclusive", "0"
+	if l.shared {
+		scope = "shared"
+	}
+	if l.infinite {
+		depth = "infinity"
+	}
+
+	b.WriteString("<D:activelock><D:locktype><D:write/></D:locktype>")
+	fmt.Fprintf(b, "<D:lockscope><D:%s/></D:lockscope><D:depth>%s</D:depth>", scope, depth)
+	if l.owner != "" {
+		fmt.Fprintf(b, "<D:owner>%s</D:owner>", l.owner)
+	}
+	fmt.Fprintf(b, "<D:timeout>Second-%d</D:timeout>", int64(l.timeout/time.Second))
+	fmt.Fprintf(b, "<D:locktoken><D:href>%s</D:href></D:locktoken>", escapeXML(l.token))
+	fmt.Fprintf(b, "<D:lockroot><D:href>%s</D:href></D:lockroot>", escapeXML(davHref(l.root, false)))
+	b.WriteString("</D:activelock>")
+}
+
+// sendLockResponse answers LOCK with the lock's lockdiscovery property
+func sendLockResponse(conn *tcp.TCPConn, req *protocol.Request, l *davLock, code int, status string, keepAlive bool, remainingRequests int) error {
+	var b strings.Builder
+	b.WriteString(xml.Header + `<D:prop xmlns:D="DAV:"><D:lockdiscovery>`)
+	writeActiveLock(&b, l)
+	b.WriteString("</D:lockdiscovery></D:prop>")
+
+	resp := protocol.NewResponse(code, status, req.Version, b.String())
+	resp.Headers["Content-Type"] = "application/xml; charset=utf-8"
+	resp.Headers["Lock-Token"] = "<" + l.token + ">"
+	return sendWithConnection(conn, resp, keepAlive, remainingRequests)
+}
@@ -0,0 +1,451 @@
+package handler
+
+import (
+	"encoding/xml"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+)
+
+// davTimeFormat is getlastmodified's date format; WebDAV clients insist on "GMT"
+const davTimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// davLiveProps are the properties computed from the file system, in the
+// order allprop returns them; clients can't change them with PROPPATCH
+var davLiveProps = []string{
+	"resourcetype", "displayname", "getcontentlength", "getcontenttype", "getetag",
+	"getlastmodified", "creationdate", "supportedlock", "lockdiscovery",
+}
+
+// davStatusText is the reason phrase of the statuses a multistatus reports
+var davStatusText = map[int]string{
+	200: "OK",
+	403: "Forbidden",
+	404: "Not Found",
+	424: "Failed Dependency",
+}
+
+// davNames collects the names of the elements inside <prop>
+type davNames []xml.Name
+
+func (n *davNames) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			*n = append(*n, t.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+// davPropfind is a PROPFIND request body; exactly one field is set
+type davPropfind struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     *davNames `xml:"DAV: prop"`
+}
+
+// davPropValue is a property as sent in PROPPATCH
+type davPropValue struct {
+	XMLName  xml.Name
+	InnerXML string `xml:",innerxml"`
+}
+
+// davPropertyUpdate is a PROPPATCH request body: <set> and <remove>
+// instructions, applied in document order
+type davPropertyUpdate struct {
+	XMLName      xml.Name `xml:"DAV: propertyupdate"`
+	Instructions []struct {
+		XMLName xml.Name
+		Prop    struct {
+			Values []davPropValue `xml:",any"`
+		} `xml:"DAV: prop"`
+	} `xml:",any"`
+}
+
+// propStore holds dead properties (set with PROPPATCH) in memory
+type propStore struct {
+	mu    sync.Mutex
+	props map[string]map[xml.Name]string // Path relative to the root -> name -> inner XML
+}
+
+func newPropStore() *propStore {
+	return &propStore{props: make(map[string]map[xml.Name]string)}
+}
+
+// get returns a copy of the dead properties of rel
+func (s *propStore) get(rel string) map[xml.Name]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	props := make(map[xml.Name]string, len(s.props[rel]))
+	for name, value := range s.props[rel] {
+		props[name] = value
+	}
+	return props
+}
+
+// patch sets the given properties of rel, or removes them if remove is set
+func (s *propStore) patch(rel string, values []davPropValue, remove []bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	props := s.props[rel]
+	if props == nil {
+		props = make(map[xml.Name]string)
+		s.props[rel] = props
+	}
+	for i, v := range values {
+		if remove[i] {
+			delete(props, v.XMLName)
+		} else {
+			props[v.XMLName] = v.InnerXML
+		}
+	}
+	if len(props) == 0 {
+		delete(s.props, rel)
+	}
+}
+
+// move renames the properties of src and everything below it to dst
+func (s *propStore) move(src, dst string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for rel, props := range s.props {
+		if rel == src || isBelow(rel, src) {
+			delete(s.props, rel)
+			s.props[dst+strings.TrimPrefix(rel, src)] = props
+		}
+	}
+}
+
+// copy duplicates the properties of src (and, with recursive, its members) to dst
+func (s *propStore) copy(src, dst string, recursive bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	copies := make(map[string]map[xml.Name]string)
+	for rel, props := range s.props {
+		if rel != src && !(recursive && isBelow(rel, src)) {
+			continue
+		}
+		dup := make(map[xml.Name]string, len(props))
+		for name, value := range props {
+			dup[name] = value
+		}
+		copies[dst+strings.TrimPrefix(rel, src)] = dup
+	}
+	for rel, props := range copies {
+		s.props[rel] = props
+	}
+}
+
+// remove drops the properties of rel and everything below it
+func (s *propStore) remove(rel string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key := range s.props {
+		if key == rel || isBelow(key, rel) {
+			delete(s.props, key)
+		}
+	}
+}
+
+// servePropfind reports properties of rel and, with "Depth: 1", its members
+// "Depth: infinity" (also the default) is refused, as RFC 4918 allows, so a
+// single request can't walk the whole tree.
+func (fs *FileServer) servePropfind(req *protocol.Request, conn *tcp.TCPConn, rel string, keepAlive bool, remainingRequests int) error {
+	depth := req.Headers["Depth"]
+	if depth != "0" && depth != "1" {
+		return fs.sendDAVError(conn, req, 403, "Forbidden", "propfind-finite-depth", keepAlive, remainingRequests)
+	}
+
+	// An empty body means allprop
+	var propfind davPropfind
+	if strings.TrimSpace(req.Body) != "" {
+		err := xml.Unmarshal([]byte(req.Body), &propfind)
+		if err != nil || (propfind.AllProp == nil && propfind.PropName == nil && propfind.Prop == nil) {
+			return fs.sendError(conn, req, 400, "Bad Request", keepAlive, remainingRequests)
+		}
+	}
+
+	file, info, err := fs.open(rel)
+	if err != nil {
+		code, status := openErrorStatus(err)
+		return fs.sendError(conn, req, code, status, keepAlive, remainingRequests)
+	}
+	defer file.Close()
+
+	ms := newMultistatus()
+	fs.writePropfind(ms, &propfind, rel, file, info)
+
+	if depth == "1" && info.IsDir() {
+		readDir, ok := file.(iofs.ReadDirFile)
+		if !ok {
+			return fs.sendError(conn, req, 500, "Internal Server Error", keepAlive, remainingRequests)
+		}
+		entries, err := readDir.ReadDir(-1)
+		if err != nil {
+			return fs.sendError(conn, req, 500, "Internal Server Error", keepAlive, remainingRequests)
+		}
+		for _, entry := range entries {
+			// Members are opened like GET would open them, so hidden names,
+			// blocked extensions and escaping symlinks are left out
+			childRel := path.Join(rel, entry.Name())
+			child, childInfo, err := fs.open(childRel)
+			if err != nil {
+				continue
+			}
+			fs.writePropfind(ms, &propfind, childRel, child, childInfo)
+			child.Close()
+		}
+	}
+
+	return ms.send(conn, req, keepAlive, remainingRequests)
+}
+
+// writePropfind adds the response for one resource to ms
+func (fs *FileServer) writePropfind(ms *multistatus, propfind *davPropfind, rel string, file staticFile, info os.FileInfo) {
+	dead := fs.dav.props.get(rel)
+	var found, missing []davProp
+
+	switch {
+	case propfind.PropName != nil:
+		for _, local := range davLiveProps {
+			if _, ok := fs.liveProp(local, rel, file, info); ok {
+				found = append(found, davProp{name: xml.Name{Space: "DAV:", Local: local}})
+			}
+		}
+		for name := range dead {
+			found = append(found, davProp{name: name})
+		}
+
+	case propfind.Prop != nil:
+		for _, name := range *propfind.Prop {
+			value, ok := dead[name]
+			if name.Space == "DAV:" && isLiveProp(name.Local) {
+				value, ok = fs.liveProp(name.Local, rel, file, info)
+			}
+			if ok {
+				found = append(found, davProp{name: name, value: value})
+			} else {
+				missing = append(missing, davProp{name: name})
+			}
+		}
+
+	default: // allprop
+		for _, local := range davLiveProps {
+			if value, ok := fs.liveProp(local, rel, file, info); ok {
+				found = append(found, davProp{name: xml.Name{Space: "DAV:", Local: local}, value: value})
+			}
+		}
+		for name, value := range dead {
+			found = append(found, davProp{name: name, value: value})
+		}
+	}
+
+	ms.response(davHref(rel, info.IsDir()), propstat{200, found}, propstat{404, missing})
+}
+
+// isLiveProp reports whether DAV:local is computed by the server
+func isLiveProp(local string) bool {
+	for _, live := range davLiveProps {
+		if local == live {
+			return true
+		}
+	}
+	return false
+}
+
+// liveProp returns the value (as XML) of the live property DAV:local of rel
+// Collections have no content length, type or ETag.
+func (fs *FileServer) liveProp(local, rel string, file staticFile, info os.FileInfo) (string, bool) {
+	isFile := info.Mode().IsRegular()
+
+	switch local {
+	case "resourcetype":
+		if info.IsDir() {
+			return "<D:collection/>", true
+		}
+		return "", true
+	case "displayname":
+		return escapeXML(path.Base("/" + rel)), true
+	case "getcontentlength":
+		return fmt.Sprintf("%d", info.Size()), isFile
+	case "getcontenttype":
+		if !isFile {
+			return "", false
+		}
+		return escapeXML(contentTypeOf(rel, file)), true
+	case "getetag":
+		if !isFile {
+			return "", false
+		}
+		etag, err := fs.etag(file, rel, info)
+		return escapeXML(etag), err == nil
+	case "getlastmodified":
+		return info.ModTime().UTC().Format(davTimeFormat), true
+	case "creationdate":
+		return info.ModTime().UTC().Format(time.RFC3339), true // Linux keeps no portable birth time
+	case "supportedlock":
+		return "<D:lockentry><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>" +
+			"<D:lockentry><D:lockscope><D:shared/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockentry>", true
+	case "lockdiscovery":
+		var b strings.Builder
+		for _, l := range fs.dav.locks.covering(rel, false) {
+			writeActiveLock(&b, l)
+		}
+		return b.String(), true
+	}
+	return "", false
+}
+
+// serveProppatch sets and removes dead properties of rel
+// The update is atomic: if any property can't be changed, none are.
+func (fs *FileServer) serveProppatch(req *protocol.Request, conn *tcp.TCPConn, rel string, keepAlive bool, remainingRequests int) error {
+	var update davPropertyUpdate
+	if err := xml.Unmarshal([]byte(req.Body), &update); err != nil {
+		return fs.sendError(conn, req, 400, "Bad Request", keepAlive, remainingRequests)
+	}
+
+	var values []davPropValue
+	var remove []bool
+	for _, instruction := range update.Instructions {
+		if instruction.XMLName.Space != "DAV:" || (instruction.XMLName.Local != "set" && instruction.XMLName.Local != "remove") {
+			return fs.sendError(conn, req, 400, "Bad Request", keepAlive, remainingRequests)
+		}
+		for _, v := range instruction.Prop.Values {
+			values = append(values, v)
+			remove = append(remove, instruction.XMLName.Local == "remove")
+		}
+	}
+
+	fs.writes.mu.Lock()
+	defer fs.writes.mu.Unlock()
+
+	_, _, info, err := fs.currentVersion(rel)
+	if err != nil {
+		code, status := openErrorStatus(err)
+		return fs.sendError(conn, req, code, status, keepAlive, remainingRequests)
+	}
+	if code, status := fs.checkLocks(req, lockTarget{rel: rel}); code != 0 {
+		return fs.sendError(conn, req, code, status, keepAlive, remainingRequests)
+	}
+
+	var ok, forbidden []davProp
+	for _, v := range values {
+		if v.XMLName.Space == "DAV:" && isLiveProp(v.XMLName.Local) {
+			forbidden = append(forbidden, davProp{name: v.XMLName})
+		} else {
+			ok = append(ok, davProp{name: v.XMLName})
+		}
+	}
+
+	ms := newMultistatus()
+	if len(forbidden) > 0 {
+		ms.response(davHref(rel, info.IsDir()), propstat{403, forbidden}, propstat{424, ok})
+	} else {
+		fs.dav.props.patch(rel, values, remove)
+		ms.response(davHref(rel, info.IsDir()), propstat{200, ok})
+	}
+	return ms.send(conn, req, keepAlive, remainingRequests)
+}
+
+// davProp is a property name with its value as XML ("" for none)
+type davProp struct {
+	name  xml.Name
+	value string
+}
+
+// propstat groups properties sharing a status
+type propstat struct {
+	code  int
+	props []davProp
+}
+
+// multistatus builds a 207 Multi-Status body
+type multistatus struct {
+	b strings.Builder
+}
+
+func newMultistatus() *multistatus {
+	ms := &multistatus{}
+	ms.b.WriteString(xml.Header + `<D:multistatus xmlns:D="DAV:">`)
+	return ms
+}
+
+// response adds the propstats of one resource; empty ones are left out
+func (ms *multistatus) response(href string, propstats ...propstat) {
+	fmt.Fprintf(&ms.b, "<D:response><D:href>%s</D:href>", escapeXML(href))
+	for _, ps := range propstats {
+		if len(ps.props) == 0 {
+			continue
+		}
+		ms.b.WriteString("<D:propstat><D:prop>")
+		for _, p := range ps.props {
+			writeProp(&ms.b, p.name, p.value)
+		}
+		fmt.Fprintf(&ms.b, "</D:prop><D:status>HTTP/1.1 %d %s</D:status></D:propstat>", ps.code, davStatusText[ps.code])
+	}
+	ms.b.WriteString("</D:response>")
+}
+
+// send writes the multistatus as a 207 response
+func (ms *multistatus) send(conn *tcp.TCPConn, req *protocol.Request, keepAlive bool, remainingRequests int) error {
+	ms.b.WriteString("</D:multistatus>")
+
+	resp := protocol.NewResponse(207, "Multi-Status", req.Version, ms.b.String())
+	resp.Headers["Content-Type"] = "application/xml; charset=utf-8"
+	return sendWithConnection(conn, resp, keepAlive, remainingRequests)
+}
+
+// writeProp writes one property element; DAV: properties use the D prefix,
+// others declare their namespace on the element itself
+func writeProp(b *strings.Builder, name xml.Name, value string) {
+	element, attrs := "D:"+name.Local, ""
+	switch name.Space {
+	case "DAV:":
+	case "":
+		element, attrs = name.Local, ` xmlns=""`
+	default:
+		element, attrs = "R:"+name.Local, ` xmlns:R="`+escapeXML(name.Space)+`"`
+	}
+
+	if value == "" {
+		fmt.Fprintf(b, "<%s%s/>", element, attrs)
+		return
+	}
+	fmt.Fprintf(b, "<%s%s>%s</%s>", element, attrs, value, element)
+}
+
+// sendDAVError sends an error with a precondition element (RFC 4918 section 16)
+func (fs *FileServer) sendDAVError(conn *tcp.TCPConn, req *protocol.Request, code int, status, condition string, keepAlive bool, remainingRequests int) error {
+	body := fmt.Sprintf(`%s<D:error xmlns:D="DAV:"><D:%s/></D:error>`, xml.Header, condition)
+	resp := protocol.NewResponse(code, status, req.Version, body)
+	resp.Headers["Content-Type"] = "application/xml; charset=utf-8"
+	return sendWithConnection(conn, resp, keepAlive, remainingRequests)
+}
+
+// escapeXML escapes s for use as XML character data or an attribute value
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
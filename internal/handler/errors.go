@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"webserver/internal/protocol"
+	"webserver/internal/router"
+)
+
+// errorPageData is the data passed to error page templates
+type errorPageData struct {
+	Code   int
+	Status string
+	Path   string
+}
+
+// TemplateErrorHandler renders HTML error pages from a template directory
+// For a 404 it looks for "<dir>/404.html", then falls back to "<dir>/error.html".
+// Templates receive .Code, .Status and .Path. If no template can be rendered,
+// the router's plain-text error body is used instead.
+func TemplateErrorHandler(dir string) router.ErrorHandlerFunc {
+	return TemplateErrorHandlerFS(os.DirFS(dir))
+}
+
+// TemplateErrorHandlerFS is TemplateErrorHandler for templates in an fs.FS
+// (e.g. the errors directory of embedded assets)
+func TemplateErrorHandlerFS(fsys fs.FS) router.ErrorHandlerFunc {
+	// Parse templates once at startup (missing files simply mean "no template")
+	pages := make(map[string]*template.Template)
+	if files, err := fs.Glob(fsys, "*.html"); err == nil {
+		for _, file := range files {
+			tmpl, err := template.ParseFS(fsys, file)
+			if err != nil {
+				log.Printf("error page template %s: %v", file, err)
+				continue
+			}
+			pages[strings.TrimSuffix(path.Base(file), ".html")] = tmpl
+		}
+	}
+
+	return func(req *protocol.Request, code int, status string) *protocol.Response {
+		tmpl, ok := pages[strconv.Itoa(code)]
+		if !ok {
+			tmpl, ok = pages["error"]
+		}
+		if !ok {
+			return router.DefaultErrorHandler(req, code, status)
+		}
+
+		var buf bytes.Buffer
+		data := errorPageData{Code: code, Status: status, Path: strings.Split(req.Path, "?")[0]}
+		if err := tmpl.Execute(&buf, data); err != nil {
+			log.Printf("error page template for %d: %v", code, err)
+			return router.DefaultErrorHandler(req, code, status)
+		}
+
+		resp := protocol.NewResponse(code, status, req.Version, buf.String())
+		resp.Headers["Content-Type"] = "text/html; charset=utf-8"
+		return resp
+	}
+}
+
+// problemDetails is an RFC 9457 problem+json body
+type problemDetails struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Instance string `json:"instance"`
+}
+
+// ProblemJSONErrorHandler renders errors as application/problem+json (RFC 9457)
+// Intended for API routes, e.g. r.RegisterErrorHandler("/api/*", 0, ProblemJSONErrorHandler)
+func ProblemJSONErrorHandler(req *protocol.Request, code int, status string) *protocol.Response {
+	problem := problemDetails{
+		Type:     "about:blank",
+		Title:    status,
+		Status:   code,
+		Instance: strings.Split(req.Path, "?")[0],
+	}
+
+	body, err := json.Marshal(problem)
+	if err != nil {
+		return router.DefaultErrorHandler(req, code, status)
+	}
+
+	resp := protocol.NewResponse(code, status, req.Version, string(body))
+	resp.Headers["Content-Type"] = "application/problem+json"
+	return resp
+}
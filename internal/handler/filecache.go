@@ -0,0 +1,416 @@
+package handler
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+)
+
+// DefaultFileCacheSize is a reasonable memory budget for SiteConfig.FileCacheSize
+const DefaultFileCacheSize = 32 * 1024 * 1024 // 32MB
+
+// fileEntry is one file held in memory by FileCache
+// content and the validators never change; variants fill in as clients ask
+// for encodings (nil = compression didn't help, send identity).
+type fileEntry struct {
+	rel         string // Request path relative to the root
+	realRel     string // Same file with symlinks resolved, for invalidation
+	content     []byte
+	contentType string
+	etag        string
+	modTime     time.Time
+	variants    map[string][]byte
+}
+
+// size is what the entry costs against the budget
+func (e *fileEntry) size() int64 {
+	size := int64(len(e.content))
+	for _, body := range e.variants {
+		size += int64(len(body))
+	}
+	return size
+}
+
+// FileCache keeps small, frequently requested files of a FileServer root in
+// memory: contents, Content-Type, ETag and compressed variants. A hit costs
+// no syscalls at all. Entries are invalidated through inotify as soon as the
+// file, or any directory on its path, changes; misses fall back to disk.
+// Least recently used files are evicted to stay within the byte budget.
+// Safe for concurrent use.
+type FileCache struct {
+	root    string // Root with symlinks resolved, to relate /proc/self/fd targets to it
+	budget  int64
+	watcher *inotifyWatcher
+
+	mu         sync.Mutex
+	used       int64
+	order      *list.List               // Front = most recently used, values are *fileEntry
+	entries    map[string]*list.Element // rel -> element
+	generation uint64                   // Bumped by every invalidation
+	stats      CacheStats
+}
+
+// newFileCache creates a cache for root and starts watching it
+func newFileCache(root string, budget int64) (*FileCache, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	if realRoot, err = filepath.Abs(realRoot); err != nil {
+		return nil, err
+	}
+
+	c := &FileCache{
+		root:    realRoot,
+		budget:  budget,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	if c.watcher, err = newInotifyWatcher(realRoot, c.invalidate, c.Flush); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// EnableFileCache keeps hot files under the root in memory, up to budget bytes
+// Only files up to MaxInMemorySize are cached. Has no effect on servers
+// created with NewFileServerFS, whose files are already in memory. Fails if
+// inotify is unavailable, in which case every request keeps going to disk.
+func (fs *FileServer) EnableFileCache(budget int64) error {
+	if fs.fsys != nil {
+		return nil
+	}
+	cache, err := newFileCache(fs.root, budget)
+	if err != nil {
+		return fmt.Errorf("file cache for %s: %w", fs.root, err)
+	}
+	if fs.files != nil {
+		fs.files.Close()
+	}
+	fs.files = cache
+	return nil
+}
+
+// FileCache returns the cache enabled with EnableFileCache (nil if none)
+func (fs *FileServer) FileCache() *FileCache {
+	return fs.files
+}
+
+// Close stops watching the root and drops every entry
+func (c *FileCache) Close() error {
+	c.Flush()
+	return c.watcher.Close()
+}
+
+// Flush drops every entry (used when inotify events were lost)
+func (c *FileCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stats.Invalidations += uint64(len(c.entries))
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.used = 0
+	c.generation++
+}
+
+// Stats returns the current metrics
+func (c *FileCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	stats.Bytes = c.used
+	stats.Budget = c.budget
+	return stats
+}
+
+// get returns the entry for rel and marks it recently used
+func (c *FileCache) get(rel string) *fileEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, found := c.entries[rel]
+	if !found {
+		c.stats.Misses++
+		return nil
+	}
+
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*fileEntry)
+}
+
+// currentGeneration is read before a file is opened and passed to put, so a
+// change that lands while the file is being read keeps it out of the cache
+func (c *FileCache) currentGeneration() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// put stores an entry read from disk, unless anything was invalidated since
+// generation or a directory on its path is not watched
+func (c *FileCache) put(entry *fileEntry, generation uint64) {
+	if entry.size() > c.budget {
+		return
+	}
+	if !c.watcher.watched(path.Dir("/" + entry.rel)[1:]) || !c.watcher.watched(path.Dir("/" + entry.realRel)[1:]) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return
+	}
+	if elem, found := c.entries[entry.rel]; found {
+		c.remove(elem)
+	}
+	c.entries[entry.rel] = c.order.PushFront(entry)
+	c.used += entry.size()
+	c.evict()
+}
+
+// putVariant stores a compressed body (nil = not worth compressing) of a cached entry
+// Entries are shared by concurrent requests, so variants are copied on write.
+func (c *FileCache) putVariant(entry *fileEntry, encoding string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, found := c.entries[entry.rel]
+	if !found {
+		return // Invalidated meanwhile
+	}
+	current := elem.Value.(*fileEntry)
+	if current.etag != entry.etag || !current.modTime.Equal(entry.modTime) {
+		return // Replaced by a newer version
+	}
+	if _, exists := current.variants[encoding]; exists {
+		return // Another request got there first
+	}
+
+	updated := *current
+	updated.variants = make(map[string][]byte, len(current.variants)+1)
+	for name, variant := range current.variants {
+		updated.variants[name] = variant
+	}
+	updated.variants[encoding] = body
+
+	elem.Value = &updated
+	c.used += int64(len(body))
+	c.evict()
+}
+
+// invalidate drops rel and everything below it, matching both request paths
+// and symlink targets (a change to "static/real/app.js" also drops
+// "static/link/app.js")
+func (c *FileCache) invalidate(rel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for key, elem := range c.entries {
+		entry := elem.Value.(*fileEntry)
+		if underPath(entry.rel, rel) || underPath(entry.realRel, rel) {
+			c.remove(elem)
+			delete(c.entries, key)
+			c.stats.Invalidations++
+		}
+	}
+}
+
+// evict drops least recently used entries until the budget is met (caller holds the lock)
+func (c *FileCache) evict() {
+	for c.used > c.budget {
+		oldest := c.order.Back()
+		if oldest == nil {
+			return
+		}
+		delete(c.entries, oldest.Value.(*fileEntry).rel)
+		c.remove(oldest)
+		c.stats.Evictions++
+	}
+}
+
+// remove unlinks an element from the LRU list (caller holds the lock and
+// deletes the map entry)
+func (c *FileCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	c.used -= elem.Value.(*fileEntry).size()
+}
+
+// realPath returns the path of an open file relative to the cache root, with
+// symlinks resolved, or false if it can't be determined
+func (c *FileCache) realPath(file staticFile) (string, bool) {
+	f, ok := file.(*os.File)
+	if !ok {
+		return "", false
+	}
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return "", false
+	}
+
+	var target string
+	conn.Control(func(fd uintptr) {
+		target, err = os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+	})
+	if err != nil {
+		return "", false
+	}
+
+	rel, err := filepath.Rel(c.root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// underPath reports whether rel is dir or inside it ("" is the whole root)
+func underPath(rel, dir string) bool {
+	return dir == "" || rel == dir || strings.HasPrefix(rel, dir+"/")
+}
+
+// cacheFile reads a small file into the cache and returns its entry
+// Returns nil if the file can't be cached; the caller then serves it from disk.
+func (fs *FileServer) cacheFile(file staticFile, rel string, info os.FileInfo, generation uint64) *fileEntry {
+	if info.Size() > MaxInMemorySize {
+		return nil
+	}
+	realRel, ok := fs.files.realPath(file)
+	if !ok {
+		return nil
+	}
+
+	content, err := readAll(file)
+	if err != nil || int64(len(content)) != info.Size() {
+		return nil // Changed while being read
+	}
+	etag, err := fs.etag(file, rel, info)
+	if err != nil {
+		return nil
+	}
+
+	entry := &fileEntry{
+		rel:         rel,
+		realRel:     realRel,
+		content:     content,
+		contentType: contentTypeOf(rel, bytes.NewReader(content)),
+		etag:        etag,
+		modTime:     info.ModTime(),
+	}
+	fs.files.put(entry, generation)
+	return entry
+}
+
+// variant returns the body to send for enc: a compressed copy of the entry,
+// computed (or read from an up-to-date sidecar) on first use, or nil to send
+// the identity body
+func (fs *FileServer) variant(entry *fileEntry, enc Encoder) []byte {
+	if body, found := entry.variants[enc.Name()]; found {
+		return body
+	}
+
+	body := fs.sidecarFor(entry, enc)
+	if body == nil {
+		if compressed, err := compressContent(enc, entry.content); err == nil && len(compressed) < len(entry.content) {
+			body = compressed
+		}
+	}
+
+	fs.files.putVariant(entry, enc.Name(), body)
+	return body
+}
+
+// sidecarFor reads the precompressed sidecar of entry for enc, if it is up to date
+func (fs *FileServer) sidecarFor(entry *fileEntry, enc Encoder) []byte {
+	ext, ok := sidecarExtensions[enc.Name()]
+	if !ok {
+		return nil
+	}
+	file, info, err := fs.open(entry.rel + ext)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	if !info.Mode().IsRegular() || info.ModTime().Before(entry.modTime) || info.Size() > MaxInMemorySize {
+		return nil
+	}
+	body, err := readAll(file)
+	if err != nil {
+		return nil
+	}
+	return body
+}
+
+// serveCached answers a request for a regular file held in the file cache
+// It mirrors the disk path: preconditions, ranges, then the negotiated encoding.
+func (fs *FileServer) serveCached(entry *fileEntry, req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+	size := int64(len(entry.content))
+	version := req.Version
+
+	switch EvaluatePreconditions(req, entry.etag, entry.modTime) {
+	case 304:
+		tag := representationETag(req, entry.etag, entry.contentType, size)
+		return fs.sendNotModified(conn, entry.rel, entry.modTime, tag, version, keepAlive, remainingRequests)
+	case 412:
+		return fs.sendError(conn, req, 412, "Precondition Failed", keepAlive, remainingRequests)
+	}
+
+	ranges, err := requestedRanges(req, entry.etag, entry.modTime, size)
+	if err != nil {
+		return fs.sendRangeNotSatisfiable(conn, size, version, keepAlive, remainingRequests)
+	}
+	switch {
+	case len(ranges) == 1:
+		return fs.sendRangeFile(bytes.NewReader(entry.content), ranges[0].start, ranges[0].end, entry.rel, entry.contentType, size, entry.modTime, entry.etag, version, conn, keepAlive, remainingRequests)
+	case len(ranges) > 1:
+		return fs.sendMultipartRanges(bytes.NewReader(entry.content), ranges, entry.rel, entry.contentType, size, entry.modTime, entry.etag, version, conn, keepAlive, remainingRequests)
+	}
+
+	resp := protocol.NewResponse(200, "OK", version, "")
+	resp.Headers["Content-Type"] = entry.contentType
+	resp.Headers["X-Content-Type-Options"] = "nosniff"
+	resp.Headers["Accept-Ranges"] = "bytes"
+	resp.Headers["Last-Modified"] = entry.modTime.UTC().Format(httpTimeFormat)
+	resp.Headers["ETag"] = entry.etag
+	fs.cachePolicy.apply(resp.Headers, entry.rel)
+	resp.Headers["Date"] = time.Now().UTC().Format(httpTimeFormat)
+	resp.Headers["Server"] = "GoWebServer/1.0"
+
+	// Set Connection headers for keep-alive
+	if keepAlive {
+		resp.Headers["Connection"] = "keep-alive"
+		resp.Headers["Keep-Alive"] = fmt.Sprintf("timeout=30, max=%d", remainingRequests)
+	} else {
+		resp.Headers["Connection"] = "close"
+	}
+
+	body := entry.content
+	if shouldCompress(entry.contentType) {
+		resp.Headers["Vary"] = "Accept-Encoding"
+		if enc := negotiateEncoder(req.Headers["Accept-Encoding"]); enc != nil && size >= minSizeForCompression {
+			if compressed := fs.variant(entry, enc); compressed != nil {
+				body = compressed
+				resp.Headers["Content-Encoding"] = enc.Name()
+				resp.Headers["ETag"] = weakETag(entry.etag)
+			}
+		}
+	}
+	resp.Body = string(body)
+
+	return writeBufferedResponse(conn, resp)
+}
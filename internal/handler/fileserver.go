@@ -1,15 +1,18 @@
 package handler
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"mime"
+	iofs "io/fs"
 	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
+	"path"
+	"syscall"
 	"time"
 	"webserver/internal/protocol"
+	"webserver/internal/router"
 	"webserver/internal/tcp"
 )
 
@@ -17,71 +20,122 @@ import (
 // Files larger than 1MB will be streamed to save memory
 const MaxInMemorySize = 1024 * 1024 // 1MB
 
+// streamChunkSize is the largest chunk written when streaming compressed files
+const streamChunkSize = 32 * 1024 // 32KB
+
 // FileServer serves static files from a directory
 type FileServer struct {
-	root string // Root directory for static files
+	root         string                  // Root directory for static files
+	errorHandler router.ErrorHandlerFunc // Renders error pages (nil = plain text)
+	cache        *CompressionCache       // Compressed small files (nil = compress every hit)
+	etagMode     ETagMode                // How strong ETags are derived
+	listing      *ListingOptions         // Directory listings (nil = 403 for directories without index.html)
+	spa          *SPAOptions             // Index served for client-side routes (nil = 404 for missing files)
+	archives     bool                    // Directories download as zip/tar.gz with ?download=
+	access       AccessPolicy            // Symlink, dotfile and extension rules
+	cachePolicy  CachePolicy             // Cache-Control, Expires and extra headers per path
+
+	fsys     iofs.FS                   // Serve from this file system instead of root (NewFileServerFS)
+	loadedAt time.Time                 // Modification time for fsys files that have none
+	assets   map[string]*embeddedAsset // ETags and compressed variants from PrecomputeAssets
+
+	files  *FileCache  // Hot files held in memory (nil = always read from disk)
+	writes *writeState // PUT/DELETE/MKCOL (nil = read-only)
+	dav    *davState   // PROPFIND, LOCK, COPY, MOVE... (nil = plain uploads only)
+	images *imageState // Resized images (nil = endpoint disabled)
 }
 
 // NewFileServer creates a new file server with the given root directory
 func NewFileServer(root string) *FileServer {
 	return &FileServer{
-		root: root,
+		root:        root,
+		access:      DefaultAccessPolicy(),
+		cachePolicy: DefaultCachePolicy(),
 	}
 }
 
+// SetAccessPolicy replaces the symlink, dotfile and blocked-extension rules
+func (fs *FileServer) SetAccessPolicy(policy AccessPolicy) {
+	fs.access = policy
+	if fs.files != nil {
+		fs.files.Flush() // Entries were admitted under the old rules
+	}
+}
+
+// SetErrorHandler sets the renderer used for 4xx/5xx responses
+// Pass the router's Error method so static errors match the rest of the site
+func (fs *FileServer) SetErrorHandler(handler router.ErrorHandlerFunc) {
+	fs.errorHandler = handler
+}
+
+// SetCompressionCache enables caching of compressed small files
+// The cache may be shared between file servers.
+func (fs *FileServer) SetCompressionCache(cache *CompressionCache) {
+	fs.cache = cache
+}
+
+// CompressionCache returns the cache set with SetCompressionCache (nil if none)
+func (fs *FileServer) CompressionCache() *CompressionCache {
+	return fs.cache
+}
+
+// SetETagMode chooses between file-info (default) and content-hash ETags
+func (fs *FileServer) SetETagMode(mode ETagMode) {
+	fs.etagMode = mode
+}
+
 // ServeFile serves a static file (used for small files via Response object)
 // This method is kept as a fallback for the current router architecture
 // which expects handlers to return *protocol.Response objects
 func (fs *FileServer) ServeFile(req *protocol.Request) *protocol.Response {
-	// Remove any query parameters from the path
-	path := strings.Split(req.Path, "?")[0]
-
-	// Clean the path to prevent directory traversal attacks
-	cleanPath := filepath.Clean(path)
-
-	// Prevent directory traversal outside root
-	if strings.Contains(cleanPath, "..") {
-		return protocol.NewResponse(403, "Forbidden", req.Version, "403 - Forbidden")
+	// Decode and clean the path; ".." can no longer leave the root
+	rel, err := cleanRequestPath(req.Path)
+	if err != nil {
+		return fs.errorResponse(req, 400, "Bad Request")
 	}
 
-	// Build full file path
-	filePath := filepath.Join(fs.root, cleanPath)
-
-	// Check if file exists
-	fileInfo, err := os.Stat(filePath)
+	// Open the file confined to the root (symlinks cannot escape it)
+	file, fileInfo, err := fs.open(rel)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return protocol.NewResponse(404, "Not Found", req.Version, "404 - File Not Found")
+		code, status := openErrorStatus(err)
+		if code == 404 {
+			if resp := fs.spaFallback(req); resp != nil {
+				return resp
+			}
 		}
-		return protocol.NewResponse(500, "Internal Server Error", req.Version, "500 - Internal Server Error")
+		return fs.errorResponse(req, code, status)
 	}
+	defer func() { file.Close() }()
 
 	// If it's a directory, try to serve index.html
 	if fileInfo.IsDir() {
-		indexPath := filepath.Join(filePath, "index.html")
-		if _, err := os.Stat(indexPath); err == nil {
-			filePath = indexPath
-		} else {
+		index, indexInfo, err := fs.open(path.Join(rel, "index.html"))
+		if err != nil {
 			// Directory listing disabled for security
-			return protocol.NewResponse(403, "Forbidden", req.Version, "403 - Directory listing disabled")
+			return fs.errorResponse(req, 403, "Forbidden")
 		}
+		file.Close()
+		file, fileInfo, rel = index, indexInfo, path.Join(rel, "index.html")
+	}
+	if !fileInfo.Mode().IsRegular() {
+		return fs.errorResponse(req, 404, "Not Found")
 	}
 
 	// Read file content
-	content, err := os.ReadFile(filePath)
+	content, err := readAll(file)
 	if err != nil {
-		return protocol.NewResponse(500, "Internal Server Error", req.Version, "500 - Error reading file")
+		return fs.errorResponse(req, 500, "Internal Server Error")
 	}
 
 	// Create response
 	resp := protocol.NewResponse(200, "OK", req.Version, string(content))
 
-	// Set Content-Type based on file extension
-	contentType := getContentType(filePath)
-	resp.Headers["Content-Type"] = contentType
+	// Set Content-Type from the extension, or the content for unknown ones
+	resp.Headers["Content-Type"] = contentTypeOf(rel, file)
+	resp.Headers["X-Content-Type-Options"] = "nosniff"
 
 	// Add cache control headers
-	resp.Headers["Cache-Control"] = "public, max-age=3600"
+	fs.cachePolicy.apply(resp.Headers, rel)
 
 	return resp
 }
@@ -90,57 +144,93 @@ func (fs *FileServer) ServeFile(req *protocol.Request) *protocol.Response {
 // Small files (<1MB): Loaded in memory for speed
 // Large files (>1MB): Streamed to save memory
 func (fs *FileServer) ServeFileStream(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
-	// Remove any query parameters from the path
-	path := strings.Split(req.Path, "?")[0]
-
-	// Clean the path to prevent directory traversal attacks
-	cleanPath := filepath.Clean(path)
-
-	// Prevent directory traversal outside root
-	if strings.Contains(cleanPath, "..") {
-		return fs.sendError(conn, 403, "Forbidden", req.Version, keepAlive, remainingRequests)
+	// Decode and clean the path; ".." can no longer leave the root
+	rel, err := cleanRequestPath(req.Path)
+	if err != nil {
+		return fs.sendError(conn, req, 400, "Bad Request", keepAlive, remainingRequests)
 	}
 
-	// Build full file path
-	filePath := filepath.Join(fs.root, cleanPath)
+	// Hot files come straight from memory: no open, stat or read
+	var generation uint64
+	if fs.files != nil && fs.access.allowed(rel) {
+		if entry := fs.files.get(rel); entry != nil {
+			return fs.serveCached(entry, req, conn, keepAlive, remainingRequests)
+		}
+		generation = fs.files.currentGeneration()
+	}
 
-	// Check if file exists
-	fileInfo, err := os.Stat(filePath)
+	// Open the file confined to the root: symlinks cannot escape it, and
+	// dotfiles or blocked extensions look like missing files
+	file, fileInfo, err := fs.open(rel)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return fs.sendError(conn, 404, "Not Found", req.Version, keepAlive, remainingRequests)
+		code, status := openErrorStatus(err)
+		if code == 404 {
+			// Client-side routes of a single-page app get its index.html
+			if resp := fs.spaFallback(req); resp != nil {
+				return sendWithConnection(conn, resp, keepAlive, remainingRequests)
+			}
 		}
-		return fs.sendError(conn, 500, "Internal Server Error", req.Version, keepAlive, remainingRequests)
+		return fs.sendError(conn, req, code, status, keepAlive, remainingRequests)
 	}
+	defer func() { file.Close() }()
 
-	// If it's a directory, try to serve index.html
+	// If it's a directory, send it as an archive (?download=zip) or try to serve index.html
 	if fileInfo.IsDir() {
-		indexPath := filepath.Join(filePath, "index.html")
-		if newInfo, err := os.Stat(indexPath); err == nil {
-			filePath = indexPath
-			fileInfo = newInfo
+		if format := archiveRequested(req); fs.archives && format != "" {
+			return fs.serveArchive(req, conn, file, fileInfo, rel, format, keepAlive, remainingRequests)
+		}
+		if index, indexInfo, err := fs.open(path.Join(rel, "index.html")); err == nil {
+			file.Close()
+			file, fileInfo, rel = index, indexInfo, path.Join(rel, "index.html")
+		} else if fs.listing != nil {
+			return fs.serveDirectory(req, conn, file, rel, keepAlive, remainingRequests)
 		} else {
-			return fs.sendError(conn, 403, "Directory listing disabled", req.Version, keepAlive, remainingRequests)
+			return fs.sendError(conn, req, 403, "Directory listing disabled", keepAlive, remainingRequests)
 		}
 	}
 
-	// Check If-Modified-Since header for caching (304 Not Modified)
+	// Only regular files are served (no FIFOs, devices or sockets)
+	if !fileInfo.Mode().IsRegular() {
+		return fs.sendError(conn, req, 404, "Not Found", keepAlive, remainingRequests)
+	}
+
+	// Small files are read once into the file cache and served from there
+	if fs.files != nil {
+		if entry := fs.cacheFile(file, rel, fileInfo, generation); entry != nil {
+			return fs.serveCached(entry, req, conn, keepAlive, remainingRequests)
+		}
+	}
+
+	// Content-Type from the extension, or sniffed from the first bytes
+	contentType := contentTypeOf(rel, file)
+
+	// Conditional requests: ETag and Last-Modified validators in RFC 9110 order
 	modTime := fileInfo.ModTime()
-	if !modTime.IsZero() {
-		if ifModifiedSince := req.Headers["If-Modified-Since"]; ifModifiedSince != "" {
-			// Parse client's cached date
-			ifModTime, err := parseHTTPTime(ifModifiedSince)
-			if err == nil {
-				// Truncate to seconds (HTTP time format precision)
-				modTime = modTime.Truncate(time.Second)
-				ifModTime = ifModTime.Truncate(time.Second)
-
-				// If file hasn't been modified since client's cached version
-				if !modTime.After(ifModTime) {
-					// Return 304 Not Modified (no body - saves bandwidth!)
-					return fs.sendNotModified(conn, modTime, req.Version, keepAlive, remainingRequests)
-				}
-			}
+	etag, err := fs.etag(file, rel, fileInfo)
+	if err != nil {
+		return fs.sendError(conn, req, 500, "Internal Server Error", keepAlive, remainingRequests)
+	}
+	switch EvaluatePreconditions(req, etag, modTime) {
+	case 304:
+		// Return 304 Not Modified (no body - saves bandwidth!)
+		tag := representationETag(req, etag, contentType, fileInfo.Size())
+		return fs.sendNotModified(conn, rel, modTime, tag, req.Version, keepAlive, remainingRequests)
+	case 412:
+		return fs.sendError(conn, req, 412, "Precondition Failed", keepAlive, remainingRequests)
+	}
+
+	// A Range that will be ignored (stale If-Range, bad syntax, too many
+	// ranges) turns the request into a plain GET for the full representation
+	if ranges, err := requestedRanges(req, etag, modTime, fileInfo.Size()); ranges == nil && err == nil {
+		delete(req.Headers, "Range")
+	}
+
+	// Serve a precompressed sidecar (style.css.gz, style.css.zst) when one is
+	// up to date and acceptable; Range requests always use the original file
+	if req.Headers["Range"] == "" {
+		if sc := fs.findSidecar(req, rel, fileInfo); sc != nil {
+			defer sc.file.Close()
+			return fs.sendSidecar(sc, rel, contentType, modTime, etag, req, conn, keepAlive, remainingRequests)
 		}
 	}
 
@@ -149,30 +239,51 @@ func (fs *FileServer) ServeFileStream(req *protocol.Request, conn *tcp.TCPConn,
 	// Decision: Small file (load in memory) or large file (stream)?
 	if fileSize <= MaxInMemorySize {
 		// Small file: Use in-memory approach (fast for small files)
-		return fs.serveSmallFile(conn, filePath, fileSize, modTime, req.Version, req, keepAlive, remainingRequests)
+		return fs.serveSmallFile(conn, file, rel, contentType, fileSize, modTime, etag, req.Version, req, keepAlive, remainingRequests)
 	} else {
 		// Large file: Use streaming with Range support (memory-efficient)
-		return fs.serveLargeFile(req, conn, filePath, fileSize, modTime, req.Version, keepAlive, remainingRequests)
+		return fs.serveLargeFile(req, conn, file, rel, contentType, fileSize, modTime, etag, req.Version, keepAlive, remainingRequests)
 	}
 }
 
 // serveSmallFile loads entire file in memory (fast for small files <1MB)
-// Supports gzip compression for text-based content types via CompressResponse middleware
-func (fs *FileServer) serveSmallFile(conn *tcp.TCPConn, filePath string, fileSize int64, modTime time.Time, version protocol.HTTPVersion, req *protocol.Request, keepAlive bool, remainingRequests int) error {
-	content, err := os.ReadFile(filePath)
+// Supports gzip/zstd/deflate compression for text-based content types via CompressResponse middleware
+func (fs *FileServer) serveSmallFile(conn *tcp.TCPConn, file staticFile, rel, contentType string, fileSize int64, modTime time.Time, etag string, version protocol.HTTPVersion, req *protocol.Request, keepAlive bool, remainingRequests int) error {
+	// Ranges are served from the file contents in memory, uncompressed,
+	// exactly as serveLargeFile does for big files
+	ranges, err := requestedRanges(req, etag, modTime, fileSize)
 	if err != nil {
-		return fs.sendError(conn, 500, "Error reading file", version, keepAlive, remainingRequests)
+		return fs.sendRangeNotSatisfiable(conn, fileSize, version, keepAlive, remainingRequests)
+	}
+	if len(ranges) > 0 {
+		content, err := readAll(file)
+		if err != nil {
+			return fs.sendError(conn, req, 500, "Error reading file", keepAlive, remainingRequests)
+		}
+		// The file may have changed size since it was stat'ed; re-check against the bytes we have
+		if int64(len(content)) != fileSize {
+			if ranges, err = requestedRanges(req, etag, modTime, int64(len(content))); err != nil {
+				return fs.sendRangeNotSatisfiable(conn, int64(len(content)), version, keepAlive, remainingRequests)
+			}
+			fileSize = int64(len(content))
+		}
+		if len(ranges) == 1 {
+			return fs.sendRangeFile(bytes.NewReader(content), ranges[0].start, ranges[0].end, rel, contentType, fileSize, modTime, etag, version, conn, keepAlive, remainingRequests)
+		}
+		if len(ranges) > 1 {
+			return fs.sendMultipartRanges(bytes.NewReader(content), ranges, rel, contentType, fileSize, modTime, etag, version, conn, keepAlive, remainingRequests)
+		}
 	}
-
-	contentType := getContentType(filePath)
 
 	// Create response object
-	resp := protocol.NewResponse(200, "OK", version, string(content))
+	resp := protocol.NewResponse(200, "OK", version, "")
 	resp.Headers["Content-Type"] = contentType
-	resp.Headers["Accept-Ranges"] = "bytes"                            // Critical: tells browser Range requests are supported
-	resp.Headers["Last-Modified"] = modTime.UTC().Format(time.RFC1123) // Enable caching
-	resp.Headers["Cache-Control"] = "public, max-age=3600"
-	resp.Headers["Date"] = time.Now().UTC().Format(time.RFC1123)
+	resp.Headers["X-Content-Type-Options"] = "nosniff"
+	resp.Headers["Accept-Ranges"] = "bytes"                              // Critical: tells browser Range requests are supported
+	resp.Headers["Last-Modified"] = modTime.UTC().Format(httpTimeFormat) // Enable caching
+	resp.Headers["ETag"] = etag
+	fs.cachePolicy.apply(resp.Headers, rel)
+	resp.Headers["Date"] = time.Now().UTC().Format(httpTimeFormat)
 	resp.Headers["Server"] = "GoWebServer/1.0"
 
 	// Set Connection headers for keep-alive
@@ -183,9 +294,44 @@ func (fs *FileServer) serveSmallFile(conn *tcp.TCPConn, filePath string, fileSiz
 		resp.Headers["Connection"] = "close"
 	}
 
-	// Apply gzip compression using middleware (handles all compression logic)
+	// Compressed bodies of hot files come from the cache: no disk read, no compression
+	var key cacheKey
+	cacheable := false
+	if enc := negotiateEncoder(req.Headers["Accept-Encoding"]); enc != nil && fs.cache != nil &&
+		shouldCompress(contentType) && fileSize >= minSizeForCompression {
+		key = newCacheKey(fs.displayPath(rel), modTime, fileSize, enc.Name())
+		cacheable = true
+
+		if compressed, found := fs.cache.Get(key); found {
+			resp.Body = string(compressed)
+			resp.Headers["Content-Encoding"] = enc.Name()
+			resp.Headers["Content-Length"] = fmt.Sprintf("%d", len(compressed))
+			resp.Headers["Vary"] = "Accept-Encoding"
+			resp.Headers["ETag"] = weakETag(etag)
+			return writeBufferedResponse(conn, resp)
+		}
+	}
+
+	content, err := readAll(file)
+	if err != nil {
+		return fs.sendError(conn, req, 500, "Error reading file", keepAlive, remainingRequests)
+	}
+	resp.Body = string(content)
+
+	// Apply compression using middleware (handles all compression logic)
 	CompressResponse(resp, req)
 
+	if cacheable && resp.Headers["Content-Encoding"] == key.encoding {
+		fs.cache.Put(key, []byte(resp.Body))
+	}
+
+	return writeBufferedResponse(conn, resp)
+}
+
+// writeBufferedResponse writes a response whose headers are already complete
+func writeBufferedResponse(conn *tcp.TCPConn, resp *protocol.Response) error {
+	resp.Headers["Content-Length"] = fmt.Sprintf("%d", len(resp.Body))
+
 	// Convert response object to string and send
 	responseStr := fmt.Sprintf("%s %d %s\r\n", resp.Version, resp.StatusCode, resp.Status)
 	for key, value := range resp.Headers {
@@ -193,114 +339,55 @@ func (fs *FileServer) serveSmallFile(conn *tcp.TCPConn, filePath string, fileSiz
 	}
 	responseStr += "\r\n" + resp.Body
 
-	_, err = conn.Write([]byte(responseStr))
+	_, err := conn.Write([]byte(responseStr))
 	return err
 }
 
 // serveLargeFile streams file directly to connection with Range request support
 // Supports partial content delivery (206) for video/audio seeking and resume downloads
 // Works for ALL file types, not just video/audio
-func (fs *FileServer) serveLargeFile(req *protocol.Request, conn *tcp.TCPConn, filePath string, fileSize int64, modTime time.Time, version protocol.HTTPVersion, keepAlive bool, remainingRequests int) error {
-	// Open file (doesn't load into memory!)
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fs.sendError(conn, 500, "Error opening file", version, keepAlive, remainingRequests)
-	}
-	defer file.Close()
-
-	// Check if client requests a specific range
-	rangeHeader := req.Headers["Range"]
-	if rangeHeader == "" {
-		// No Range header - send full file with Accept-Ranges header
-		return fs.sendFullFile(file, filePath, fileSize, modTime, version, conn, keepAlive, remainingRequests)
-	}
+func (fs *FileServer) serveLargeFile(req *protocol.Request, conn *tcp.TCPConn, file staticFile, rel, contentType string, fileSize int64, modTime time.Time, etag string, version protocol.HTTPVersion, keepAlive bool, remainingRequests int) error {
+	// The file is already open; it is streamed, never loaded into memory
 
-	// Parse Range header (e.g., "bytes=0-1023")
-	start, end, err := parseRangeHeader(rangeHeader, fileSize)
+	// Check if client requests specific ranges
+	ranges, err := requestedRanges(req, etag, modTime, fileSize)
 	if err != nil {
-		// Invalid range - send 416 Range Not Satisfiable
-		resp := protocol.NewResponse(416, "Range Not Satisfiable", version, "")
-		resp.Headers["Content-Range"] = fmt.Sprintf("bytes */%d", fileSize)
-		resp.Headers["Date"] = time.Now().UTC().Format(time.RFC1123)
-		resp.Headers["Server"] = "GoWebServer/1.0"
-
-		// Set Connection headers
-		if keepAlive {
-			resp.Headers["Connection"] = "keep-alive"
-			resp.Headers["Keep-Alive"] = fmt.Sprintf("timeout=30, max=%d", remainingRequests)
-		} else {
-			resp.Headers["Connection"] = "close"
-		}
-
-		headersStr := fmt.Sprintf("%s %d %s\r\n", resp.Version, resp.StatusCode, resp.Status)
-		for key, value := range resp.Headers {
-			headersStr += fmt.Sprintf("%s: %s\r\n", key, value)
-		}
-		headersStr += "\r\n"
-
-		if _, writeErr := conn.Write([]byte(headersStr)); writeErr != nil {
-			return writeErr
-		}
-		return err
+		// No requested range overlaps the file - send 416 Range Not Satisfiable
+		return fs.sendRangeNotSatisfiable(conn, fileSize, version, keepAlive, remainingRequests)
 	}
 
-	// Send requested range (206 Partial Content)
-	return fs.sendRangeFile(file, start, end, filePath, fileSize, modTime, version, conn, keepAlive, remainingRequests)
-}
-
-// parseRangeHeader parses HTTP Range header (e.g., "bytes=0-1023")
-// Returns start and end byte positions (inclusive)
-func parseRangeHeader(rangeHeader string, fileSize int64) (int64, int64, error) {
-	// Expected format: "bytes=start-end" or "bytes=start-"
-	if !strings.HasPrefix(rangeHeader, "bytes=") {
-		return 0, 0, fmt.Errorf("invalid range header format")
-	}
-
-	// Remove "bytes=" prefix
-	rangeSpec := strings.TrimPrefix(rangeHeader, "bytes=")
-
-	// Split on '-'
-	parts := strings.Split(rangeSpec, "-")
-	if len(parts) != 2 {
-		return 0, 0, fmt.Errorf("invalid range specification")
-	}
-
-	// Parse start
-	start, err := strconv.ParseInt(parts[0], 10, 64)
-	if err != nil {
-		return 0, 0, fmt.Errorf("invalid start value")
-	}
-
-	// Parse end (or use fileSize-1 if not specified)
-	var end int64
-	if parts[1] == "" {
-		end = fileSize - 1
-	} else {
-		end, err = strconv.ParseInt(parts[1], 10, 64)
-		if err != nil {
-			return 0, 0, fmt.Errorf("invalid end value")
+	switch len(ranges) {
+	case 0:
+		// No (usable) Range header - compress on the fly if the client allows it,
+		// otherwise send the full file with Accept-Ranges header
+		if enc := streamingEncoder(req, contentType); enc != nil {
+			return fs.sendCompressedFile(file, enc, rel, contentType, modTime, weakETag(etag), version, conn, keepAlive, remainingRequests)
 		}
-	}
+		return fs.sendFullFile(file, rel, contentType, fileSize, modTime, etag, version, conn, keepAlive, remainingRequests)
 
-	// Validate range
-	if start < 0 || end >= fileSize || start > end {
-		return 0, 0, fmt.Errorf("range out of bounds")
-	}
+	case 1:
+		// Send requested range (206 Partial Content), always uncompressed so
+		// byte offsets refer to the file
+		return fs.sendRangeFile(file, ranges[0].start, ranges[0].end, rel, contentType, fileSize, modTime, etag, version, conn, keepAlive, remainingRequests)
 
-	return start, end, nil
+	default:
+		return fs.sendMultipartRanges(file, ranges, rel, contentType, fileSize, modTime, etag, version, conn, keepAlive, remainingRequests)
+	}
 }
 
 // sendFullFile sends the complete file with Accept-Ranges header
-func (fs *FileServer) sendFullFile(file *os.File, filePath string, fileSize int64, modTime time.Time, version protocol.HTTPVersion, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+func (fs *FileServer) sendFullFile(file staticFile, rel, contentType string, fileSize int64, modTime time.Time, etag string, version protocol.HTTPVersion, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
 	resp := protocol.NewResponse(200, "OK", version, "")
 
 	// Set headers
-	resp.Headers["Content-Type"] = getContentType(filePath)
+	resp.Headers["Content-Type"] = contentType
+	resp.Headers["X-Content-Type-Options"] = "nosniff"
 	resp.Headers["Content-Length"] = fmt.Sprintf("%d", fileSize)
-	resp.Headers["Accept-Ranges"] = "bytes"                            // Critical: tells browser Range requests are supported
-	resp.Headers["Last-Modified"] = modTime.UTC().Format(time.RFC1123) // Enable caching
-	resp.Headers["Cache-Control"] = "public, max-age=3600"
-	resp.Headers["Date"] = time.Now().UTC().Format(time.RFC1123)
+	resp.Headers["Accept-Ranges"] = "bytes"                              // Critical: tells browser Range requests are supported
+	resp.Headers["Last-Modified"] = modTime.UTC().Format(httpTimeFormat) // Enable caching
+	resp.Headers["ETag"] = etag
+	fs.cachePolicy.apply(resp.Headers, rel)
+	resp.Headers["Date"] = time.Now().UTC().Format(httpTimeFormat)
 	resp.Headers["Server"] = "GoWebServer/1.0"
 
 	// Set Connection headers for keep-alive
@@ -323,31 +410,78 @@ func (fs *FileServer) sendFullFile(file *os.File, filePath string, fileSize int6
 	}
 
 	// Stream full file content
-	_, err := io.Copy(conn, file)
-	return err
+	return sendFileRange(conn, file, 0, fileSize)
 }
 
-// sendRangeFile sends a partial file content (206 Partial Content)
-// Used for video seeking, audio playback, and resume downloads
-func (fs *FileServer) sendRangeFile(file *os.File, start, end int64, filePath string, fileSize int64, modTime time.Time, version protocol.HTTPVersion, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
-	// Seek to start position
-	if _, err := file.Seek(start, 0); err != nil {
+// sendCompressedFile streams the file through enc using chunked transfer encoding
+// The compressed size is unknown until the end, so there is no Content-Length;
+// the body is sent in chunks of up to streamChunkSize bytes.
+func (fs *FileServer) sendCompressedFile(file staticFile, enc Encoder, rel, contentType string, modTime time.Time, etag string, version protocol.HTTPVersion, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+	resp := protocol.NewResponse(200, "OK", version, "")
+
+	// Set headers
+	resp.Headers["Content-Type"] = contentType
+	resp.Headers["X-Content-Type-Options"] = "nosniff"
+	resp.Headers["Content-Encoding"] = enc.Name()
+	resp.Headers["Transfer-Encoding"] = "chunked"
+	resp.Headers["Vary"] = "Accept-Encoding"
+	resp.Headers["Accept-Ranges"] = "bytes"                              // Range requests get the identity encoding
+	resp.Headers["Last-Modified"] = modTime.UTC().Format(httpTimeFormat) // Enable caching
+	resp.Headers["ETag"] = etag
+	fs.cachePolicy.apply(resp.Headers, rel)
+
+	// Set Connection headers for keep-alive
+	if keepAlive {
+		resp.Headers["Connection"] = "keep-alive"
+		resp.Headers["Keep-Alive"] = fmt.Sprintf("timeout=30, max=%d", remainingRequests)
+	} else {
+		resp.Headers["Connection"] = "close"
+	}
+
+	if err := protocol.WriteHeaders(conn, resp); err != nil {
+		return err
+	}
+
+	// file -> encoder -> buffer -> chunked -> connection
+	chunked := protocol.NewChunkedWriter(conn)
+	buffered := bufio.NewWriterSize(chunked, streamChunkSize)
+	compressor, err := enc.NewWriter(buffered)
+	if err != nil {
 		return err
 	}
 
+	if _, err := io.Copy(compressor, file); err != nil {
+		compressor.Close()
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	return chunked.Close()
+}
+
+// sendRangeFile sends a partial file content (206 Partial Content)
+// Used for video seeking, audio playback, and resume downloads
+// file is an open file or, for small files, their contents already in memory
+func (fs *FileServer) sendRangeFile(file io.ReaderAt, start, end int64, rel, contentType string, fileSize int64, modTime time.Time, etag string, version protocol.HTTPVersion, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
 	contentLength := end - start + 1
 
 	// Create 206 Partial Content response
 	resp := protocol.NewResponse(206, "Partial Content", version, "")
 
 	// Set headers
-	resp.Headers["Content-Type"] = getContentType(filePath)
+	resp.Headers["Content-Type"] = contentType
+	resp.Headers["X-Content-Type-Options"] = "nosniff"
 	resp.Headers["Content-Length"] = fmt.Sprintf("%d", contentLength)
 	resp.Headers["Content-Range"] = fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize)
 	resp.Headers["Accept-Ranges"] = "bytes"
-	resp.Headers["Last-Modified"] = modTime.UTC().Format(time.RFC1123) // Enable caching
-	resp.Headers["Cache-Control"] = "public, max-age=3600"
-	resp.Headers["Date"] = time.Now().UTC().Format(time.RFC1123)
+	resp.Headers["Last-Modified"] = modTime.UTC().Format(httpTimeFormat) // Enable caching
+	resp.Headers["ETag"] = etag
+	fs.cachePolicy.apply(resp.Headers, rel)
+	resp.Headers["Date"] = time.Now().UTC().Format(httpTimeFormat)
 	resp.Headers["Server"] = "GoWebServer/1.0"
 
 	// Set Connection headers for keep-alive
@@ -369,25 +503,29 @@ func (fs *FileServer) sendRangeFile(file *os.File, start, end int64, filePath st
 		return err
 	}
 
-	// Stream only the requested range using io.CopyN
-	// This reads exactly contentLength bytes from the file
-	_, err := io.CopyN(conn, file, contentLength)
-	return err
+	// Stream only the requested range
+	return sendFileRange(conn, file, start, contentLength)
 }
 
-// sendError sends an error response
-func (fs *FileServer) sendError(conn *tcp.TCPConn, code int, status string, version protocol.HTTPVersion, keepAlive bool, remainingRequests int) error {
-	body := fmt.Sprintf("%d - %s", code, status)
-
-	// Create response object
-	resp := protocol.NewResponse(code, status, version, body)
+// sendFileRange sends length bytes of file, starting at offset, as they are
+// Files on disk go through sendfile(2); embedded and in-memory files are
+// copied. Either way a bandwidth throttle on the connection applies.
+func sendFileRange(conn *tcp.TCPConn, file io.ReaderAt, offset, length int64) error {
+	if f, ok := file.(*os.File); ok {
+		_, err := conn.SendFile(f, offset, length)
+		return err
+	}
+	_, err := io.Copy(conn, io.NewSectionReader(file, offset, length))
+	return err
+}
 
-	// Set headers
-	resp.Headers["Content-Type"] = "text/plain"
-	resp.Headers["Content-Length"] = fmt.Sprintf("%d", len(body))
-	resp.Headers["Date"] = time.Now().UTC().Format(time.RFC1123)
-	resp.Headers["Server"] = "GoWebServer/1.0"
+// sendError sends an error response rendered by the configured error handler
+func (fs *FileServer) sendError(conn *tcp.TCPConn, req *protocol.Request, code int, status string, keepAlive bool, remainingRequests int) error {
+	return sendWithConnection(conn, fs.errorResponse(req, code, status), keepAlive, remainingRequests)
+}
 
+// sendWithConnection writes a buffered response with keep-alive headers
+func sendWithConnection(conn *tcp.TCPConn, resp *protocol.Response, keepAlive bool, remainingRequests int) error {
 	// Set Connection headers for keep-alive
 	if keepAlive {
 		resp.Headers["Connection"] = "keep-alive"
@@ -396,25 +534,49 @@ func (fs *FileServer) sendError(conn *tcp.TCPConn, code int, status string, vers
 		resp.Headers["Connection"] = "close"
 	}
 
-	// Convert response object to string and send
-	responseStr := fmt.Sprintf("%s %d %s\r\n", resp.Version, resp.StatusCode, resp.Status)
-	for key, value := range resp.Headers {
-		responseStr += fmt.Sprintf("%s: %s\r\n", key, value)
+	// WriteResponse adds Content-Length, Date and Server headers
+	return protocol.WriteResponse(conn, resp)
+}
+
+// openErrorStatus maps an open error to a response status
+// Policy denials and escapes look exactly like missing files.
+func openErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, errForbiddenPath), os.IsNotExist(err), errors.Is(err, syscall.ENOTDIR):
+		return 404, "Not Found"
+	case os.IsPermission(err):
+		return 403, "Forbidden"
+	default:
+		return 500, "Internal Server Error"
 	}
-	responseStr += "\r\n" + resp.Body
+}
 
-	_, err := conn.Write([]byte(responseStr))
-	return err
+// readAll reads a whole file from the start (small files only)
+func readAll(file staticFile) ([]byte, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(file)
+}
+
+// errorResponse renders an error page with the configured error handler
+func (fs *FileServer) errorResponse(req *protocol.Request, code int, status string) *protocol.Response {
+	if fs.errorHandler != nil {
+		return fs.errorHandler(req, code, status)
+	}
+	return router.DefaultErrorHandler(req, code, status)
 }
 
 // sendNotModified sends a 304 Not Modified response (no body)
-func (fs *FileServer) sendNotModified(conn *tcp.TCPConn, modTime time.Time, version protocol.HTTPVersion, keepAlive bool, remainingRequests int) error {
+func (fs *FileServer) sendNotModified(conn *tcp.TCPConn, rel string, modTime time.Time, etag string, version protocol.HTTPVersion, keepAlive bool, remainingRequests int) error {
 	resp := protocol.NewResponse(304, "Not Modified", version, "")
 
-	// Set headers (no Content-Length or Content-Type for 304)
-	resp.Headers["Last-Modified"] = modTime.UTC().Format(time.RFC1123)
-	resp.Headers["Cache-Control"] = "public, max-age=3600"
-	resp.Headers["Date"] = time.Now().UTC().Format(time.RFC1123)
+	// Set headers (no Content-Length or Content-Type for 304); caching
+	// headers match the 200 so a revalidation refreshes them
+	resp.Headers["Last-Modified"] = modTime.UTC().Format(httpTimeFormat)
+	resp.Headers["ETag"] = etag
+	fs.cachePolicy.apply(resp.Headers, rel)
+	resp.Headers["Date"] = time.Now().UTC().Format(httpTimeFormat)
 	resp.Headers["Server"] = "GoWebServer/1.0"
 
 	// Set Connection headers for keep-alive
@@ -456,21 +618,6 @@ func parseHTTPTime(timeStr string) (time.Time, error) {
 	return time.Time{}, fmt.Errorf("invalid time format")
 }
 
-// getContentType returns the MIME type based on file extension
-func getContentType(filePath string) string {
-	ext := filepath.Ext(filePath)
-
-	// Use Go's standard library to detect MIME type
-	contentType := mime.TypeByExtension(ext)
-
-	// If no type found, default to binary data
-	if contentType == "" {
-		return "application/octet-stream"
-	}
-
-	return contentType
-}
-
 // HandleStaticFile creates a handler function for serving static files
 // This is kept for backward compatibility with response-based routing
 func HandleStaticFile(rootDir string) func(*protocol.Request) *protocol.Response {
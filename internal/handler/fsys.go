@@ -0,0 +1,211 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// staticFile is an open file served by FileServer, from disk or from an fs.FS
+// *os.File implements it; files from an fs.FS without ReadAt/Seek are read
+// into memory (memFile), and their directories are wrapped in dirFile.
+type staticFile interface {
+	iofs.File
+	io.ReaderAt
+	io.Seeker
+}
+
+// errIsDirectory is returned when a directory is read as a file
+var errIsDirectory = errors.New("is a directory")
+
+// memFile is a file whose contents are held in memory
+type memFile struct {
+	*bytes.Reader
+	info iofs.FileInfo
+}
+
+func (f *memFile) Stat() (iofs.FileInfo, error) { return f.info, nil }
+func (f *memFile) Close() error                 { return nil }
+
+// dirFile adapts an fs.FS directory to staticFile
+type dirFile struct {
+	iofs.ReadDirFile
+}
+
+func (d dirFile) ReadAt([]byte, int64) (int, error) { return 0, errIsDirectory }
+func (d dirFile) Seek(int64, int) (int64, error)    { return 0, errIsDirectory }
+
+// modTimeInfo overrides the modification time of files that have none
+// (embed.FS reports the zero time for everything)
+type modTimeInfo struct {
+	iofs.FileInfo
+	modTime time.Time
+}
+
+func (i modTimeInfo) ModTime() time.Time { return i.modTime }
+
+// embeddedAsset holds what PrecomputeAssets derived for one file
+type embeddedAsset struct {
+	etag     string            // Strong content-hash ETag
+	variants map[string][]byte // Compressed bodies by encoding name
+}
+
+// NewFileServerFS creates a file server for fsys, e.g. an embed.FS or fs.Sub of one
+// Paths are resolved by fsys itself; the access policy's dotfile and extension
+// rules still apply. Files without a modification time use the time the
+// server was created, so Last-Modified stays stable for the process lifetime.
+func NewFileServerFS(fsys iofs.FS) *FileServer {
+	return &FileServer{
+		fsys:        fsys,
+		loadedAt:    time.Now().Truncate(time.Second),
+		access:      DefaultAccessPolicy(),
+		cachePolicy: DefaultCachePolicy(),
+	}
+}
+
+// PrecomputeAssets hashes every file in the server's fs.FS and compresses the
+// compressible ones with each registered encoder, so requests never hash or
+// compress. Meant for read-only trees such as embed.FS; call it once before
+// serving. Files that change afterwards keep their precomputed ETag.
+func (fs *FileServer) PrecomputeAssets() error {
+	if fs.fsys == nil {
+		return errors.New("PrecomputeAssets requires a FileServer created with NewFileServerFS")
+	}
+
+	assets := make(map[string]*embeddedAsset)
+	err := iofs.WalkDir(fs.fsys, ".", func(name string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !fs.access.allowed(name) {
+			return nil
+		}
+
+		content, err := iofs.ReadFile(fs.fsys, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		asset := &embeddedAsset{
+			etag:     `"` + hex.EncodeToString(sum[:16]) + `"`,
+			variants: make(map[string][]byte),
+		}
+
+		if shouldCompress(contentTypeOf(name, bytes.NewReader(content))) && len(content) >= minSizeForCompression {
+			for _, enc := range encoders {
+				compressed, err := compressContent(enc, content)
+				if err != nil {
+					log.Printf("precompute %s (%s): %v", name, enc.Name(), err)
+					continue
+				}
+				// Only keep variants that are actually smaller
+				if len(compressed) < len(content) {
+					asset.variants[enc.Name()] = compressed
+				}
+			}
+		}
+
+		assets[name] = asset
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fs.assets = assets
+	return nil
+}
+
+// openFS opens rel in the server's fs.FS
+func (fs *FileServer) openFS(rel string) (staticFile, os.FileInfo, error) {
+	name := rel
+	if name == "" {
+		name = "."
+	}
+	if !iofs.ValidPath(name) {
+		return nil, nil, errForbiddenPath
+	}
+
+	file, err := fs.fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if info.ModTime().IsZero() {
+		info = modTimeInfo{FileInfo: info, modTime: fs.loadedAt}
+	}
+
+	if sf, ok := file.(staticFile); ok {
+		return sf, info, nil
+	}
+	if info.IsDir() {
+		if dir, ok := file.(iofs.ReadDirFile); ok {
+			return dirFile{dir}, info, nil
+		}
+		file.Close()
+		return nil, nil, &iofs.PathError{Op: "readdir", Path: name, Err: errors.ErrUnsupported}
+	}
+
+	// Regular files without ReadAt/Seek are buffered (fs.FS trees are small)
+	content, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &memFile{Reader: bytes.NewReader(content), info: info}, info, nil
+}
+
+// etag returns the strong ETag for rel: precomputed for embedded assets,
+// otherwise derived from the open file as configured by SetETagMode
+func (fs *FileServer) etag(file staticFile, rel string, info os.FileInfo) (string, error) {
+	if asset, ok := fs.assets[rel]; ok {
+		return asset.etag, nil
+	}
+	return fileETag(file, fs.displayPath(rel), info, fs.etagMode)
+}
+
+// precomputedVariant returns the best precompressed body of rel the client accepts
+func (fs *FileServer) precomputedVariant(accept, rel string, info os.FileInfo) *sidecar {
+	asset, ok := fs.assets[rel]
+	if !ok || len(asset.variants) == 0 {
+		return nil
+	}
+
+	var candidates []Encoder
+	for _, enc := range encoders {
+		if _, ok := asset.variants[enc.Name()]; ok {
+			candidates = append(candidates, enc)
+		}
+	}
+	enc := negotiate(accept, candidates)
+	if enc == nil {
+		return nil
+	}
+
+	body := asset.variants[enc.Name()]
+	return &sidecar{
+		file:     &memFile{Reader: bytes.NewReader(body), info: info},
+		encoding: enc,
+		size:     int64(len(body)),
+	}
+}
+
+// displayPath identifies rel in logs and cache keys ("public/static/app.js")
+func (fs *FileServer) displayPath(rel string) string {
+	if fs.fsys != nil {
+		return path.Join(fs.root, rel)
+	}
+	return filepath.Join(fs.root, filepath.FromSlash(rel))
+}
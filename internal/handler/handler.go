@@ -1,71 +1,254 @@
 package handler
 
 import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/fs"
+	"log"
 	"os"
+	"path"
+	"path/filepath"
 	"webserver/internal/protocol"
 	"webserver/internal/router"
 	"webserver/internal/tcp"
+	"webserver/internal/throttle"
 )
 
 type HTTPHandler struct {
 	router *router.Router
+	site   SiteConfig
 }
 
+// SiteConfig describes the on-disk layout of one site
+// Each virtual host gets its own HTTPHandler built from a SiteConfig
+type SiteConfig struct {
+	StaticRoot  string // Directory whose static/ subtree is served under /static/
+	TemplateDir string // Directory holding home.html and errors/*.html
+	Files       fs.FS  // Read StaticRoot and TemplateDir from this FS (e.g. embedded assets) instead of disk
+	DebugRoutes bool   // Expose GET /debug/routes and GET /debug/cache
+
+	CompressionCacheSize int64    // Memory budget for compressed static files (0 = no cache)
+	FileCacheSize        int64    // Memory budget for hot static files, invalidated by inotify (0 = no cache)
+	ETagMode             ETagMode // How static file ETags are computed (default: size+mtime+inode)
+	ListDirectories      bool     // List /static/ directories that have no index.html
+	ArchiveDownloads     bool     // Download /static/ directories as zip or tar.gz (?download=zip)
+
+	AccessPolicy *AccessPolicy // Symlink/dotfile/extension rules for /static/ (nil = DefaultAccessPolicy)
+	SPAFallback  *SPAOptions   // Serve a single-page app's index.html for its client-side routes (nil = 404)
+	CachePolicy  *CachePolicy  // Cache-Control/Expires rules for /static/ and /favicon.ico (nil = DefaultCachePolicy)
+	Uploads      *WriteOptions // Authenticated PUT/DELETE/MKCOL under /static/ (nil = read-only)
+	WebDAV       bool          // Also serve /static/ as a WebDAV share (needs Uploads)
+
+	DownloadLimit *throttle.Config // Bandwidth limits for GET /static/ (nil = unlimited)
+	Images        *ImageOptions    // Resized copies of static images under /img/ (nil = off)
+}
+
+// DefaultSiteConfig returns the layout of the bundled site (./public, ./templates)
+func DefaultSiteConfig() SiteConfig {
+	return SiteConfig{
+		StaticRoot:  "./public",
+		TemplateDir: "templates",
+
+		CompressionCacheSize: DefaultCompressionCacheSize,
+	}
+}
+
+// NewHTTPHandler creates the handler for the bundled default site
 func NewHTTPHandler() *HTTPHandler {
+	return NewSiteHandler(DefaultSiteConfig())
+}
+
+// NewSiteHandler creates a handler with its own router, static root and templates
+func NewSiteHandler(site SiteConfig) *HTTPHandler {
 	r := router.NewRouter()
 
+	// Recover from handler panics with a logged stack trace and a 500 page
+	r.Use(r.Recover())
+
+	// Templates come from disk or from site.Files
+	templates := site.subFS(site.TemplateDir)
+
+	// Error pages: HTML templates for the site, problem+json for the API
+	r.RegisterErrorHandler("/*", 0, TemplateErrorHandlerFS(subFS(templates, "errors")))
+	r.RegisterErrorHandler("/api/*", 0, ProblemJSONErrorHandler)
+
 	// Register API routes (exact matches)
-	r.RegisterRoute("GET", "/", handleHome)
-	r.RegisterRoute("GET", "/hello", handleHello)
-	r.RegisterRoute("POST", "/echo", handleEcho)
-	r.RegisterRoute("GET", "/api/users", handleGetUsers)
-	r.RegisterRoute("GET", "/version", handleVersion)
-	r.RegisterRoute("GET", "/favicon.ico", handleFavicon) // Root level favicon
-
-	// Set streaming handler for static files (GET /static/*)
+	// Names are used by templates via {{url "name"}} instead of hard-coded links
+	r.RegisterRoute("GET", "/", homeHandler(templates, r)).Name("home")
+	r.RegisterRoute("GET", "/hello", handleHello).Name("hello")
+	r.RegisterRoute("POST", "/echo", handleEcho).Name("echo")
+	r.RegisterRoute("GET", "/api/users", handleGetUsers).Name("users.list")
+	r.RegisterRoute("GET", "/version", handleVersion).Name("version")
+
+	// Register streaming routes for static files (GET/HEAD /static/*)
 	// Automatically uses in-memory for small files (<1MB) and streaming for large files (>1MB)
-	r.SetStreamHandler(HandleStaticFileStream("./public"))
+	// Embedded sites get their ETags and compressed variants computed once, here
+	var static *FileServer
+	if site.Files != nil {
+		static = NewFileServerFS(site.subFS(site.StaticRoot))
+	} else {
+		static = NewFileServer(site.StaticRoot)
+	}
+	static.SetErrorHandler(r.Error)
+	static.SetETagMode(site.ETagMode)
+	if site.AccessPolicy != nil {
+		static.SetAccessPolicy(*site.AccessPolicy)
+	}
+	if site.CachePolicy != nil {
+		static.SetCachePolicy(*site.CachePolicy)
+	}
+	if site.SPAFallback != nil {
+		static.SetSPAFallback(*site.SPAFallback)
+	}
+	if site.ArchiveDownloads {
+		static.SetArchiveDownloads(true)
+	}
+	if site.ListDirectories {
+		static.SetDirectoryListing(ListingOptions{Prefix: "/static/"})
+	}
+	if site.CompressionCacheSize > 0 {
+		static.SetCompressionCache(NewCompressionCache(site.CompressionCacheSize))
+	}
+	if site.FileCacheSize > 0 {
+		if err := static.EnableFileCache(site.FileCacheSize); err != nil {
+			log.Printf("%v (serving from disk)", err)
+		}
+	}
+	if site.Files != nil {
+		if err := static.PrecomputeAssets(); err != nil {
+			log.Printf("precompute static assets: %v", err)
+		}
+	}
+	r.RegisterRoute("GET", "/favicon.ico", faviconHandler(r, static)).Name("favicon") // Root level favicon
+	staticRoute := r.RegisterStreamRoute("GET", "/static/*", static.ServeFileStream).Name("static")
+	if site.DownloadLimit != nil {
+		staticRoute.Use(throttle.New(*site.DownloadLimit).Middleware())
+	}
+	r.RegisterStreamRoute("HEAD", "/static/*", static.ServeFileStream)
+
+	// Optional write mode: uploads stream from the connection to disk
+	if site.Uploads != nil {
+		if err := static.EnableWrites(*site.Uploads); err != nil {
+			log.Printf("static uploads disabled: %v", err)
+		} else {
+			r.RegisterStreamRoute("PUT", "/static/*", static.ServeWrite).StreamBody()
+			r.RegisterStreamRoute("DELETE", "/static/*", static.ServeWrite)
+			r.RegisterStreamRoute("MKCOL", "/static/*", static.ServeWrite)
+		}
+	}
+	if site.WebDAV {
+		if err := static.EnableWebDAV(WebDAVOptions{Prefix: "/static/"}); err != nil {
+			log.Printf("static WebDAV disabled: %v", err)
+		} else {
+			for _, method := range webDAVMethods {
+				r.RegisterStreamRoute(method, "/static/*", static.ServeWebDAV)
+			}
+		}
+	}
+
+	// Optional thumbnails: /img/static/images/a.jpg?w=320 resizes /static/images/a.jpg
+	if site.Images != nil {
+		opts := *site.Images
+		opts.Prefix = "/img/"
+		if err := static.EnableImageResizing(opts); err != nil {
+			log.Printf("image resizing disabled: %v", err)
+		} else {
+			r.RegisterStreamRoute("GET", "/img/static/*", static.ServeImage).Name("images")
+			r.RegisterStreamRoute("HEAD", "/img/static/*", static.ServeImage)
+		}
+	}
+
+	// Optional route listing for auditing what the server exposes
+	if site.DebugRoutes {
+		r.RegisterRoute("GET", "/debug/routes", debugRoutesHandler(r)).Name("debug.routes")
+		r.RegisterRoute("GET", "/debug/cache", debugCacheHandler(r, static.CompressionCache())).Name("debug.cache")
+		if files := static.FileCache(); files != nil {
+			r.RegisterRoute("GET", "/debug/filecache", debugStatsHandler(r, files.Stats)).Name("debug.filecache")
+		}
+	}
 
 	return &HTTPHandler{
 		router: r,
+		site:   site,
 	}
 }
 
+// Site returns the layout this handler serves
+func (h *HTTPHandler) Site() SiteConfig {
+	return h.site
+}
+
+// Router exposes the underlying router so callers can register additional
+// buffered or streaming routes (e.g. POST /upload, GET /export, /media/*)
+func (h *HTTPHandler) Router() *router.Router {
+	return h.router
+}
+
 func (h *HTTPHandler) Handle(req *protocol.Request) *protocol.Response {
 	return h.router.Route(req)
 }
 
-func (h *HTTPHandler) NeedsStreaming(req *protocol.Request) bool {
-	return h.router.NeedsStreaming(req)
+// Serve dispatches the request and writes the response to the connection
+// The router decides per route whether the handler is buffered or streaming
+func (h *HTTPHandler) Serve(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+	return h.router.Serve(req, conn, keepAlive, remainingRequests)
 }
 
-func (h *HTTPHandler) HandleStream(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
-	return h.router.RouteStream(req, conn, keepAlive, remainingRequests)
+// subFS returns dir as a file system: a subtree of Files, or a directory on disk
+func (site SiteConfig) subFS(dir string) fs.FS {
+	if site.Files == nil {
+		return os.DirFS(dir)
+	}
+	return subFS(site.Files, path.Clean(filepath.ToSlash(dir)))
 }
 
-func handleHome(req *protocol.Request) *protocol.Response {
-	// Read homepage template
-	htmlBytes, err := os.ReadFile("templates/home.html")
+// subFS is fs.Sub for paths known to be valid ("." returns fsys itself)
+func subFS(fsys fs.FS, dir string) fs.FS {
+	sub, err := fs.Sub(fsys, dir)
 	if err != nil {
-		// Fallback if template file not found
-		resp := protocol.NewResponse(500, "Internal Server Error", req.Version, "Error loading homepage template")
-		resp.Headers["Content-Type"] = "text/plain"
-		return resp
+		return fsys
 	}
+	return sub
+}
 
-	resp := protocol.NewResponse(200, "OK", req.Version, string(htmlBytes))
-	resp.Headers["Content-Type"] = "text/html; charset=utf-8"
+// homeHandler renders home.html from the template file system
+// The template can build links with {{url "route.name" "key" "value"}}
+func homeHandler(templates fs.FS, r *router.Router) router.HandlerFunc {
+	funcs := template.FuncMap{"url": r.URL}
 
-	// Apply gzip compression if beneficial
-	CompressResponse(resp, req)
+	return func(req *protocol.Request) *protocol.Response {
+		// Read and render homepage template
+		var buf bytes.Buffer
+		tmpl, err := template.New("home.html").Funcs(funcs).ParseFS(templates, "home.html")
+		if err == nil {
+			err = tmpl.Execute(&buf, nil)
+		}
+		if err != nil {
+			// Template missing or failing to render: the site's 500 page
+			log.Printf("home.html: %v", err)
+			return r.Error(req, 500, "Internal Server Error")
+		}
 
-	return resp
+		resp := protocol.NewResponse(200, "OK", req.Version, buf.String())
+		resp.Headers["Content-Type"] = "text/html; charset=utf-8"
+
+		// ETag from the rendered page; answers If-None-Match with 304
+		ConditionalResponse(resp, req)
+
+		// Apply gzip compression if beneficial
+		CompressResponse(resp, req)
+
+		return resp
+	}
 }
 
 func handleHello(req *protocol.Request) *protocol.Response {
 	resp := protocol.NewResponse(200, "OK", req.Version, "Hello from Go Web Server!")
 	resp.Headers["Content-Type"] = "text/plain"
 
+	ConditionalResponse(resp, req)
+
 	// Apply gzip compression if beneficial
 	CompressResponse(resp, req)
 
@@ -87,6 +270,8 @@ func handleGetUsers(req *protocol.Request) *protocol.Response {
 	resp := protocol.NewResponse(200, "OK", req.Version, `[{"id":1,"name":"Faizan"},{"id":2,"name":"Hussain"}]`)
 	resp.Headers["Content-Type"] = "application/json"
 
+	ConditionalResponse(resp, req)
+
 	// Apply gzip compression if beneficial
 	CompressResponse(resp, req)
 
@@ -98,22 +283,87 @@ func handleVersion(req *protocol.Request) *protocol.Response {
 	resp := protocol.NewResponse(200, "OK", req.Version, body)
 	resp.Headers["Content-Type"] = "application/json"
 
+	ConditionalResponse(resp, req)
+
 	// Apply gzip compression if beneficial
 	CompressResponse(resp, req)
 
 	return resp
 }
 
-func handleFavicon(req *protocol.Request) *protocol.Response {
-	// Redirect to static favicon
-	content, err := os.ReadFile("public/static/favicon.ico")
-	if err != nil {
-		return protocol.NewResponse(404, "Not Found", req.Version, "Favicon not found")
+// faviconHandler serves static/favicon.ico from the static file server at the root level
+func faviconHandler(r *router.Router, static *FileServer) router.HandlerFunc {
+	const faviconPath = "static/favicon.ico"
+
+	return func(req *protocol.Request) *protocol.Response {
+		// Redirect to static favicon; errors use the site's error pages
+		file, info, err := static.open(faviconPath)
+		if err != nil {
+			return r.Error(req, 404, "Not Found")
+		}
+		defer file.Close()
+
+		content, err := readAll(file)
+		if err != nil {
+			return r.Error(req, 500, "Internal Server Error")
+		}
+
+		resp := protocol.NewResponse(200, "OK", req.Version, string(content))
+		resp.Headers["Content-Type"] = "image/x-icon"
+		resp.Headers["X-Content-Type-Options"] = "nosniff"
+		static.cachePolicy.apply(resp.Headers, faviconPath) // Same rules as /static/ (a day for .ico by default)
+
+		// Same validators as files under /static/
+		resp.Headers["Last-Modified"] = info.ModTime().UTC().Format(httpTimeFormat)
+		if etag, err := static.etag(file, faviconPath, info); err == nil {
+			resp.Headers["ETag"] = etag
+		}
+		ConditionalResponse(resp, req)
+
+		return resp
 	}
+}
 
-	resp := protocol.NewResponse(200, "OK", req.Version, string(content))
-	resp.Headers["Content-Type"] = "image/x-icon"
-	resp.Headers["Cache-Control"] = "public, max-age=86400" // Cache for 24 hours
+// debugRoutesHandler lists the router's routes as JSON (method, pattern, name, streaming, middleware)
+func debugRoutesHandler(r *router.Router) router.HandlerFunc {
+	return func(req *protocol.Request) *protocol.Response {
+		body, err := json.MarshalIndent(r.Routes(), "", "  ")
+		if err != nil {
+			return r.Error(req, 500, "Internal Server Error")
+		}
 
-	return resp
+		resp := protocol.NewResponse(200, "OK", req.Version, string(body))
+		resp.Headers["Content-Type"] = "application/json"
+		resp.Headers["Cache-Control"] = "no-store"
+
+		CompressResponse(resp, req)
+
+		return resp
+	}
+}
+
+// debugCacheHandler reports compression cache metrics as JSON
+func debugCacheHandler(r *router.Router, cache *CompressionCache) router.HandlerFunc {
+	return debugStatsHandler(r, func() CacheStats {
+		if cache == nil {
+			return CacheStats{}
+		}
+		return cache.Stats()
+	})
+}
+
+// debugStatsHandler reports the metrics returned by stats as JSON
+func debugStatsHandler(r *router.Router, stats func() CacheStats) router.HandlerFunc {
+	return func(req *protocol.Request) *protocol.Response {
+		body, err := json.MarshalIndent(stats(), "", "  ")
+		if err != nil {
+			return r.Error(req, 500, "Internal Server Error")
+		}
+
+		resp := protocol.NewResponse(200, "OK", req.Version, string(body))
+		resp.Headers["Content-Type"] = "application/json"
+		resp.Headers["Cache-Control"] = "no-store"
+
+		return resp
+	}
 }
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"webserver/internal/protocol"
+)
+
+// newTestSite creates a site whose static/ directory holds files (name -> content)
+// configure, if given, adjusts the site before the handler is built.
+func newTestSite(t *testing.T, files map[string]string, configure ...func(*SiteConfig)) (*HTTPHandler, string) {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "static"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		full := filepath.Join(root, "static", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	site := DefaultSiteConfig()
+	site.StaticRoot = root
+	site.TemplateDir = t.TempDir()
+	for _, fn := range configure {
+		fn(&site)
+	}
+	return NewSiteHandler(site), root
+}
+
+func TestFaviconUsesErrorHandlers(t *testing.T) {
+	h, root := newTestSite(t, nil)
+	h.Router().RegisterErrorHandler("/*", 404, func(req *protocol.Request, code int, status string) *protocol.Response {
+		return protocol.NewResponse(code, status, req.Version, "custom 404")
+	})
+
+	req := &protocol.Request{Method: "GET", Path: "/favicon.ico", Version: protocol.HTTP11, Headers: map[string]string{}}
+	resp := h.Handle(req)
+	if resp.StatusCode != 404 || resp.Body != "custom 404" {
+		t.Errorf("missing favicon: got %d %q, want the custom 404 page", resp.StatusCode, resp.Body)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "static", "favicon.ico"), []byte("icon"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	resp = h.Handle(req)
+	if resp.StatusCode != 200 || resp.Body != "icon" {
+		t.Errorf("favicon: got %d %q, want 200 \"icon\"", resp.StatusCode, resp.Body)
+	}
+}
+
+func TestHomeTemplateErrorUsesErrorHandlers(t *testing.T) {
+	h, _ := newTestSite(t, nil) // No home.html in the template directory
+	h.Router().RegisterErrorHandler("/*", 500, func(req *protocol.Request, code int, status string) *protocol.Response {
+		return protocol.NewResponse(code, status, req.Version, "custom 500")
+	})
+
+	resp := h.Handle(&protocol.Request{Method: "GET", Path: "/", Version: protocol.HTTP11, Headers: map[string]string{}})
+	if resp.StatusCode != 500 || resp.Body != "custom 500" {
+		t.Errorf("missing template: got %d %q, want the custom 500 page", resp.StatusCode, resp.Body)
+	}
+}
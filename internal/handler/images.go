@@ -0,0 +1,480 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	iofs "io/fs"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+)
+
+// Limits applied when ImageOptions leaves them at 0
+const (
+	DefaultMaxImageSize      = 2048              // Widest and tallest output, in pixels
+	DefaultMaxSourcePixels   = 50_000_000        // Larger sources are refused before decoding
+	DefaultImageQuality      = 82                // JPEG quality when the request has no q
+	DefaultImageCacheSize    = 256 * 1024 * 1024 // 256MB of derivatives on disk
+	derivativeCacheVersion   = "1"               // Bump when the output for the same parameters changes
+	derivativeRefreshAge     = time.Hour         // Hits refresh an entry's mtime (its LRU age) at most this often
+	derivativeCachePruneRate = 0.9               // Pruning stops at this fraction of CacheSize
+)
+
+// imageFormats are the output formats, by the name used in ?fm= and by image.Decode
+var imageFormats = map[string]struct {
+	ext, contentType string
+}{
+	"jpeg": {".jpg", "image/jpeg"},
+	"png":  {".png", "image/png"},
+	"gif":  {".gif", "image/gif"},
+}
+
+// ImageOptions configures the image resizing endpoint of a FileServer
+type ImageOptions struct {
+	Prefix          string // URL prefix of the endpoint ("/img/"); the rest of the path is the source
+	CacheDir        string // Directory for resized copies ("" = resize on every request)
+	CacheSize       int64  // Disk budget for CacheDir; least recently used copies go first (0 = DefaultImageCacheSize)
+	MaxWidth        int    // Widest output allowed (0 = DefaultMaxImageSize)
+	MaxHeight       int    // Tallest output allowed (0 = DefaultMaxImageSize)
+	MaxSourcePixels int64  // Largest source image, in pixels, that is decoded (0 = DefaultMaxSourcePixels)
+	Quality         int    // JPEG quality without ?q= (0 = DefaultImageQuality)
+}
+
+// imageState is the resizing endpoint of a FileServer
+type imageState struct {
+	opts    ImageOptions
+	workers chan struct{} // Bounds concurrent decodes to the number of CPUs
+	used    atomic.Int64  // Bytes in CacheDir
+	pruning atomic.Bool
+}
+
+// imageParams is a parsed resize request
+type imageParams struct {
+	width, height int    // 0 = follow the aspect ratio
+	fit           string // contain, cover or fill
+	quality       int
+	format        string // Output format ("" = same as the source)
+}
+
+// EnableImageResizing serves scaled copies of the images under the root, as
+// in GET /img/static/images/photo.jpg?w=320&h=240&fit=cover&q=75&fm=png:
+//
+//   - w, h: output size in pixels; with only one, the other keeps the aspect ratio
+//   - fit: contain (default) scales into the box, cover fills it and crops the
+//     overflow evenly, fill stretches to exactly w x h
+//   - q: JPEG quality 1-100; fm: jpeg, png or gif (default: the source format)
+//
+// JPEG, PNG and GIF sources are decoded with the standard library (GIFs by
+// their first frame) and never enlarged. Sources are opened under the access
+// policy like any GET. Resized copies are kept in CacheDir, keyed by the
+// parameters and the source's size and mtime, so an edited image is resized
+// again; copies of old versions age out of the cache.
+func (fs *FileServer) EnableImageResizing(opts ImageOptions) error {
+	if opts.Prefix == "" {
+		opts.Prefix = "/img/"
+	}
+	if opts.CacheSize <= 0 {
+		opts.CacheSize = DefaultImageCacheSize
+	}
+	if opts.MaxWidth <= 0 {
+		opts.MaxWidth = DefaultMaxImageSize
+	}
+	if opts.MaxHeight <= 0 {
+		opts.MaxHeight = DefaultMaxImageSize
+	}
+	if opts.MaxSourcePixels <= 0 {
+		opts.MaxSourcePixels = DefaultMaxSourcePixels
+	}
+	if opts.Quality <= 0 || opts.Quality > 100 {
+		opts.Quality = DefaultImageQuality
+	}
+
+	images := &imageState{opts: opts, workers: make(chan struct{}, runtime.NumCPU())}
+	if opts.CacheDir != "" {
+		if err := os.MkdirAll(opts.CacheDir, 0o755); err != nil {
+			return fmt.Errorf("image cache: %w", err)
+		}
+		images.used.Store(images.cacheUsage())
+	}
+	fs.images = images
+	return nil
+}
+
+// ServeImage handles GET and HEAD under the ImageOptions prefix
+func (fs *FileServer) ServeImage(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+	images := fs.images
+	if images == nil {
+		return fs.sendError(conn, req, 404, "Not Found", keepAlive, remainingRequests)
+	}
+
+	rel, err := cleanRequestPath(strings.TrimPrefix(req.Path, strings.TrimSuffix(images.opts.Prefix, "/")))
+	if err != nil {
+		return fs.sendError(conn, req, 400, "Bad Request", keepAlive, remainingRequests)
+	}
+	params, err := images.parseParams(req)
+	if err != nil {
+		return fs.sendError(conn, req, 400, err.Error(), keepAlive, remainingRequests)
+	}
+
+	file, info, err := fs.open(rel)
+	if err != nil {
+		code, status := openErrorStatus(err)
+		return fs.sendError(conn, req, code, status, keepAlive, remainingRequests)
+	}
+	defer file.Close()
+	if !info.Mode().IsRegular() {
+		return fs.sendError(conn, req, 404, "Not Found", keepAlive, remainingRequests)
+	}
+
+	// Every variant of every version of the source has its own key
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%d\x00%d\x00%d\x00%d\x00%s\x00%d\x00%s",
+		derivativeCacheVersion, rel, info.Size(), info.ModTime().UnixNano(),
+		params.width, params.height, params.fit, params.quality, params.format)))
+	key := hex.EncodeToString(sum[:])
+	etag := `"` + key[:32] + `"`
+	modTime := info.ModTime()
+
+	switch EvaluatePreconditions(req, etag, modTime) {
+	case 304:
+		return fs.sendNotModified(conn, rel, modTime, etag, req.Version, keepAlive, remainingRequests)
+	case 412:
+		return fs.sendError(conn, req, 412, "Precondition Failed", keepAlive, remainingRequests)
+	}
+
+	// Cached copy
+	if cached, format, size := images.lookup(key); cached != nil {
+		defer cached.Close()
+		return fs.sendImage(req, conn, cached, size, format, rel, modTime, etag, keepAlive, remainingRequests)
+	}
+
+	body, format, err := images.render(io.NewSectionReader(file, 0, info.Size()), params)
+	switch {
+	case errors.Is(err, image.ErrFormat):
+		return fs.sendError(conn, req, 415, "Unsupported Media Type", keepAlive, remainingRequests)
+	case errors.Is(err, errImageTooLarge):
+		return fs.sendError(conn, req, 422, "Image too large to resize", keepAlive, remainingRequests)
+	case err != nil:
+		log.Printf("resize %s: %v", rel, err)
+		return fs.sendError(conn, req, 500, "Internal Server Error", keepAlive, remainingRequests)
+	}
+	images.store(key, format, body)
+
+	return fs.sendImage(req, conn, bytes.NewReader(body), int64(len(body)), format, rel, modTime, etag, keepAlive, remainingRequests)
+}
+
+// sendImage sends a resized image
+func (fs *FileServer) sendImage(req *protocol.Request, conn *tcp.TCPConn, body io.ReaderAt, size int64, format, rel string, modTime time.Time, etag string, keepAlive bool, remainingRequests int) error {
+	resp := protocol.NewResponse(200, "OK", req.Version, "")
+
+	// Set headers
+	resp.Headers["Content-Type"] = imageFormats[format].contentType
+	resp.Headers["X-Content-Type-Options"] = "nosniff"
+	resp.Headers["Content-Length"] = fmt.Sprintf("%d", size)
+	resp.Headers["Last-Modified"] = modTime.UTC().Format(httpTimeFormat)
+	resp.Headers["ETag"] = etag
+	fs.cachePolicy.apply(resp.Headers, rel)
+
+	// Set Connection headers for keep-alive
+	if keepAlive {
+		resp.Headers["Connection"] = "keep-alive"
+		resp.Headers["Keep-Alive"] = fmt.Sprintf("timeout=30, max=%d", remainingRequests)
+	} else {
+		resp.Headers["Connection"] = "close"
+	}
+
+	if err := protocol.WriteHeaders(conn, resp); err != nil {
+		return err
+	}
+	if req.Method == "HEAD" {
+		return nil
+	}
+	return sendFileRange(conn, body, 0, size)
+}
+
+// parseParams reads w, h, fit, q and fm from the query string
+func (images *imageState) parseParams(req *protocol.Request) (imageParams, error) {
+	_, rawQuery, _ := strings.Cut(req.Path, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return imageParams{}, errors.New("Bad query string")
+	}
+
+	params := imageParams{fit: "contain", quality: images.opts.Quality}
+	size := func(name string, max int) (int, error) {
+		value := query.Get(name)
+		if value == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("Bad %s", name)
+		}
+		if n > max {
+			return 0, fmt.Errorf("%s exceeds %d", name, max)
+		}
+		return n, nil
+	}
+	if params.width, err = size("w", images.opts.MaxWidth); err != nil {
+		return imageParams{}, err
+	}
+	if params.height, err = size("h", images.opts.MaxHeight); err != nil {
+		return imageParams{}, err
+	}
+
+	if fit := query.Get("fit"); fit != "" {
+		if fit != "contain" && fit != "cover" && fit != "fill" {
+			return imageParams{}, errors.New("Bad fit")
+		}
+		params.fit = fit
+	}
+	if q := query.Get("q"); q != "" {
+		n, err := strconv.Atoi(q)
+		if err != nil || n < 1 || n > 100 {
+			return imageParams{}, errors.New("Bad q")
+		}
+		params.quality = n
+	}
+	if fm := strings.ToLower(query.Get("fm")); fm != "" {
+		if fm == "jpg" {
+			fm = "jpeg"
+		}
+		if _, ok := imageFormats[fm]; !ok {
+			return imageParams{}, errors.New("Bad fm")
+		}
+		params.format = fm
+	}
+
+	// The quality only matters for JPEG output; leaving it out of other
+	// formats' keys avoids caching identical copies
+	if params.format != "" && params.format != "jpeg" {
+		params.quality = 0
+	}
+	return params, nil
+}
+
+// errImageTooLarge is returned for sources over MaxSourcePixels
+var errImageTooLarge = errors.New("source image too large")
+
+// render decodes src, resizes it and encodes the result
+func (images *imageState) render(src io.ReadSeeker, params imageParams) ([]byte, string, error) {
+	// The header alone tells the size, before any pixels are allocated
+	config, format, err := image.DecodeConfig(src)
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(config.Width)*int64(config.Height) > images.opts.MaxSourcePixels {
+		return nil, "", errImageTooLarge
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, "", err
+	}
+
+	images.workers <- struct{}{}
+	defer func() { <-images.workers }()
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return nil, "", err
+	}
+	crop, width, height := images.layout(img.Bounds(), params)
+	out := resample(img, crop, width, height)
+
+	if params.format != "" {
+		format = params.format
+	}
+	if params.quality == 0 {
+		params.quality = images.opts.Quality
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, flatten(out, color.White), &jpeg.Options{Quality: params.quality})
+	case "gif":
+		err = gif.Encode(&buf, out, &gif.Options{NumColors: 256})
+	default:
+		format = "png"
+		err = png.Encode(&buf, out)
+	}
+	return buf.Bytes(), format, err
+}
+
+// layout returns the part of the source to use and the output size
+// Images are never enlarged: a box larger than the source shrinks to fit it.
+func (images *imageState) layout(bounds image.Rectangle, params imageParams) (image.Rectangle, int, int) {
+	srcW, srcH := float64(bounds.Dx()), float64(bounds.Dy())
+	w, h := float64(params.width), float64(params.height)
+
+	// A missing side follows the aspect ratio; with neither, the source size
+	// is kept within the output limits
+	switch {
+	case w == 0 && h == 0:
+		w, h = srcW, srcH
+		if w > float64(images.opts.MaxWidth) || h > float64(images.opts.MaxHeight) {
+			scale := math.Min(float64(images.opts.MaxWidth)/w, float64(images.opts.MaxHeight)/h)
+			w, h = w*scale, h*scale
+		}
+	case w == 0:
+		w = math.Min(h*srcW/srcH, float64(images.opts.MaxWidth))
+	case h == 0:
+		h = math.Min(w*srcH/srcW, float64(images.opts.MaxHeight))
+	}
+
+	crop := bounds
+	switch params.fit {
+	case "cover":
+		scale := math.Max(w/srcW, h/srcH)
+		if scale > 1 {
+			w, h, scale = w/scale, h/scale, 1
+		}
+		cropW, cropH := int(math.Round(w/scale)), int(math.Round(h/scale))
+		x := bounds.Min.X + (bounds.Dx()-cropW)/2
+		y := bounds.Min.Y + (bounds.Dy()-cropH)/2
+		crop = image.Rect(x, y, x+cropW, y+cropH).Intersect(bounds)
+	case "fill":
+		w, h = math.Min(w, srcW), math.Min(h, srcH)
+	default:
+		scale := math.Min(math.Min(w/srcW, h/srcH), 1)
+		w, h = srcW*scale, srcH*scale
+	}
+	return crop, max(int(math.Round(w)), 1), max(int(math.Round(h)), 1)
+}
+
+// cachePath is where the copy with key is kept
+func (images *imageState) cachePath(key, format string) string {
+	return filepath.Join(images.opts.CacheDir, key[:2], key+imageFormats[format].ext)
+}
+
+// lookup opens the cached copy with key (nil if there is none)
+func (images *imageState) lookup(key string) (*os.File, string, int64) {
+	if images.opts.CacheDir == "" {
+		return nil, "", 0
+	}
+	for format := range imageFormats {
+		name := images.cachePath(key, format)
+		file, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			continue
+		}
+		// The mtime is the entry's last use, for pruning
+		if now := time.Now(); now.Sub(info.ModTime()) > derivativeRefreshAge {
+			os.Chtimes(name, now, now)
+		}
+		return file, format, info.Size()
+	}
+	return nil, "", 0
+}
+
+// store writes a resized copy to the cache
+// The copy is written under a temporary name and renamed into place, so
+// concurrent requests never see half a file. Failures only cost a cache miss.
+func (images *imageState) store(key, format string, body []byte) {
+	if images.opts.CacheDir == "" {
+		return
+	}
+	name := images.cachePath(key, format)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		log.Printf("image cache: %v", err)
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		log.Printf("image cache: %v", err)
+		return
+	}
+	_, err = tmp.Write(body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("image cache: %v", err)
+		return
+	}
+
+	if images.used.Add(int64(len(body))) > images.opts.CacheSize && images.pruning.CompareAndSwap(false, true) {
+		go images.prune()
+	}
+}
+
+// cachedCopy is one file in CacheDir
+type cachedCopy struct {
+	path    string
+	size    int64
+	lastUse time.Time
+}
+
+// cacheCopies lists the files in CacheDir
+func (images *imageState) cacheCopies() []cachedCopy {
+	var copies []cachedCopy
+	filepath.WalkDir(images.opts.CacheDir, func(name string, entry iofs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			copies = append(copies, cachedCopy{path: name, size: info.Size(), lastUse: info.ModTime()})
+		}
+		return nil
+	})
+	return copies
+}
+
+// cacheUsage adds up the size of CacheDir
+func (images *imageState) cacheUsage() int64 {
+	var total int64
+	for _, c := range images.cacheCopies() {
+		total += c.size
+	}
+	return total
+}
+
+// prune removes the least recently used copies until the cache is back
+// under its budget, with some room to spare
+func (images *imageState) prune() {
+	defer images.pruning.Store(false)
+
+	copies := images.cacheCopies()
+	sort.Slice(copies, func(i, j int) bool { return copies[i].lastUse.Before(copies[j].lastUse) })
+
+	var total int64
+	for _, c := range copies {
+		total += c.size
+	}
+	target := int64(float64(images.opts.CacheSize) * derivativeCachePruneRate)
+	for _, c := range copies {
+		if total <= target {
+			break
+		}
+		if err := os.Remove(c.path); err == nil {
+			total -= c.size
+		}
+	}
+	images.used.Store(total)
+}
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"io"
+	"os"
+	"testing"
+	"time"
+	"webserver/internal/protocol"
+)
+
+// newImageState enables resizing on a throwaway FileServer, so opts get the
+// same defaults as in production
+func newImageState(t *testing.T, opts ImageOptions) *imageState {
+	t.Helper()
+	fs := NewFileServer(t.TempDir())
+	if err := fs.EnableImageResizing(opts); err != nil {
+		t.Fatal(err)
+	}
+	return fs.images
+}
+
+func TestImageLayout(t *testing.T) {
+	images := newImageState(t, ImageOptions{MaxWidth: 1000, MaxHeight: 800})
+	src := image.Rect(0, 0, 400, 200)
+
+	tests := []struct {
+		name          string
+		bounds        image.Rectangle
+		params        imageParams
+		crop          image.Rectangle
+		width, height int
+	}{
+		// contain scales into the box, keeping the aspect ratio
+		{"contain", src, imageParams{width: 100, height: 100, fit: "contain"}, src, 100, 50},
+		{"contain tall box", src, imageParams{width: 300, height: 50, fit: "contain"}, src, 100, 50},
+		{"contain never enlarges", src, imageParams{width: 1000, height: 800, fit: "contain"}, src, 400, 200},
+
+		// cover fills the box and crops the overflow evenly
+		{"cover", src, imageParams{width: 100, height: 100, fit: "cover"}, image.Rect(100, 0, 300, 200), 100, 100},
+		{"cover wide box", src, imageParams{width: 200, height: 50, fit: "cover"}, image.Rect(0, 50, 400, 150), 200, 50},
+		{"cover never enlarges", src, imageParams{width: 800, height: 800, fit: "cover"}, image.Rect(100, 0, 300, 200), 200, 200},
+		{"cover offset bounds", image.Rect(10, 10, 410, 210), imageParams{width: 100, height: 100, fit: "cover"}, image.Rect(110, 10, 310, 210), 100, 100},
+
+		// fill stretches to the box
+		{"fill", src, imageParams{width: 100, height: 100, fit: "fill"}, src, 100, 100},
+		{"fill never enlarges", src, imageParams{width: 1000, height: 50, fit: "fill"}, src, 400, 50},
+
+		// A missing side follows the aspect ratio
+		{"width only", src, imageParams{width: 100, fit: "contain"}, src, 100, 50},
+		{"height only", src, imageParams{height: 100, fit: "contain"}, src, 200, 100},
+		{"width only never enlarges", src, imageParams{width: 800, fit: "contain"}, src, 400, 200},
+		{"width only cover", src, imageParams{width: 100, fit: "cover"}, src, 100, 50},
+		{"height only capped by MaxWidth", image.Rect(0, 0, 4000, 1000), imageParams{height: 800, fit: "contain"}, image.Rect(0, 0, 4000, 1000), 1000, 250},
+
+		// Neither side: the source size within the limits
+		{"no size", src, imageParams{fit: "contain"}, src, 400, 200},
+		{"no size capped by MaxWidth", image.Rect(0, 0, 4000, 1000), imageParams{fit: "contain"}, image.Rect(0, 0, 4000, 1000), 1000, 250},
+		{"no size capped by MaxHeight", image.Rect(0, 0, 1000, 4000), imageParams{fit: "contain"}, image.Rect(0, 0, 1000, 4000), 200, 800},
+
+		// Outputs are at least one pixel
+		{"thin source", image.Rect(0, 0, 1000, 1), imageParams{width: 10, fit: "contain"}, image.Rect(0, 0, 1000, 1), 10, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crop, width, height := images.layout(tt.bounds, tt.params)
+			if crop != tt.crop || width != tt.width || height != tt.height {
+				t.Errorf("layout = %v %dx%d, want %v %dx%d", crop, width, height, tt.crop, tt.width, tt.height)
+			}
+		})
+	}
+}
+
+func TestImageParseParams(t *testing.T) {
+	images := newImageState(t, ImageOptions{MaxWidth: 1000, MaxHeight: 800})
+
+	tests := []struct {
+		query   string
+		want    imageParams
+		wantErr string
+	}{
+		{"", imageParams{fit: "contain", quality: DefaultImageQuality}, ""},
+		{"?w=320&h=240&fit=cover&q=75", imageParams{width: 320, height: 240, fit: "cover", quality: 75}, ""},
+		{"?w=1000&h=800&fit=fill", imageParams{width: 1000, height: 800, fit: "fill", quality: DefaultImageQuality}, ""},
+		{"?fm=jpg&q=50", imageParams{fit: "contain", quality: 50, format: "jpeg"}, ""},
+		{"?fm=JPEG", imageParams{fit: "contain", quality: DefaultImageQuality, format: "jpeg"}, ""},
+		{"?fm=png&q=50", imageParams{fit: "contain", format: "png"}, ""}, // Quality only matters for JPEG
+		{"?fm=gif", imageParams{fit: "contain", format: "gif"}, ""},
+
+		// Limits
+		{"?w=1001", imageParams{}, "w exceeds 1000"},
+		{"?h=801", imageParams{}, "h exceeds 800"},
+		{"?w=0", imageParams{}, "Bad w"},
+		{"?h=-5", imageParams{}, "Bad h"},
+		{"?w=abc", imageParams{}, "Bad w"},
+
+		// Bad q, fm and fit
+		{"?q=0", imageParams{}, "Bad q"},
+		{"?q=101", imageParams{}, "Bad q"},
+		{"?q=high", imageParams{}, "Bad q"},
+		{"?fm=webp", imageParams{}, "Bad fm"},
+		{"?fm=", imageParams{fit: "contain", quality: DefaultImageQuality}, ""},
+		{"?fit=stretch", imageParams{}, "Bad fit"},
+		{"?fit=COVER", imageParams{}, "Bad fit"},
+		{"?w=%zz", imageParams{}, "Bad query string"},
+	}
+	for _, tt := range tests {
+		req := &protocol.Request{Method: "GET", Path: "/img/static/a.jpg" + tt.query, Version: protocol.HTTP11, Headers: map[string]string{}}
+		got, err := images.parseParams(req)
+		switch {
+		case tt.wantErr != "":
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseParams(%q) error = %v, want %q", tt.query, err, tt.wantErr)
+			}
+		case err != nil:
+			t.Errorf("parseParams(%q) error = %v", tt.query, err)
+		case got != tt.want:
+			t.Errorf("parseParams(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
+
+// imageKey returns a derivative cache key for name
+func imageKey(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestDerivativeCache(t *testing.T) {
+	dir := t.TempDir()
+	images := newImageState(t, ImageOptions{CacheDir: dir, CacheSize: 1 << 20})
+	body := bytes.Repeat([]byte("x"), 300)
+
+	if file, _, _ := images.lookup(imageKey("a")); file != nil {
+		file.Close()
+		t.Fatal("lookup found a copy in an empty cache")
+	}
+
+	// Store and look up
+	images.store(imageKey("a"), "png", body)
+	file, format, size := images.lookup(imageKey("a"))
+	if file == nil {
+		t.Fatal("lookup missed a stored copy")
+	}
+	got, _ := io.ReadAll(file)
+	file.Close()
+	if format != "png" || size != 300 || !bytes.Equal(got, body) {
+		t.Errorf("lookup = %s, %d bytes, want png, 300 bytes", format, size)
+	}
+	if used := images.used.Load(); used != 300 {
+		t.Errorf("used = %d, want 300", used)
+	}
+
+	// A hit refreshes an old entry's last use
+	old := time.Now().Add(-2 * derivativeRefreshAge)
+	os.Chtimes(images.cachePath(imageKey("a"), "png"), old, old)
+	if file, _, _ := images.lookup(imageKey("a")); file != nil {
+		file.Close()
+	}
+	if info, err := os.Stat(images.cachePath(imageKey("a"), "png")); err != nil || time.Since(info.ModTime()) > time.Minute {
+		t.Errorf("lookup didn't refresh the last use of an old entry")
+	}
+
+	// Four entries, last used an hour apart, in a 1000-byte cache: pruning
+	// removes the oldest until 900 bytes (90%) remain
+	for i, name := range []string{"a", "b", "c", "d"} {
+		images.store(imageKey(name), "jpeg", body)
+		lastUse := time.Now().Add(-time.Duration(4-i) * time.Hour)
+		os.Chtimes(images.cachePath(imageKey(name), "jpeg"), lastUse, lastUse)
+	}
+	os.Remove(images.cachePath(imageKey("a"), "png"))
+	images.opts.CacheSize = 1000
+	images.prune()
+
+	// Checked with stat: a lookup would refresh the entries' last use
+	cached := func(name, format string) bool {
+		_, err := os.Stat(images.cachePath(imageKey(name), format))
+		return err == nil
+	}
+	for name, want := range map[string]bool{"a": false, "b": true, "c": true, "d": true} {
+		if got := cached(name, "jpeg"); got != want {
+			t.Errorf("after prune, %s cached = %v, want %v", name, got, want)
+		}
+	}
+	if used := images.used.Load(); used != 900 {
+		t.Errorf("used after prune = %d, want 900", used)
+	}
+
+	// Going over the budget prunes in the background
+	images.store(imageKey("e"), "gif", body)
+	deadline := time.Now().Add(5 * time.Second)
+	for images.used.Load() > 900 || images.pruning.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("cache not pruned: %d bytes used", images.used.Load())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if cached("b", "jpeg") {
+		t.Error("the least recently used entry survived the background prune")
+	}
+	if !cached("e", "gif") {
+		t.Error("the newest entry was pruned")
+	}
+
+	// A restart counts the copies already on disk
+	if used := newImageState(t, ImageOptions{CacheDir: dir}).used.Load(); used != 900 {
+		t.Errorf("used after restart = %d, want 900", used)
+	}
+}
+
+func TestDerivativeCacheDisabled(t *testing.T) {
+	images := newImageState(t, ImageOptions{})
+	images.store(imageKey("a"), "png", []byte("body"))
+	if file, _, _ := images.lookup(imageKey("a")); file != nil {
+		file.Close()
+		t.Error("lookup found a copy without a CacheDir")
+	}
+}
@@ -0,0 +1,216 @@
+//go:build linux
+
+package handler
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// watchMask is every inotify event that can change what a path serves
+const watchMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_DELETE_SELF |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_MOVE_SELF |
+	syscall.IN_ONLYDIR
+
+// inotifyWatcher watches every directory under a root and reports changed paths
+// inotify is not recursive, so a watch is added per directory, including
+// directories created or moved in later. onChange receives the path relative
+// to the root ("static/css/style.css"); onOverflow means events were lost.
+type inotifyWatcher struct {
+	root       string
+	fd         int      // inotify instance, for adding and removing watches
+	file       *os.File // The same fd, non-blocking, read through the runtime poller
+	onChange   func(rel string)
+	onOverflow func()
+
+	mu    sync.Mutex
+	dirs  map[int32]string // Watch descriptor -> directory relative to the root
+	byDir map[string]int32 // Directory -> watch descriptor
+}
+
+// newInotifyWatcher starts watching root and its subdirectories
+func newInotifyWatcher(root string, onChange func(string), onOverflow func()) (*inotifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	w := &inotifyWatcher{
+		root:       root,
+		fd:         fd,
+		file:       os.NewFile(uintptr(fd), "inotify"),
+		onChange:   onChange,
+		onOverflow: onOverflow,
+		dirs:       make(map[int32]string),
+		byDir:      make(map[string]int32),
+	}
+	if err := w.addTree(""); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	go w.run()
+	return w, nil
+}
+
+// watched reports whether dir (relative to the root) has a watch
+// Files in unwatched directories must not be cached: nobody would invalidate them.
+func (w *inotifyWatcher) watched(dir string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, ok := w.byDir[dir]
+	return ok
+}
+
+// Close stops the watcher; the event loop exits on the next read
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
+
+// addTree watches dir and every directory below it
+// Only the root failing is an error; subdirectories that cannot be watched
+// (e.g. fs.inotify.max_user_watches reached) are logged and left uncached.
+func (w *inotifyWatcher) addTree(dir string) error {
+	return filepath.WalkDir(filepath.Join(w.root, filepath.FromSlash(dir)), func(p string, d fs.DirEntry, err error) error {
+		rel, relErr := filepath.Rel(w.root, p)
+		if relErr != nil {
+			return relErr
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			rel = ""
+		}
+
+		if err != nil {
+			if rel == "" {
+				return err
+			}
+			return nil // Vanished or unreadable: nothing below it gets cached
+		}
+		if !d.IsDir() {
+			return nil // WalkDir does not follow symlinks, so linked directories are skipped
+		}
+
+		if err := w.add(rel); err != nil {
+			if rel == "" {
+				return err
+			}
+			log.Printf("file cache: not watching %s: %v", p, err)
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
+
+// add watches one directory
+func (w *inotifyWatcher) add(dir string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, filepath.Join(w.root, filepath.FromSlash(dir)), watchMask)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.dirs[int32(wd)] = dir
+	w.byDir[dir] = int32(wd)
+	return nil
+}
+
+// removeTree forgets dir and everything below it (it was moved or deleted)
+// The kernel drops watches of deleted directories itself (IN_IGNORED); a
+// moved directory keeps its watches, which would now report the wrong paths.
+func (w *inotifyWatcher) removeTree(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for d, wd := range w.byDir {
+		if d == dir || strings.HasPrefix(d, dir+"/") {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.byDir, d)
+			delete(w.dirs, wd)
+		}
+	}
+}
+
+// run reads and dispatches events until the watcher is closed
+func (w *inotifyWatcher) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				log.Printf("file cache: inotify read: %v", err)
+				w.onOverflow() // Can no longer trust the cache
+			}
+			return
+		}
+		w.dispatch(buf[:n])
+	}
+}
+
+// dispatch handles a batch of raw inotify events
+func (w *inotifyWatcher) dispatch(buf []byte) {
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= len(buf); {
+		event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+		nameStart := offset + syscall.SizeofInotifyEvent
+		nameEnd := nameStart + int(event.Len)
+		if nameEnd > len(buf) {
+			return
+		}
+		name := strings.TrimRight(string(buf[nameStart:nameEnd]), "\x00")
+		offset = nameEnd
+
+		w.handle(event.Wd, event.Mask, name)
+	}
+}
+
+// handle reacts to one event
+func (w *inotifyWatcher) handle(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.onOverflow()
+		return
+	}
+
+	w.mu.Lock()
+	dir, ok := w.dirs[wd]
+	if ok && mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+		if w.byDir[dir] == wd {
+			delete(w.byDir, dir)
+		}
+	}
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	rel := dir
+	if name != "" {
+		rel = path.Join(dir, name)
+	}
+
+	isDir := mask&syscall.IN_ISDIR != 0
+	switch {
+	case isDir && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		// Watch the new subtree before invalidating, so nothing cached in
+		// between can be missed
+		if err := w.addTree(rel); err != nil {
+			log.Printf("file cache: watch %s: %v", rel, err)
+		}
+	case isDir && mask&(syscall.IN_MOVED_FROM|syscall.IN_DELETE) != 0:
+		w.removeTree(rel)
+	}
+
+	w.onChange(rel)
+}
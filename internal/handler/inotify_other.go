@@ -0,0 +1,20 @@
+//go:build !linux
+
+package handler
+
+import (
+	"errors"
+	"runtime"
+)
+
+// inotifyWatcher is Linux-only; elsewhere EnableFileCache fails and every
+// request keeps going to disk
+type inotifyWatcher struct{}
+
+func newInotifyWatcher(root string, onChange func(string), onOverflow func()) (*inotifyWatcher, error) {
+	return nil, errors.New("inotify is not available on " + runtime.GOOS)
+}
+
+func (w *inotifyWatcher) watched(dir string) bool { return false }
+
+func (w *inotifyWatcher) Close() error { return nil }
@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	iofs "io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+)
+
+// DefaultListingPageSize is the number of entries per listing page
+const DefaultListingPageSize = 500
+
+// ListingOptions configures directory listings for a FileServer
+type ListingOptions struct {
+	Prefix     string // URL prefix the file server is mounted at (e.g. "/static/"); no parent link above it
+	ShowHidden bool   // List dotfiles (hidden by default)
+	PageSize   int    // Entries per page (0 = DefaultListingPageSize)
+}
+
+// listingEntry is one row of a directory listing
+type listingEntry struct {
+	Name    string    `json:"name"`
+	URL     string    `json:"url"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// listingPage is the data rendered as HTML or JSON
+type listingPage struct {
+	Path     string         `json:"path"`
+	Parent   string         `json:"parent,omitempty"`
+	Entries  []listingEntry `json:"entries"`
+	Sort     string         `json:"sort"`
+	Order    string         `json:"order"`
+	Page     int            `json:"page"`
+	Pages    int            `json:"pages"`
+	PageSize int            `json:"pageSize"`
+	Total    int            `json:"total"`
+	Prev     string         `json:"prev,omitempty"`
+	Next     string         `json:"next,omitempty"`
+}
+
+// SetDirectoryListing enables listings for directories without index.html
+// Without it (the default) such directories return 403.
+func (fs *FileServer) SetDirectoryListing(opts ListingOptions) {
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultListingPageSize
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "/"
+	}
+	fs.listing = &opts
+}
+
+// serveDirectory renders a listing of dirPath for the request's URL path
+// Query parameters: sort=name|size|mtime, order=asc|desc, page=N (from 1).
+// Clients sending "Accept: application/json" get JSON instead of HTML.
+// dir is the open directory and rel its path relative to the root.
+func (fs *FileServer) serveDirectory(req *protocol.Request, conn *tcp.TCPConn, dir staticFile, rel string, keepAlive bool, remainingRequests int) error {
+	opts := fs.listing
+	urlPath, rawQuery, _ := strings.Cut(req.Path, "?")
+	if !strings.HasSuffix(urlPath, "/") {
+		urlPath += "/"
+	}
+	query, _ := url.ParseQuery(rawQuery)
+
+	readDir, ok := dir.(iofs.ReadDirFile)
+	if !ok {
+		return fs.sendError(conn, req, 500, "Internal Server Error", keepAlive, remainingRequests)
+	}
+	dirEntries, err := readDir.ReadDir(-1)
+	if err != nil {
+		return fs.sendError(conn, req, 500, "Error reading directory", keepAlive, remainingRequests)
+	}
+
+	var entries []listingEntry
+	for _, de := range dirEntries {
+		name := de.Name()
+		if !opts.ShowHidden && strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !fs.access.allowed(path.Join(rel, name)) {
+			continue // Would 404 anyway
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue // Removed while listing
+		}
+		entry := listingEntry{
+			Name:    name,
+			URL:     urlPath + url.PathEscape(name),
+			IsDir:   info.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+		}
+		if entry.IsDir {
+			entry.URL += "/"
+			entry.Size = 0
+		}
+		entries = append(entries, entry)
+	}
+
+	page := listingPage{
+		Path:     urlPath,
+		Sort:     query.Get("sort"),
+		Order:    query.Get("order"),
+		PageSize: opts.PageSize,
+		Total:    len(entries),
+	}
+	sortListing(entries, &page)
+
+	// Parent link, unless this is the top of the mount
+	if urlPath != opts.Prefix && strings.HasPrefix(urlPath, opts.Prefix) {
+		page.Parent = path.Dir(strings.TrimSuffix(urlPath, "/"))
+		if !strings.HasSuffix(page.Parent, "/") {
+			page.Parent += "/"
+		}
+	}
+
+	// Pagination
+	page.Pages = (len(entries) + opts.PageSize - 1) / opts.PageSize
+	if page.Pages == 0 {
+		page.Pages = 1
+	}
+	page.Page, _ = strconv.Atoi(query.Get("page"))
+	if page.Page < 1 {
+		page.Page = 1
+	}
+	if page.Page > page.Pages {
+		page.Page = page.Pages
+	}
+	start := (page.Page - 1) * opts.PageSize
+	end := start + opts.PageSize
+	if end > len(entries) {
+		end = len(entries)
+	}
+	page.Entries = entries[start:end]
+	if page.Entries == nil {
+		page.Entries = []listingEntry{}
+	}
+	if page.Page > 1 {
+		page.Prev = page.link(page.Sort, page.Order, page.Page-1)
+	}
+	if page.Page < page.Pages {
+		page.Next = page.link(page.Sort, page.Order, page.Page+1)
+	}
+
+	var resp *protocol.Response
+	if strings.Contains(req.Headers["Accept"], "application/json") {
+		body, err := json.MarshalIndent(page, "", "  ")
+		if err != nil {
+			return fs.sendError(conn, req, 500, "Internal Server Error", keepAlive, remainingRequests)
+		}
+		resp = protocol.NewResponse(200, "OK", req.Version, string(body))
+		resp.Headers["Content-Type"] = "application/json"
+	} else {
+		var buf bytes.Buffer
+		if err := listingTemplate.Execute(&buf, page); err != nil {
+			return fs.sendError(conn, req, 500, "Internal Server Error", keepAlive, remainingRequests)
+		}
+		resp = protocol.NewResponse(200, "OK", req.Version, buf.String())
+		resp.Headers["Content-Type"] = "text/html; charset=utf-8"
+	}
+	resp.Headers["Cache-Control"] = "no-cache"
+	resp.Headers["Vary"] = "Accept"
+
+	CompressResponse(resp, req)
+	if resp.Headers["Content-Encoding"] != "" {
+		resp.Headers["Vary"] = "Accept, Accept-Encoding"
+	}
+
+	// Set Connection headers for keep-alive
+	if keepAlive {
+		resp.Headers["Connection"] = "keep-alive"
+		resp.Headers["Keep-Alive"] = fmt.Sprintf("timeout=30, max=%d", remainingRequests)
+	} else {
+		resp.Headers["Connection"] = "close"
+	}
+
+	return protocol.WriteResponse(conn, resp)
+}
+
+// sortListing orders entries by the requested column (directories first)
+// Unknown columns fall back to name; the order defaults to ascending.
+func sortListing(entries []listingEntry, page *listingPage) {
+	if page.Sort != "size" && page.Sort != "mtime" {
+		page.Sort = "name"
+	}
+	if page.Order != "desc" {
+		page.Order = "asc"
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if page.Order == "desc" {
+			a, b = b, a
+		}
+		switch page.Sort {
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "mtime":
+			if !a.ModTime.Equal(b.ModTime) {
+				return a.ModTime.Before(b.ModTime)
+			}
+		}
+		return a.Name < b.Name
+	})
+}
+
+// link builds the query string for a sort column/order and page
+func (p listingPage) link(sortBy, order string, page int) string {
+	q := url.Values{}
+	q.Set("sort", sortBy)
+	q.Set("order", order)
+	if page > 1 {
+		q.Set("page", strconv.Itoa(page))
+	}
+	return "?" + q.Encode()
+}
+
+// SortLink returns the header link for a column: toggles order on the active column
+func (p listingPage) SortLink(column string) string {
+	order := "asc"
+	if p.Sort == column && p.Order == "asc" {
+		order = "desc"
+	}
+	return p.link(column, order, 1)
+}
+
+// formatSize renders a byte count for humans ("1.5 MB")
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+var listingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"size": formatSize,
+	"time": func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Index of {{.Path}}</title>
+    <style>
+        body { font-family: 'Segoe UI', Tahoma, Geneva, Verdana, sans-serif; margin: 40px; color: #333; }
+        h1 { color: #667eea; font-size: 24px; word-break: break-all; }
+        table { border-collapse: collapse; width: 100%; max-width: 960px; }
+        th, td { text-align: left; padding: 6px 12px; border-bottom: 1px solid #eee; }
+        th a, td a { color: #764ba2; text-decoration: none; }
+        td.size, td.mtime { font-family: monospace; white-space: nowrap; }
+        .pages { margin-top: 16px; }
+    </style>
+</head>
+<body>
+    <h1>Index of {{.Path}}</h1>
+    <table>
+        <tr>
+            <th><a href="{{.SortLink "name"}}">Name</a></th>
+            <th><a href="{{.SortLink "size"}}">Size</a></th>
+            <th><a href="{{.SortLink "mtime"}}">Modified</a></th>
+        </tr>
+        {{- if .Parent}}
+        <tr><td><a href="{{.Parent}}">../</a></td><td></td><td></td></tr>
+        {{- end}}
+        {{- range .Entries}}
+        <tr>
+            <td><a href="{{.URL}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td>
+            <td class="size">{{if not .IsDir}}{{size .Size}}{{end}}</td>
+            <td class="mtime">{{time .ModTime}}</td>
+        </tr>
+        {{- end}}
+    </table>
+    {{- if gt .Pages 1}}
+    <p class="pages">
+        {{if .Prev}}<a href="{{.Prev}}">&larr; Previous</a>{{end}}
+        Page {{.Page}} of {{.Pages}} ({{.Total}} entries)
+        {{if .Next}}<a href="{{.Next}}">Next &rarr;</a>{{end}}
+    </p>
+    {{- end}}
+</body>
+</html>
+`))
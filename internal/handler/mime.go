@@ -0,0 +1,315 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// sniffLen is how much of a file is inspected when its extension is unknown
+const sniffLen = 512
+
+// builtinMIMETypes is the extension table shipped with the server
+// It replaces mime.TypeByExtension, whose answers depend on the host's
+// /etc/mime.types, so every machine serves the same types.
+var builtinMIMETypes = map[string]string{
+	// Text and markup
+	".html":     "text/html",
+	".htm":      "text/html",
+	".xhtml":    "application/xhtml+xml",
+	".css":      "text/css",
+	".csv":      "text/csv",
+	".txt":      "text/plain",
+	".text":     "text/plain",
+	".log":      "text/plain",
+	".md":       "text/markdown",
+	".markdown": "text/markdown",
+	".xml":      "text/xml",
+	".xsl":      "text/xml",
+	".rss":      "application/rss+xml",
+	".atom":     "application/atom+xml",
+	".ics":      "text/calendar",
+	".vtt":      "text/vtt",
+	".srt":      "text/plain",
+	".yaml":     "text/yaml",
+	".yml":      "text/yaml",
+	".toml":     "text/plain",
+	".ini":      "text/plain",
+	".conf":     "text/plain",
+	".sh":       "text/x-shellscript",
+	".go":       "text/plain",
+	".py":       "text/plain",
+	".c":        "text/plain",
+	".h":        "text/plain",
+	".rs":       "text/plain",
+	".java":     "text/plain",
+	".tsx":      "text/plain",
+	".jsx":      "text/plain",
+
+	// Scripts and data
+	".js":          "text/javascript",
+	".mjs":         "text/javascript",
+	".cjs":         "text/javascript",
+	".json":        "application/json",
+	".map":         "application/json",
+	".jsonld":      "application/ld+json",
+	".webmanifest": "application/manifest+json",
+	".wasm":        "application/wasm",
+
+	// Images
+	".png":  "image/png",
+	".apng": "image/apng",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".jfif": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+	".avif": "image/avif",
+	".svg":  "image/svg+xml",
+	".svgz": "image/svg+xml",
+	".ico":  "image/x-icon",
+	".bmp":  "image/bmp",
+	".tif":  "image/tiff",
+	".tiff": "image/tiff",
+	".heic": "image/heic",
+
+	// Fonts
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".eot":   "application/vnd.ms-fontobject",
+
+	// Audio and video
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".aac":  "audio/aac",
+	".oga":  "audio/ogg",
+	".ogg":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".ogv":  "video/ogg",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t", // HLS segments, as nginx and Apache serve them
+
+	// Documents and archives
+	".pdf":  "application/pdf",
+	".epub": "application/epub+zip",
+	".zip":  "application/zip",
+	".gz":   "application/gzip",
+	".tgz":  "application/gzip",
+	".zst":  "application/zstd",
+	".tar":  "application/x-tar",
+	".7z":   "application/x-7z-compressed",
+	".rar":  "application/vnd.rar",
+	".doc":  "application/msword",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xls":  "application/vnd.ms-excel",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".ppt":  "application/vnd.ms-powerpoint",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".odt":  "application/vnd.oasis.opendocument.text",
+	".rtf":  "application/rtf",
+	".bin":  "application/octet-stream",
+	".exe":  "application/octet-stream",
+	".dmg":  "application/octet-stream",
+	".iso":  "application/octet-stream",
+}
+
+var (
+	mimeOverridesMu sync.RWMutex
+	mimeOverrides   = make(map[string]string)
+)
+
+// RegisterMIMEType sets the Content-Type served for an extension (".ext"),
+// overriding the built-in table. Text types get "charset=utf-8" unless the
+// type already names a charset.
+func RegisterMIMEType(ext, contentType string) {
+	ext = strings.ToLower(ext)
+	if !strings.HasPrefix(ext, ".") {
+		ext = "." + ext
+	}
+
+	mimeOverridesMu.Lock()
+	defer mimeOverridesMu.Unlock()
+	mimeOverrides[ext] = contentType
+}
+
+// typeByExtension looks name's extension up in the overrides, then the built-in table
+func typeByExtension(name string) (string, bool) {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return "", false
+	}
+
+	mimeOverridesMu.RLock()
+	contentType, ok := mimeOverrides[ext]
+	mimeOverridesMu.RUnlock()
+	if !ok {
+		contentType, ok = builtinMIMETypes[ext]
+	}
+	if !ok {
+		return "", false
+	}
+	return withCharset(contentType), true
+}
+
+// getContentType returns the MIME type for a file name from its extension alone
+// Unknown extensions are application/octet-stream; use contentTypeOf when the
+// contents are at hand.
+func getContentType(filePath string) string {
+	if contentType, ok := typeByExtension(filePath); ok {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+// contentTypeOf returns the MIME type for a file, sniffing the first 512
+// bytes when the extension is unknown or missing
+func contentTypeOf(name string, content io.ReaderAt) string {
+	if contentType, ok := typeByExtension(name); ok {
+		return contentType
+	}
+
+	buf := make([]byte, sniffLen)
+	n, err := content.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "application/octet-stream"
+	}
+	return withCharset(sniffContentType(buf[:n]))
+}
+
+// withCharset adds "; charset=utf-8" to text types that don't name a charset
+func withCharset(contentType string) string {
+	if strings.Contains(strings.ToLower(contentType), "charset=") {
+		return contentType
+	}
+
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		mediaType == "application/xhtml+xml",
+		mediaType == "image/svg+xml",
+		mediaType == "application/rss+xml",
+		mediaType == "application/atom+xml":
+		return contentType + "; charset=utf-8"
+	}
+	return contentType
+}
+
+// magicSignature identifies a binary format by a prefix at a fixed offset
+type magicSignature struct {
+	offset      int
+	prefix      string
+	contentType string
+}
+
+// magicSignatures are checked in order; the first match wins
+var magicSignatures = []magicSignature{
+	{0, "%PDF-", "application/pdf"},
+	{0, "\x89PNG\r\n\x1a\n", "image/png"},
+	{0, "\xff\xd8\xff", "image/jpeg"},
+	{0, "GIF87a", "image/gif"},
+	{0, "GIF89a", "image/gif"},
+	{0, "\x00\x00\x01\x00", "image/x-icon"},
+	{8, "WEBP", "image/webp"},
+	{8, "WAVE", "audio/wav"},
+	{8, "AVI ", "video/x-msvideo"},
+	{4, "ftypavif", "image/avif"},
+	{4, "ftypheic", "image/heic"},
+	{4, "ftypqt", "video/quicktime"},
+	{4, "ftypM4A", "audio/mp4"},
+	{4, "ftyp", "video/mp4"},
+	{0, "\x1a\x45\xdf\xa3", "video/webm"},
+	{0, "OggS", "application/ogg"},
+	{0, "ID3", "audio/mpeg"},
+	{0, "fLaC", "audio/flac"},
+	{0, "wOFF", "font/woff"},
+	{0, "wOF2", "font/woff2"},
+	{0, "OTTO", "font/otf"},
+	{0, "\x00\x01\x00\x00", "font/ttf"},
+	{0, "\x00asm", "application/wasm"},
+	{0, "\x1f\x8b\x08", "application/gzip"},
+	{0, "\x28\xb5\x2f\xfd", "application/zstd"},
+	{0, "PK\x03\x04", "application/zip"},
+	{0, "7z\xbc\xaf\x27\x1c", "application/x-7z-compressed"},
+	{0, "Rar!\x1a\x07", "application/vnd.rar"},
+	{257, "ustar", "application/x-tar"},
+	{0, "%!PS", "application/postscript"},
+}
+
+// sniffContentType guesses a MIME type from the start of a file
+// Known binary signatures come first, then markup, then a text check: valid
+// UTF-8 without control characters is text/plain, anything else is binary.
+func sniffContentType(data []byte) string {
+	for _, sig := range magicSignatures {
+		end := sig.offset + len(sig.prefix)
+		if len(data) >= end && string(data[sig.offset:end]) == sig.prefix {
+			if sig.offset == 8 && !bytes.HasPrefix(data, []byte("RIFF")) {
+				continue // WEBP/WAVE/AVI live inside a RIFF container
+			}
+			return sig.contentType
+		}
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("\xfe\xff")):
+		return "text/plain; charset=utf-16be"
+	case bytes.HasPrefix(data, []byte("\xff\xfe")):
+		return "text/plain; charset=utf-16le"
+	}
+
+	text := bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")) // UTF-8 BOM
+	trimmed := bytes.ToLower(bytes.TrimLeft(text, " \t\r\n\f"))
+	for _, prefix := range []string{"<!doctype html", "<html", "<head", "<body", "<script", "<iframe", "<h1", "<div", "<table", "<p>", "<!--"} {
+		if bytes.HasPrefix(trimmed, []byte(prefix)) {
+			return "text/html"
+		}
+	}
+	if bytes.HasPrefix(trimmed, []byte("<svg")) {
+		return "image/svg+xml"
+	}
+	if bytes.HasPrefix(trimmed, []byte("<?xml")) {
+		if bytes.Contains(trimmed, []byte("<svg")) {
+			return "image/svg+xml"
+		}
+		return "text/xml"
+	}
+
+	if isText(text) {
+		return "text/plain"
+	}
+	return "application/octet-stream"
+}
+
+// isText reports whether data looks like UTF-8 text
+// The last rune may be cut off by the 512-byte window.
+func isText(data []byte) bool {
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size <= 1 {
+			if len(data) < utf8.UTFMax && !utf8.FullRune(data) {
+				return true // Truncated final rune
+			}
+			return false
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' && r != '\f' && r != 0x1b {
+			return false
+		}
+		if r == 0x7f {
+			return false
+		}
+		data = data[size:]
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContentTypeOfSniffsExtensionlessFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"NOTICE", "hello plain\n", "text/plain; charset=utf-8"},
+		{"LICENSE", "\xef\xbb\xbfMIT License — Copyright\n", "text/plain; charset=utf-8"},
+		{"page", "  <!DOCTYPE html><html></html>", "text/html; charset=utf-8"},
+		{"feed", "<?xml version=\"1.0\"?><rss/>", "text/xml; charset=utf-8"},
+		{"drawing", "<?xml version=\"1.0\"?>\n<svg xmlns=\"http://www.w3.org/2000/svg\"/>", "image/svg+xml; charset=utf-8"},
+		{"logo", "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR", "image/png"},
+		{"photo", "\xff\xd8\xff\xe0\x00\x10JFIF", "image/jpeg"},
+		{"clip", "RIFF\x00\x00\x00\x00WEBPVP8 ", "image/webp"},
+		{"notwebp", "JUNK\x00\x00\x00\x00WEBPVP8 ", "application/octet-stream"},
+		{"doc", "%PDF-1.7\n", "application/pdf"},
+		{"blob", "\x00\x01\x02\x03binary", "application/octet-stream"},
+		{"utf16", "\xff\xfeh\x00i\x00", "text/plain; charset=utf-16le"},
+		{"cut", "caf\xc3", "text/plain; charset=utf-8"}, // Rune cut off by the sniff window
+		{"empty", "", "text/plain; charset=utf-8"},
+		{"notes.txt", "\x00\x01", "text/plain; charset=utf-8"}, // The extension wins over the content
+	}
+	for _, tt := range tests {
+		if got := contentTypeOf(tt.name, strings.NewReader(tt.content)); got != tt.want {
+			t.Errorf("contentTypeOf(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+)
+
+const (
+	// maxRangeSpecs is the most ranges a request may list; beyond that the
+	// Range header is ignored and the full file sent (RFC 9110 section 14.2
+	// lets servers ignore ranges that look like abuse)
+	maxRangeSpecs = 64
+
+	// rangeCoalesceGap merges ranges separated by fewer bytes than a part header
+	rangeCoalesceGap = 80
+)
+
+// errRangeNotSatisfiable means no requested range overlaps the file (416)
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// byteRange is an inclusive byte range within a file
+type byteRange struct {
+	start, end int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+// requestedRanges returns the ranges to serve for the request's Range header
+// A nil result with a nil error means "send the whole file": no Range header,
+// a syntactically invalid one, an If-Range that no longer matches, or too many
+// ranges. errRangeNotSatisfiable means none of the ranges overlap the file.
+func requestedRanges(req *protocol.Request, etag string, modTime time.Time, size int64) ([]byteRange, error) {
+	header := req.Headers["Range"]
+	if header == "" || !ifRangeMatches(req.Headers["If-Range"], etag, modTime) {
+		return nil, nil
+	}
+	return parseRanges(header, size)
+}
+
+// ifRangeMatches evaluates If-Range (RFC 9110 section 13.1.5)
+// An entity-tag must match strongly; a date must equal Last-Modified exactly.
+func ifRangeMatches(ifRange, etag string, modTime time.Time) bool {
+	ifRange = strings.TrimSpace(ifRange)
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etagMatches(ifRange, etag, true)
+	}
+	t, err := parseHTTPTime(ifRange)
+	return err == nil && t.Equal(modTime.Truncate(time.Second))
+}
+
+// parseRanges parses "bytes=0-99, 200-, -500" against a file of size bytes
+// Ends past EOF are clamped, suffix ranges count from the end, unsatisfiable
+// ranges are dropped and the rest are sorted and coalesced.
+func parseRanges(header string, size int64) ([]byteRange, error) {
+	unit, spec, found := strings.Cut(header, "=")
+	if !found || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return nil, nil // Unknown unit: ignore the header
+	}
+
+	parts := strings.Split(spec, ",")
+	if len(parts) > maxRangeSpecs {
+		return nil, nil
+	}
+
+	var ranges []byteRange
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, found := strings.Cut(part, "-")
+		if !found {
+			return nil, nil
+		}
+
+		var r byteRange
+		if first == "" {
+			// Suffix range: the last n bytes
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = byteRange{start: size - n, end: size - 1}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, nil
+			}
+			end := size - 1
+			if last != "" {
+				if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+					return nil, nil
+				}
+				if end > size-1 {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue // Unsatisfiable, but others may still be served
+			}
+			r = byteRange{start: start, end: end}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errRangeNotSatisfiable
+	}
+	return coalesceRanges(ranges), nil
+}
+
+// coalesceRanges sorts ranges and merges overlapping or nearly adjacent ones
+// This stops clients from requesting the same bytes many times over.
+func coalesceRanges(ranges []byteRange) []byteRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r.start <= last.end+rangeCoalesceGap {
+			if r.end > last.end {
+				last.end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// sendRangeNotSatisfiable sends 416 with the file size in Content-Range
+func (fs *FileServer) sendRangeNotSatisfiable(conn *tcp.TCPConn, fileSize int64, version protocol.HTTPVersion, keepAlive bool, remainingRequests int) error {
+	resp := protocol.NewResponse(416, "Range Not Satisfiable", version, "")
+	resp.Headers["Content-Range"] = fmt.Sprintf("bytes */%d", fileSize)
+
+	// Set Connection headers
+	if keepAlive {
+		resp.Headers["Connection"] = "keep-alive"
+		resp.Headers["Keep-Alive"] = fmt.Sprintf("timeout=30, max=%d", remainingRequests)
+	} else {
+		resp.Headers["Connection"] = "close"
+	}
+
+	// WriteResponse adds Content-Length: 0, so the connection stays usable
+	return protocol.WriteResponse(conn, resp)
+}
+
+// multipartRanges describes a multipart/byteranges body (RFC 9110 section 14.6)
+type multipartRanges struct {
+	boundary string
+	headers  []string // Part headers, one per range
+	closing  string
+}
+
+// newMultipartRanges precomputes the part headers so Content-Length is known
+// before any data is sent
+func newMultipartRanges(ranges []byteRange, contentType string, size int64) *multipartRanges {
+	random := make([]byte, 12)
+	rand.Read(random)
+
+	m := &multipartRanges{boundary: hex.EncodeToString(random)}
+	for _, r := range ranges {
+		m.headers = append(m.headers, fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			m.boundary, contentType, r.contentRange(size)))
+	}
+	m.closing = fmt.Sprintf("\r\n--%s--\r\n", m.boundary)
+	return m
+}
+
+// contentLength returns the exact size of the multipart body
+func (m *multipartRanges) contentLength(ranges []byteRange) int64 {
+	total := int64(len(m.closing))
+	for i, r := range ranges {
+		total += int64(len(m.headers[i])) + r.length()
+	}
+	return total
+}
+
+// write streams each part from src
+func (m *multipartRanges) write(conn *tcp.TCPConn, src io.ReaderAt, ranges []byteRange) error {
+	for i, r := range ranges {
+		if _, err := io.WriteString(conn, m.headers[i]); err != nil {
+			return err
+		}
+		if err := sendFileRange(conn, src, r.start, r.length()); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(conn, m.closing)
+	return err
+}
+
+// sendMultipartRanges sends several ranges as one 206 multipart/byteranges response
+func (fs *FileServer) sendMultipartRanges(file io.ReaderAt, ranges []byteRange, rel, contentType string, fileSize int64, modTime time.Time, etag string, version protocol.HTTPVersion, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+	parts := newMultipartRanges(ranges, contentType, fileSize)
+
+	resp := protocol.NewResponse(206, "Partial Content", version, "")
+
+	// Set headers
+	resp.Headers["Content-Type"] = "multipart/byteranges; boundary=" + parts.boundary
+	resp.Headers["X-Content-Type-Options"] = "nosniff"
+	resp.Headers["Content-Length"] = fmt.Sprintf("%d", parts.contentLength(ranges))
+	resp.Headers["Accept-Ranges"] = "bytes"
+	resp.Headers["Last-Modified"] = modTime.UTC().Format(httpTimeFormat) // Enable caching
+	resp.Headers["ETag"] = etag
+	fs.cachePolicy.apply(resp.Headers, rel)
+
+	// Set Connection headers for keep-alive
+	if keepAlive {
+		resp.Headers["Connection"] = "keep-alive"
+		resp.Headers["Keep-Alive"] = fmt.Sprintf("timeout=30, max=%d", remainingRequests)
+	} else {
+		resp.Headers["Connection"] = "close"
+	}
+
+	if err := protocol.WriteHeaders(conn, resp); err != nil {
+		return err
+	}
+	return parts.write(conn, file, ranges)
+}
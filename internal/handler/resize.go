@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// catmullRom is the resampling filter: sharp, with little ringing (support 2)
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}
+	return 0
+}
+
+// contribution lists the source pixels that make up one output pixel
+type contribution struct {
+	first   int       // First source pixel
+	weights []float64 // One per source pixel from first on, summing to 1
+}
+
+// contributions precomputes the filter weights for scaling src pixels to dst
+// When shrinking, the filter is stretched so every source pixel is averaged in.
+func contributions(src, dst int) []contribution {
+	scale := float64(src) / float64(dst)
+	stretch := math.Max(scale, 1)
+	support := 2 * stretch
+
+	out := make([]contribution, dst)
+	for i := range out {
+		center := (float64(i)+0.5)*scale - 0.5
+		first := int(math.Ceil(center - support))
+		last := int(math.Floor(center + support))
+		if first < 0 {
+			first = 0
+		}
+		if last > src-1 {
+			last = src - 1
+		}
+
+		weights := make([]float64, last-first+1)
+		var sum float64
+		for j := range weights {
+			w := catmullRom((float64(first+j) - center) / stretch)
+			weights[j] = w
+			sum += w
+		}
+		if sum != 0 {
+			for j := range weights {
+				weights[j] /= sum
+			}
+		}
+		out[i] = contribution{first: first, weights: weights}
+	}
+	return out
+}
+
+// resample scales the rect part of img to width x height
+// Filtering works on premultiplied alpha, so transparent pixels don't bleed
+// their colour into the edges of opaque ones.
+func resample(img image.Image, rect image.Rectangle, width, height int) *image.RGBA {
+	src := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(src, src.Bounds(), img, rect.Min, draw.Src)
+	if src.Rect.Dx() == width && src.Rect.Dy() == height {
+		return src
+	}
+	srcW, srcH := src.Rect.Dx(), src.Rect.Dy()
+
+	// Horizontal pass: srcW x srcH -> width x srcH
+	tmp := make([]float32, width*srcH*4)
+	columns := contributions(srcW, width)
+	for y := 0; y < srcH; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, c := range columns {
+			var r, g, b, a float64
+			for j, w := range c.weights {
+				p := row[(c.first+j)*4:]
+				r += w * float64(p[0])
+				g += w * float64(p[1])
+				b += w * float64(p[2])
+				a += w * float64(p[3])
+			}
+			t := tmp[(y*width+x)*4:]
+			t[0], t[1], t[2], t[3] = float32(r), float32(g), float32(b), float32(a)
+		}
+	}
+
+	// Vertical pass: width x srcH -> width x height
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	rows := contributions(srcH, height)
+	for y, c := range rows {
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < width; x++ {
+			var r, g, b, a float64
+			for j, w := range c.weights {
+				t := tmp[((c.first+j)*width+x)*4:]
+				r += w * float64(t[0])
+				g += w * float64(t[1])
+				b += w * float64(t[2])
+				a += w * float64(t[3])
+			}
+			// The filter overshoots near edges; keep colours within alpha
+			alpha := clampByte(a)
+			p := out[x*4:]
+			p[0] = min(clampByte(r), alpha)
+			p[1] = min(clampByte(g), alpha)
+			p[2] = min(clampByte(b), alpha)
+			p[3] = alpha
+		}
+	}
+	return dst
+}
+
+// clampByte rounds v to the nearest value in 0-255
+func clampByte(v float64) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
+
+// flatten draws img over a solid background, for formats without alpha
+func flatten(img *image.RGBA, background color.Color) *image.RGBA {
+	if img.Opaque() {
+		return img
+	}
+	out := image.NewRGBA(img.Rect)
+	draw.Draw(out, out.Rect, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(out, out.Rect, img, img.Rect.Min, draw.Over)
+	return out
+}
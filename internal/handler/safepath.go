@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// AccessPolicy controls which files under the root a FileServer may serve
+// Every path is opened relative to the root directory without ever leaving
+// it: ".." segments are removed before lookup, and symlinks are resolved by
+// the kernel (openat2 RESOLVE_BENEATH) or component by component with
+// O_NOFOLLOW on older kernels and other systems, so a link pointing outside the root is never
+// followed.
+type AccessPolicy struct {
+	FollowSymlinks    bool     // Follow symlinks whose target stays inside the root
+	AllowDotfiles     bool     // Serve names starting with "." (denied by default)
+	BlockedExtensions []string // Extensions never served, checked on every path segment
+}
+
+// DefaultAccessPolicy follows in-root symlinks, hides dotfiles and blocks
+// files that commonly hold secrets or repository data
+func DefaultAccessPolicy() AccessPolicy {
+	return AccessPolicy{
+		FollowSymlinks:    true,
+		BlockedExtensions: []string{".env", ".git", ".htaccess", ".htpasswd"},
+	}
+}
+
+var (
+	// errForbiddenPath means the path is denied by policy or would escape the root
+	// FileServer answers it with 404 so it doesn't reveal what exists.
+	errForbiddenPath = errors.New("path not allowed")
+
+	// errBadPath means the request path could not be decoded
+	errBadPath = errors.New("malformed request path")
+)
+
+// maxSymlinkHops bounds symlink resolution (same limit as the kernel's ELOOP)
+const maxSymlinkHops = 40
+
+// cleanRequestPath decodes a URL path and returns it relative to the root
+// ("/static/a b.txt?x=1" -> "static/a b.txt"). ".." segments are resolved
+// lexically, so names that merely contain dots ("a..b.txt") are fine.
+func cleanRequestPath(rawPath string) (string, error) {
+	p, _, _ := strings.Cut(rawPath, "?")
+	decoded, err := url.PathUnescape(p)
+	if err != nil || strings.ContainsRune(decoded, 0) {
+		return "", errBadPath
+	}
+	return strings.TrimPrefix(path.Clean("/"+decoded), "/"), nil
+}
+
+// allowed applies the dotfile and extension rules to every segment of rel
+func (p AccessPolicy) allowed(rel string) bool {
+	if rel == "" {
+		return true
+	}
+	for _, segment := range strings.Split(rel, "/") {
+		if !p.AllowDotfiles && strings.HasPrefix(segment, ".") {
+			return false
+		}
+		ext := strings.ToLower(filepath.Ext(segment))
+		for _, blocked := range p.BlockedExtensions {
+			if ext == strings.ToLower(blocked) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// open opens rel (slash-separated, relative to the root) under the access policy
+// The returned file may be a directory; the caller must close it.
+func (fs *FileServer) open(rel string) (staticFile, os.FileInfo, error) {
+	if !fs.access.allowed(rel) {
+		return nil, nil, errForbiddenPath
+	}
+	if fs.fsys != nil {
+		return fs.openFS(rel)
+	}
+
+	file, err := openBeneath(fs.root, rel, fs.access.FollowSymlinks)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// openBeneath opens rel inside root without resolving anything outside root
+func openBeneath(root, rel string, followSymlinks bool) (*os.File, error) {
+	rootDir, err := os.Open(root)
+	if err != nil {
+		return nil, err
+	}
+	if rel == "" {
+		return rootDir, nil
+	}
+	defer rootDir.Close()
+
+	file, err := openat2Beneath(rootDir, rel, followSymlinks)
+	if err == syscall.ENOSYS || err == syscall.EPERM {
+		// openat2 unavailable (kernel < 5.6, blocked by seccomp, or not Linux)
+		file, err = walkBeneath(rootDir, rel, followSymlinks)
+	}
+	switch err {
+	case nil:
+		return file, nil
+	case syscall.EXDEV, syscall.ELOOP:
+		return nil, errForbiddenPath // Escapes the root, or a symlink when they are disabled
+	default:
+		return nil, &os.PathError{Op: "open", Path: filepath.Join(root, filepath.FromSlash(rel)), Err: err}
+	}
+}
+
+// openFlags are used for every component: read-only, and never block on FIFOs
+const openFlags = syscall.O_RDONLY | syscall.O_CLOEXEC | syscall.O_NOCTTY | syscall.O_NONBLOCK
+
+// newBlockingFile wraps fd from an openFlags open
+// O_NONBLOCK only guarded the open against FIFOs; reads should block.
+func newBlockingFile(fd int, name string) *os.File {
+	syscall.SetNonblock(fd, false)
+	return os.NewFile(uintptr(fd), name)
+}
+
+// walkBeneath resolves rel one component at a time with O_NOFOLLOW
+// Symlinks are expanded by hand: absolute targets and ".." past the root are
+// rejected with EXDEV, mirroring RESOLVE_BENEATH.
+func walkBeneath(root *os.File, rel string, followSymlinks bool) (*os.File, error) {
+	dir, err := openAt(root, ".", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := strings.Split(rel, "/")
+	var resolved []string // Components opened so far, relative to the root
+	hops := 0
+
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			if len(resolved) == 0 {
+				dir.Close()
+				return nil, syscall.EXDEV
+			}
+			// Restart from the root: re-walking keeps every step O_NOFOLLOW
+			pending = append(append([]string{}, resolved[:len(resolved)-1]...), pending...)
+			resolved = nil
+			dir.Close()
+			if dir, err = openAt(root, ".", 0); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		file, err := openAt(dir, name, syscall.O_NOFOLLOW)
+		if err == syscall.ELOOP {
+			// name is a symlink
+			if !followSymlinks || hops >= maxSymlinkHops {
+				dir.Close()
+				return nil, syscall.ELOOP
+			}
+			hops++
+
+			target, err := readlinkat(dir, name)
+			if err != nil {
+				dir.Close()
+				return nil, err
+			}
+			if strings.HasPrefix(target, "/") {
+				dir.Close()
+				return nil, syscall.EXDEV
+			}
+			pending = append(strings.Split(target, "/"), pending...)
+			continue
+		}
+		dir.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		dir = file
+		resolved = append(resolved, name)
+	}
+
+	return dir, nil
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// Linux openat2(2) constants (not exported by package syscall)
+const (
+	sysOpenat2          = 437
+	resolveNoMagiclinks = 0x02
+	resolveNoSymlinks   = 0x04
+	resolveBeneath      = 0x08
+)
+
+// openHow mirrors struct open_how for openat2
+type openHow struct {
+	flags   uint64
+	mode    uint64
+	resolve uint64
+}
+
+// openat2Beneath lets the kernel confine resolution to the root directory
+func openat2Beneath(root *os.File, rel string, followSymlinks bool) (*os.File, error) {
+	namePtr, err := syscall.BytePtrFromString(rel)
+	if err != nil {
+		return nil, err
+	}
+
+	how := openHow{flags: openFlags, resolve: resolveBeneath | resolveNoMagiclinks}
+	if !followSymlinks {
+		how.resolve |= resolveNoSymlinks
+	}
+
+	fd, _, errno := syscall.Syscall6(sysOpenat2, root.Fd(), uintptr(unsafe.Pointer(namePtr)),
+		uintptr(unsafe.Pointer(&how)), unsafe.Sizeof(how), 0, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	return newBlockingFile(int(fd), filepath.Join(root.Name(), filepath.FromSlash(rel))), nil
+}
+
+// openAt opens name in dir read-only with openFlags plus flags
+func openAt(dir *os.File, name string, flags int) (*os.File, error) {
+	fd, err := syscall.Openat(int(dir.Fd()), name, openFlags|flags, 0)
+	if err != nil {
+		return nil, err
+	}
+	return newBlockingFile(fd, filepath.Join(dir.Name(), name)), nil
+}
+
+// createAt creates the regular file name in dir; it must not exist yet
+func createAt(dir *os.File, name string) (*os.File, error) {
+	fd, err := syscall.Openat(int(dir.Fd()), name, syscall.O_WRONLY|syscall.O_CREAT|syscall.O_EXCL|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(dir.Name(), name)), nil
+}
+
+// mkdirAt creates the directory name in dir
+func mkdirAt(dir *os.File, name string) error {
+	return syscall.Mkdirat(int(dir.Fd()), name, 0755)
+}
+
+// renameAt renames srcName in srcDir to dstName in dstDir
+func renameAt(srcDir *os.File, srcName string, dstDir *os.File, dstName string) error {
+	return syscall.Renameat(int(srcDir.Fd()), srcName, int(dstDir.Fd()), dstName)
+}
+
+// atRemoveDir makes unlinkat remove a directory (AT_REMOVEDIR)
+const atRemoveDir = 0x200
+
+// unlinkat removes name in dir (flags = atRemoveDir for directories)
+func unlinkat(dir *os.File, name string, flags int) error {
+	namePtr, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_UNLINKAT, dir.Fd(), uintptr(unsafe.Pointer(namePtr)), uintptr(flags))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// readlinkat reads the target of the symlink name in dir
+func readlinkat(dir *os.File, name string) (string, error) {
+	namePtr, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, syscall.PathMax)
+	n, _, errno := syscall.Syscall6(syscall.SYS_READLINKAT, dir.Fd(), uintptr(unsafe.Pointer(namePtr)),
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0, 0)
+	if errno != 0 {
+		return "", errno
+	}
+	return string(buf[:n]), nil
+}
@@ -0,0 +1,71 @@
+//go:build !linux
+
+package handler
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Without openat2 or the *at syscalls, names are resolved against the path
+// of the directory they are opened in. walkBeneath still opens every
+// component with O_NOFOLLOW, but a directory swapped for a symlink between
+// two steps is not detected as it is on Linux.
+
+// openat2Beneath is Linux-only; ENOSYS makes openBeneath walk the path instead
+func openat2Beneath(root *os.File, rel string, followSymlinks bool) (*os.File, error) {
+	return nil, syscall.ENOSYS
+}
+
+// openAt opens name in dir read-only with openFlags plus flags
+func openAt(dir *os.File, name string, flags int) (*os.File, error) {
+	p := filepath.Join(dir.Name(), name)
+	fd, err := syscall.Open(p, openFlags|flags, 0)
+	if err != nil {
+		return nil, err
+	}
+	return newBlockingFile(fd, p), nil
+}
+
+// createAt creates the regular file name in dir; it must not exist yet
+func createAt(dir *os.File, name string) (*os.File, error) {
+	p := filepath.Join(dir.Name(), name)
+	fd, err := syscall.Open(p, syscall.O_WRONLY|syscall.O_CREAT|syscall.O_EXCL|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), p), nil
+}
+
+// mkdirAt creates the directory name in dir
+func mkdirAt(dir *os.File, name string) error {
+	return syscall.Mkdir(filepath.Join(dir.Name(), name), 0755)
+}
+
+// renameAt renames srcName in srcDir to dstName in dstDir
+func renameAt(srcDir *os.File, srcName string, dstDir *os.File, dstName string) error {
+	return syscall.Rename(filepath.Join(srcDir.Name(), srcName), filepath.Join(dstDir.Name(), dstName))
+}
+
+// atRemoveDir makes unlinkat remove a directory
+const atRemoveDir = 0x1
+
+// unlinkat removes name in dir (flags = atRemoveDir for directories)
+func unlinkat(dir *os.File, name string, flags int) error {
+	p := filepath.Join(dir.Name(), name)
+	if flags&atRemoveDir != 0 {
+		return syscall.Rmdir(p)
+	}
+	return syscall.Unlink(p)
+}
+
+// readlinkat reads the target of the symlink name in dir
+func readlinkat(dir *os.File, name string) (string, error) {
+	buf := make([]byte, 4096)
+	n, err := syscall.Readlink(filepath.Join(dir.Name(), name), buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
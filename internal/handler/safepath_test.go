@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestOpenBeneath(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	for name, body := range map[string]string{
+		"root/a.txt":     "A",
+		"root/sub/b.txt": "B",
+		"secret.txt":     "S",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		os.WriteFile(p, []byte(body), 0644)
+	}
+	for link, target := range map[string]string{
+		"root/link.txt":   "a.txt",
+		"root/sublink":    "sub",
+		"root/uplink.txt": "sub/../a.txt",
+		"root/escape.txt": "../secret.txt",
+		"root/absolute":   filepath.Join(root, "a.txt"),
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, filepath.FromSlash(link))); err != nil {
+			t.Skip("symlinks unsupported:", err)
+		}
+	}
+
+	tests := []struct {
+		rel    string
+		follow bool
+		want   string // Body, or "" for errForbiddenPath
+		exists bool   // Expect a not-exist error instead of errForbiddenPath
+	}{
+		{"a.txt", false, "A", false},
+		{"sub/b.txt", false, "B", false},
+		{"sub/../a.txt", false, "A", false},
+		{"link.txt", true, "A", false},
+		{"sublink/b.txt", true, "B", false},
+		{"uplink.txt", true, "A", false},
+		{"link.txt", false, "", false},
+		{"sublink/b.txt", false, "", false},
+		{"escape.txt", true, "", false},
+		{"absolute", true, "", false},
+		{"../secret.txt", true, "", false},
+		{"missing.txt", true, "", true},
+	}
+	walk := func(root string, rel string, follow bool) (*os.File, error) {
+		rootDir, err := os.Open(root)
+		if err != nil {
+			return nil, err
+		}
+		defer rootDir.Close()
+		file, err := walkBeneath(rootDir, rel, follow)
+		if err == syscall.EXDEV || err == syscall.ELOOP {
+			err = errForbiddenPath
+		}
+		return file, err
+	}
+	for _, open := range []struct {
+		name string
+		fn   func(root, rel string, follow bool) (*os.File, error)
+	}{{"openBeneath", openBeneath}, {"walkBeneath", walk}} {
+		for _, tt := range tests {
+			file, err := open.fn(root, tt.rel, tt.follow)
+			switch {
+			case tt.want != "":
+				if err != nil {
+					t.Errorf("%s(%q, follow %v) error = %v", open.name, tt.rel, tt.follow, err)
+					continue
+				}
+				got, _ := io.ReadAll(file)
+				file.Close()
+				if string(got) != tt.want {
+					t.Errorf("%s(%q, follow %v) = %q, want %q", open.name, tt.rel, tt.follow, got, tt.want)
+				}
+			case tt.exists:
+				if !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("%s(%q, follow %v) error = %v, want not exist", open.name, tt.rel, tt.follow, err)
+				}
+			case err != errForbiddenPath:
+				if file != nil {
+					file.Close()
+				}
+				t.Errorf("%s(%q, follow %v) error = %v, want errForbiddenPath", open.name, tt.rel, tt.follow, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package handler
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+)
+
+// sidecarExtensions maps encodings to the suffix of their precompressed files
+// ("style.css" -> "style.css.gz", "style.css.zst")
+var sidecarExtensions = map[string]string{
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+// sidecar is a precompressed copy of a file found next to it on disk, or a
+// variant computed by PrecomputeAssets
+type sidecar struct {
+	file     staticFile
+	encoding Encoder
+	size     int64
+}
+
+// findSidecar returns the best precompressed variant of rel the client accepts
+// Sidecars older than the original are stale and ignored, so an edited file is
+// compressed on the fly until the precompress step is run again. Sidecars are
+// opened under the same access policy as the original. The caller closes the
+// returned file.
+func (fs *FileServer) findSidecar(req *protocol.Request, rel string, original os.FileInfo) *sidecar {
+	accept := req.Headers["Accept-Encoding"]
+	if accept == "" {
+		return nil
+	}
+	if fs.assets != nil {
+		return fs.precomputedVariant(accept, rel, original)
+	}
+
+	var candidates []Encoder
+	found := make(map[string]*sidecar)
+	for _, enc := range encoders {
+		ext, ok := sidecarExtensions[enc.Name()]
+		if !ok {
+			continue
+		}
+		file, info, err := fs.open(rel + ext)
+		if err != nil {
+			continue
+		}
+		if !info.Mode().IsRegular() || info.ModTime().Before(original.ModTime()) {
+			file.Close()
+			continue
+		}
+		candidates = append(candidates, enc)
+		found[enc.Name()] = &sidecar{file: file, encoding: enc, size: info.Size()}
+	}
+
+	var chosen *sidecar
+	if enc := negotiate(accept, candidates); enc != nil {
+		chosen = found[enc.Name()]
+	}
+	for _, sc := range found {
+		if sc != chosen {
+			sc.file.Close()
+		}
+	}
+	return chosen
+}
+
+// sendSidecar streams a precompressed file with the original's Content-Type
+func (fs *FileServer) sendSidecar(sc *sidecar, rel, contentType string, modTime time.Time, etag string, req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+	resp := protocol.NewResponse(200, "OK", req.Version, "")
+
+	// Set headers
+	resp.Headers["Content-Type"] = contentType
+	resp.Headers["X-Content-Type-Options"] = "nosniff"
+	resp.Headers["Content-Encoding"] = sc.encoding.Name()
+	resp.Headers["Content-Length"] = fmt.Sprintf("%d", sc.size)
+	resp.Headers["Vary"] = "Accept-Encoding"
+	resp.Headers["Accept-Ranges"] = "bytes"                              // Range requests get the identity encoding
+	resp.Headers["Last-Modified"] = modTime.UTC().Format(httpTimeFormat) // Enable caching
+	resp.Headers["ETag"] = weakETag(etag)
+	fs.cachePolicy.apply(resp.Headers, rel)
+
+	// Set Connection headers for keep-alive
+	if keepAlive {
+		resp.Headers["Connection"] = "keep-alive"
+		resp.Headers["Keep-Alive"] = fmt.Sprintf("timeout=30, max=%d", remainingRequests)
+	} else {
+		resp.Headers["Connection"] = "close"
+	}
+
+	if err := protocol.WriteHeaders(conn, resp); err != nil {
+		return err
+	}
+
+	return sendFileRange(conn, sc.file, 0, sc.size)
+}
+
+// PrecompressStats summarizes a Precompress run
+type PrecompressStats struct {
+	Files   int   // Source files considered
+	Written int   // Sidecar files written
+	Skipped int   // Sidecars already up to date or not worth keeping
+	Saved   int64 // Bytes saved by the written sidecars
+}
+
+// Precompress writes .gz and .zst sidecars for every compressible file under root
+// Sidecars that would not be smaller than the original are removed, and each one
+// gets its original's modification time so FileServer can detect staleness.
+// Up-to-date sidecars are left alone, which makes the step cheap to re-run.
+func Precompress(root string) (PrecompressStats, error) {
+	var stats PrecompressStats
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || isSidecar(path) || !shouldCompress(getContentType(path)) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() < minSizeForCompression {
+			return nil
+		}
+		stats.Files++
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		for _, enc := range encoders {
+			ext, ok := sidecarExtensions[enc.Name()]
+			if !ok {
+				continue
+			}
+			target := path + ext
+
+			if existing, err := os.Stat(target); err == nil && existing.ModTime().Equal(info.ModTime()) {
+				stats.Skipped++
+				continue
+			}
+
+			compressed, err := compressContent(enc, content)
+			if err != nil {
+				return fmt.Errorf("%s: %w", target, err)
+			}
+			if len(compressed) >= len(content) {
+				os.Remove(target)
+				stats.Skipped++
+				continue
+			}
+
+			if err := writeSidecar(target, compressed, info.ModTime()); err != nil {
+				return err
+			}
+			stats.Written++
+			stats.Saved += int64(len(content) - len(compressed))
+		}
+		return nil
+	})
+
+	return stats, err
+}
+
+// writeSidecar writes data via a temporary file so the server never sees a partial sidecar
+func writeSidecar(target string, data []byte, modTime time.Time) error {
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp, modTime, modTime); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+// isSidecar reports whether path is itself a precompressed variant
+func isSidecar(path string) bool {
+	ext := filepath.Ext(path)
+	for _, sidecarExt := range sidecarExtensions {
+		if ext == sidecarExt {
+			return true
+		}
+	}
+	return false
+}
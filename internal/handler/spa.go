@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"webserver/internal/protocol"
+)
+
+// DefaultSPAAssetExtensions are paths that never get the SPA fallback: a
+// missing script, stylesheet, image or font is a real 404, not a client route
+var DefaultSPAAssetExtensions = []string{
+	".js", ".mjs", ".map", ".css", ".json", ".wasm",
+	".png", ".jpg", ".jpeg", ".gif", ".svg", ".ico", ".webp", ".avif",
+	".woff", ".woff2", ".ttf", ".otf", ".eot",
+	".mp4", ".webm", ".mp3", ".txt", ".xml",
+}
+
+// SPAOptions configures the single-page-application fallback of a FileServer
+type SPAOptions struct {
+	Index           string   // File served for client-side routes, relative to the root ("static/app/index.html")
+	Prefix          string   // Only request paths under this URL prefix fall back ("" = the whole root)
+	AssetExtensions []string // Missing paths with these extensions stay 404 (nil = DefaultSPAAssetExtensions)
+}
+
+// SetSPAFallback serves opts.Index, with 200, for GET/HEAD requests that
+// accept HTML and match no file, so client-side routing works on reload and
+// deep links. Asset-like paths still get a real 404. The fallback is sent
+// with "Cache-Control: no-cache" so a new deploy is picked up immediately.
+func (fs *FileServer) SetSPAFallback(opts SPAOptions) {
+	opts.Index = strings.TrimPrefix(path.Clean("/"+opts.Index), "/")
+	if opts.AssetExtensions == nil {
+		opts.AssetExtensions = DefaultSPAAssetExtensions
+	}
+	fs.spa = &opts
+}
+
+// spaFallback returns the SPA index response for a request that matched no
+// file, or nil if the request doesn't qualify for the fallback
+func (fs *FileServer) spaFallback(req *protocol.Request) *protocol.Response {
+	opts := fs.spa
+	if opts == nil || (req.Method != "GET" && req.Method != "HEAD") || !acceptsHTML(req.Headers["Accept"]) {
+		return nil
+	}
+
+	urlPath, _, _ := strings.Cut(req.Path, "?")
+	if !strings.HasPrefix(urlPath, opts.Prefix) {
+		return nil
+	}
+	ext := strings.ToLower(path.Ext(urlPath))
+	for _, assetExt := range opts.AssetExtensions {
+		if ext == strings.ToLower(assetExt) {
+			return nil
+		}
+	}
+
+	file, info, err := fs.open(opts.Index)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	content, err := readAll(file)
+	if err != nil {
+		return nil
+	}
+
+	resp := protocol.NewResponse(200, "OK", req.Version, string(content))
+	resp.Headers["Content-Type"] = contentTypeOf(opts.Index, file)
+	resp.Headers["X-Content-Type-Options"] = "nosniff"
+	resp.Headers["Last-Modified"] = info.ModTime().UTC().Format(httpTimeFormat)
+	resp.Headers["Cache-Control"] = "no-cache" // Revalidate every time: the index names the current bundles
+	resp.Headers["Vary"] = "Accept"            // Non-HTML clients get a 404 for the same URL
+	if etag, err := fs.etag(file, opts.Index, info); err == nil {
+		resp.Headers["ETag"] = etag
+	}
+
+	ConditionalResponse(resp, req)
+	CompressResponse(resp, req)
+	if resp.Headers["Vary"] == "Accept-Encoding" {
+		resp.Headers["Vary"] = "Accept, Accept-Encoding" // CompressResponse replaced it
+	}
+
+	return resp
+}
+
+// acceptsHTML reports whether an Accept header lists text/html (or XHTML)
+// with a non-zero q-value; "*/*" alone doesn't count, so fetch() and XHR
+// calls to missing API-like paths still get a 404
+func acceptsHTML(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(name) == "q" {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,408 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+)
+
+// DefaultMaxUploadSize is the largest PUT body accepted when WriteOptions.MaxFileSize is 0
+const DefaultMaxUploadSize = 100 * 1024 * 1024 // 100MB
+
+// WriteOptions configures PUT, DELETE and MKCOL on a FileServer root
+type WriteOptions struct {
+	Authorize   func(req *protocol.Request) bool // Required; requests it rejects get 401
+	Realm       string                           // Realm sent in WWW-Authenticate ("" = "uploads")
+	MaxFileSize int64                            // Largest PUT body (0 = DefaultMaxUploadSize)
+	Quota       int64                            // Total bytes of files allowed under the root (0 = unlimited)
+}
+
+// writeState is the write mode of a FileServer
+type writeState struct {
+	opts WriteOptions
+	mu   sync.Mutex // Serializes final precondition checks, renames and quota accounting
+	used int64      // Bytes of regular files under the root
+}
+
+// BasicAuth returns an Authorize func accepting a single user name and password
+// (compared in constant time)
+func BasicAuth(user, password string) func(*protocol.Request) bool {
+	want := []byte(base64.StdEncoding.EncodeToString([]byte(user + ":" + password)))
+
+	return func(req *protocol.Request) bool {
+		scheme, credentials, _ := strings.Cut(req.Headers["Authorization"], " ")
+		if !strings.EqualFold(scheme, "Basic") {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(credentials)), want) == 1
+	}
+}
+
+// EnableWrites lets authorized clients modify the root:
+//
+//   - PUT streams the body to a temporary file and renames it over the target
+//     (201 Created for new files, 204 No Content for replaced ones)
+//   - DELETE removes a file (and its .gz/.zst sidecars) or an empty directory (204)
+//   - MKCOL creates a directory (201)
+//
+// If-Match, If-None-Match ("*" = only create) and If-Unmodified-Since guard
+// against overwriting someone else's change. Writes are confined to the root
+// exactly like reads, and names the access policy hides can't be written.
+// The quota counts the files present at startup plus every write since.
+func (fs *FileServer) EnableWrites(opts WriteOptions) error {
+	if fs.fsys != nil {
+		return errors.New("writes require a FileServer created with NewFileServer")
+	}
+	if opts.Authorize == nil {
+		return errors.New("writes require an Authorize function")
+	}
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = DefaultMaxUploadSize
+	}
+	if opts.Realm == "" {
+		opts.Realm = "uploads"
+	}
+
+	state := &writeState{opts: opts}
+	if opts.Quota > 0 {
+		used, err := diskUsage(fs.root)
+		if err != nil {
+			return fmt.Errorf("measure %s: %w", fs.root, err)
+		}
+		state.used = used
+	}
+	fs.writes = state
+	return nil
+}
+
+// ServeWrite handles PUT, DELETE and MKCOL; register it for each method
+// PUT routes must use StreamBody so the upload is never held in memory.
+func (fs *FileServer) ServeWrite(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+	if fs.writes == nil {
+		return fs.sendError(conn, req, 405, "Method Not Allowed", bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+	if !fs.writes.opts.Authorize(req) {
+		return fs.sendUnauthorized(conn, req, bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+
+	rel, err := cleanRequestPath(req.Path)
+	if err != nil {
+		return fs.sendError(conn, req, 400, "Bad Request", bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+	if rel == "" {
+		return fs.sendError(conn, req, 405, "Method Not Allowed", bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+	if !fs.access.allowed(rel) || (fs.dav != nil && rel == fs.dav.mount) {
+		return fs.sendError(conn, req, 403, "Forbidden", bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+
+	// The parent directory is opened confined to the root; everything below
+	// works relative to it with *at syscalls, so nothing can escape the root
+	parentRel, name := path.Split(rel)
+	parent, err := openBeneath(fs.root, strings.TrimSuffix(parentRel, "/"), fs.access.FollowSymlinks)
+	if err != nil {
+		code, status := openErrorStatus(err)
+		if code == 404 {
+			code, status = 409, "Conflict" // Missing parent: the client must create it first
+		}
+		return fs.sendError(conn, req, code, status, bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+	defer parent.Close()
+	if info, err := parent.Stat(); err != nil || !info.IsDir() {
+		return fs.sendError(conn, req, 409, "Conflict", bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+
+	switch req.Method {
+	case "PUT":
+		return fs.servePut(req, conn, parent, rel, name, keepAlive, remainingRequests)
+	case "DELETE":
+		return fs.serveDelete(req, conn, parent, rel, name, keepAlive, remainingRequests)
+	case "MKCOL":
+		return fs.serveMkcol(req, conn, parent, rel, name, keepAlive, remainingRequests)
+	default:
+		return fs.sendError(conn, req, 405, "Method Not Allowed", bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+}
+
+// servePut stores the request body as rel
+func (fs *FileServer) servePut(req *protocol.Request, conn *tcp.TCPConn, parent *os.File, rel, name string, keepAlive bool, remainingRequests int) error {
+	// Chunked uploads (macOS Finder, davfs2) announce no length: the size
+	// limit is enforced while the body streams in, the quota once it's known
+	chunked := req.Chunked()
+	if _, ok := req.Headers["Content-Length"]; !ok && !chunked {
+		return fs.sendError(conn, req, 411, "Length Required", false, remainingRequests)
+	}
+	length := req.ContentLength()
+	if length > fs.writes.opts.MaxFileSize {
+		return fs.sendError(conn, req, 413, "Content Too Large", bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+
+	// Fail early, before receiving the body; checked again before the rename
+	existingSize, code, status := fs.checkPut(req, rel, length)
+	if code != 0 {
+		return fs.sendError(conn, req, code, status, bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+
+	tmpName, tmp, err := createTempAt(parent)
+	if err != nil {
+		return fs.sendError(conn, req, 500, "Internal Server Error", bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+
+	// Stream the body to disk; a short body means the client went away
+	limit := length
+	if chunked {
+		limit = fs.writes.opts.MaxFileSize + 1
+	}
+	written, err := io.Copy(tmp, io.LimitReader(req.BodyReader(), limit))
+	if chunked && err == nil && written > fs.writes.opts.MaxFileSize {
+		tmp.Close()
+		unlinkat(parent, tmpName, 0)
+		return fs.sendError(conn, req, 413, "Content Too Large", bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+	if chunked {
+		length = written
+	} else if err == nil && written < length {
+		err = io.ErrUnexpectedEOF
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		unlinkat(parent, tmpName, 0)
+		return err
+	}
+
+	// Publish atomically: readers see the old file or the new one, never a mix
+	fs.writes.mu.Lock()
+	existingSize, code, status = fs.checkPut(req, rel, length)
+	if code == 0 {
+		if err = renameAt(parent, tmpName, parent, name); err == nil {
+			fs.writes.used += length - max(existingSize, 0)
+		}
+	}
+	fs.writes.mu.Unlock()
+
+	if code != 0 || err != nil {
+		unlinkat(parent, tmpName, 0)
+		if code == 0 {
+			code, status = 500, "Internal Server Error"
+		}
+		return fs.sendError(conn, req, code, status, bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+
+	resp := protocol.NewResponse(204, "No Content", req.Version, "")
+	if existingSize < 0 {
+		resp = protocol.NewResponse(201, "Created", req.Version, "")
+		resp.Headers["Location"] = strings.Split(req.Path, "?")[0]
+	}
+	if file, info, err := fs.open(rel); err == nil {
+		if etag, err := fs.etag(file, rel, info); err == nil {
+			resp.Headers["ETag"] = etag
+		}
+		file.Close()
+	}
+	return sendWithConnection(conn, resp, bodyKeepAlive(req, keepAlive), remainingRequests)
+}
+
+// checkPut evaluates preconditions, WebDAV locks and the quota for writing
+// length bytes to rel
+// Returns the size of the file being replaced (-1 if it is new), or a status.
+func (fs *FileServer) checkPut(req *protocol.Request, rel string, length int64) (int64, int, string) {
+	existingSize := int64(-1)
+	etag, modTime, info, err := fs.currentVersion(rel)
+	switch {
+	case err == nil && info.IsDir():
+		return 0, 405, "Method Not Allowed" // Can't replace a directory with a file
+	case err == nil:
+		existingSize = info.Size()
+	case !os.IsNotExist(err):
+		code, status := openErrorStatus(err)
+		return 0, code, status
+	}
+
+	if EvaluatePreconditions(req, etag, modTime) != 0 {
+		return 0, 412, "Precondition Failed"
+	}
+	if code, status := fs.checkLocks(req, lockTarget{rel: rel, members: existingSize < 0}); code != 0 {
+		return 0, code, status
+	}
+
+	if quota := fs.writes.opts.Quota; quota > 0 && fs.writes.used+length-max(existingSize, 0) > quota {
+		return 0, 507, "Insufficient Storage"
+	}
+	return existingSize, 0, ""
+}
+
+// serveDelete removes a file, its sidecars, or an empty directory (any
+// directory in WebDAV mode)
+func (fs *FileServer) serveDelete(req *protocol.Request, conn *tcp.TCPConn, parent *os.File, rel, name string, keepAlive bool, remainingRequests int) error {
+	fs.writes.mu.Lock()
+	defer fs.writes.mu.Unlock()
+
+	etag, modTime, info, err := fs.currentVersion(rel)
+	if err != nil {
+		code, status := openErrorStatus(err)
+		return fs.sendError(conn, req, code, status, bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+	if EvaluatePreconditions(req, etag, modTime) != 0 {
+		return fs.sendError(conn, req, 412, "Precondition Failed", bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+	if code, status := fs.checkLocks(req, lockTarget{rel: rel, recursive: true, members: true}); code != 0 {
+		return fs.sendError(conn, req, code, status, bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+
+	// WebDAV deletes collections with everything in them
+	if info.IsDir() && fs.dav != nil {
+		freed, err := removeAllAt(parent, name)
+		fs.writes.used -= freed
+		fs.dav.forget(rel)
+		if err != nil {
+			return fs.sendError(conn, req, 500, "Internal Server Error", bodyKeepAlive(req, keepAlive), remainingRequests)
+		}
+		return sendWithConnection(conn, protocol.NewResponse(204, "No Content", req.Version, ""), bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+
+	if info.IsDir() {
+		if err := unlinkat(parent, name, atRemoveDir); err != nil {
+			if err == syscall.ENOTEMPTY || err == syscall.EEXIST {
+				return fs.sendError(conn, req, 409, "Conflict", bodyKeepAlive(req, keepAlive), remainingRequests)
+			}
+			return fs.sendError(conn, req, 500, "Internal Server Error", bodyKeepAlive(req, keepAlive), remainingRequests)
+		}
+		return sendWithConnection(conn, protocol.NewResponse(204, "No Content", req.Version, ""), bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+
+	if err := unlinkat(parent, name, 0); err != nil {
+		return fs.sendError(conn, req, 500, "Internal Server Error", bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+	fs.writes.used -= info.Size()
+	if fs.dav != nil {
+		fs.dav.forget(rel)
+	}
+
+	// Precompressed copies would otherwise keep serving the deleted file
+	for _, ext := range sidecarExtensions {
+		if sc, scInfo, err := fs.open(rel + ext); err == nil {
+			sc.Close()
+			if scInfo.Mode().IsRegular() && unlinkat(parent, name+ext, 0) == nil {
+				fs.writes.used -= scInfo.Size()
+			}
+		}
+	}
+
+	return sendWithConnection(conn, protocol.NewResponse(204, "No Content", req.Version, ""), bodyKeepAlive(req, keepAlive), remainingRequests)
+}
+
+// serveMkcol creates a directory
+func (fs *FileServer) serveMkcol(req *protocol.Request, conn *tcp.TCPConn, parent *os.File, rel, name string, keepAlive bool, remainingRequests int) error {
+	// MKCOL with a body would describe the collection's contents; unsupported
+	if req.ContentLength() > 0 || req.Chunked() {
+		return fs.sendError(conn, req, 415, "Unsupported Media Type", bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+
+	fs.writes.mu.Lock()
+	defer fs.writes.mu.Unlock()
+	if code, status := fs.checkLocks(req, lockTarget{rel: rel, members: true}); code != 0 {
+		return fs.sendError(conn, req, code, status, bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+
+	switch err := mkdirAt(parent, name); err {
+	case nil:
+		resp := protocol.NewResponse(201, "Created", req.Version, "")
+		resp.Headers["Location"] = strings.TrimSuffix(strings.Split(req.Path, "?")[0], "/") + "/"
+		return sendWithConnection(conn, resp, bodyKeepAlive(req, keepAlive), remainingRequests)
+	case syscall.EEXIST:
+		return fs.sendError(conn, req, 405, "Method Not Allowed", bodyKeepAlive(req, keepAlive), remainingRequests)
+	default:
+		return fs.sendError(conn, req, 500, "Internal Server Error", bodyKeepAlive(req, keepAlive), remainingRequests)
+	}
+}
+
+// sendUnauthorized asks for the credentials WriteOptions.Authorize expects
+func (fs *FileServer) sendUnauthorized(conn *tcp.TCPConn, req *protocol.Request, keepAlive bool, remainingRequests int) error {
+	resp := fs.errorResponse(req, 401, "Unauthorized")
+	resp.Headers["WWW-Authenticate"] = fmt.Sprintf(`Basic realm="%s", charset="UTF-8"`, fs.writes.opts.Realm)
+	return sendWithConnection(conn, resp, keepAlive, remainingRequests)
+}
+
+// bodyKeepAlive reports whether the connection can be reused after answering
+// req: a large body left unread, e.g. by a rejected upload, closes it
+func bodyKeepAlive(req *protocol.Request, keepAlive bool) bool {
+	return keepAlive && req.CanDiscardBody(protocol.MaxRequestSize)
+}
+
+// currentVersion returns the validators of rel as it is now on disk
+func (fs *FileServer) currentVersion(rel string) (string, time.Time, os.FileInfo, error) {
+	file, info, err := fs.open(rel)
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+	defer file.Close()
+
+	if info.IsDir() {
+		return "", info.ModTime(), info, nil
+	}
+	etag, err := fs.etag(file, rel, info)
+	if err != nil {
+		return "", time.Time{}, nil, err
+	}
+	return etag, info.ModTime(), info, nil
+}
+
+// createTempAt creates a hidden temporary file in dir
+// The leading dot keeps it out of listings and unservable while it is written.
+func createTempAt(dir *os.File) (string, *os.File, error) {
+	for attempt := 0; attempt < 10; attempt++ {
+		suffix := make([]byte, 8)
+		if _, err := rand.Read(suffix); err != nil {
+			return "", nil, err
+		}
+		name := ".upload-" + hex.EncodeToString(suffix)
+
+		file, err := createAt(dir, name)
+		if err == syscall.EEXIST {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		return name, file, nil
+	}
+	return "", nil, syscall.EEXIST
+}
+
+// diskUsage sums the sizes of the regular files under root
+func diskUsage(root string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(root, func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
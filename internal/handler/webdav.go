@@ -0,0 +1,399 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+)
+
+// DefaultLockTimeout is the longest WebDAV lock granted when
+// WebDAVOptions.LockTimeout is 0; "Timeout: Infinite" gets this much too
+const DefaultLockTimeout = time.Hour
+
+// webDAVMethods are the methods ServeWebDAV handles, advertised by OPTIONS
+var webDAVMethods = []string{"OPTIONS", "PROPFIND", "PROPPATCH", "COPY", "MOVE", "LOCK", "UNLOCK"}
+
+// WebDAVOptions configures the WebDAV methods of a FileServer
+type WebDAVOptions struct {
+	Prefix      string        // URL prefix the root is mounted at ("/static/"); COPY and MOVE can't leave it
+	LockTimeout time.Duration // Longest lock a client may take (0 = DefaultLockTimeout)
+}
+
+// davState is the WebDAV mode of a FileServer
+type davState struct {
+	opts  WebDAVOptions
+	mount string // Prefix relative to the root ("static")
+	locks *lockStore
+	props *propStore
+}
+
+// EnableWebDAV turns the writable root into a WebDAV class 1 and 2 server, so
+// it can be mounted as a network drive (Finder, Explorer, davfs2, rclone):
+//
+//   - PROPFIND (Depth 0 or 1) and PROPPATCH, with dead properties kept in memory
+//   - COPY and MOVE within the mount prefix, honouring Overwrite and Depth
+//   - LOCK and UNLOCK (exclusive and shared write locks, in memory), enforced
+//     on every write together with the If header
+//   - DELETE of collections removes them recursively
+//
+// EnableWrites must be called first; its Authorize func guards every method
+// except OPTIONS. Locks and dead properties don't survive a restart.
+func (fs *FileServer) EnableWebDAV(opts WebDAVOptions) error {
+	if fs.writes == nil {
+		return errors.New("WebDAV requires EnableWrites")
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "/"
+	}
+	if opts.LockTimeout <= 0 {
+		opts.LockTimeout = DefaultLockTimeout
+	}
+
+	fs.dav = &davState{
+		opts:  opts,
+		mount: strings.Trim(opts.Prefix, "/"),
+		locks: newLockStore(),
+		props: newPropStore(),
+	}
+	return nil
+}
+
+// ServeWebDAV handles the WebDAV methods; register it for each of
+// OPTIONS, PROPFIND, PROPPATCH, COPY, MOVE, LOCK and UNLOCK
+// GET, HEAD, PUT, DELETE and MKCOL stay with ServeFileStream and ServeWrite.
+func (fs *FileServer) ServeWebDAV(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+	if fs.dav == nil {
+		return fs.sendError(conn, req, 405, "Method Not Allowed", keepAlive, remainingRequests)
+	}
+	if req.Method == "OPTIONS" {
+		return fs.serveOptions(req, conn, keepAlive, remainingRequests)
+	}
+	if !fs.writes.opts.Authorize(req) {
+		return fs.sendUnauthorized(conn, req, keepAlive, remainingRequests)
+	}
+
+	rel, err := cleanRequestPath(req.Path)
+	if err != nil {
+		return fs.sendError(conn, req, 400, "Bad Request", keepAlive, remainingRequests)
+	}
+	if !fs.access.allowed(rel) {
+		return fs.sendError(conn, req, 403, "Forbidden", keepAlive, remainingRequests)
+	}
+
+	switch req.Method {
+	case "PROPFIND":
+		return fs.servePropfind(req, conn, rel, keepAlive, remainingRequests)
+	case "PROPPATCH":
+		return fs.serveProppatch(req, conn, rel, keepAlive, remainingRequests)
+	case "COPY", "MOVE":
+		return fs.serveCopyMove(req, conn, rel, keepAlive, remainingRequests)
+	case "LOCK":
+		return fs.serveLock(req, conn, rel, keepAlive, remainingRequests)
+	case "UNLOCK":
+		return fs.serveUnlock(req, conn, rel, keepAlive, remainingRequests)
+	default:
+		return fs.sendError(conn, req, 405, "Method Not Allowed", keepAlive, remainingRequests)
+	}
+}
+
+// serveOptions advertises WebDAV compliance; it needs no credentials, as
+// clients probe with OPTIONS before they authenticate
+func (fs *FileServer) serveOptions(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+	resp := protocol.NewResponse(200, "OK", req.Version, "")
+	resp.Headers["DAV"] = "1, 2"
+	resp.Headers["MS-Author-Via"] = "DAV" // Windows only offers write access with this
+	resp.Headers["Allow"] = "GET, HEAD, PUT, DELETE, MKCOL, " + strings.Join(webDAVMethods, ", ")
+	return sendWithConnection(conn, resp, keepAlive, remainingRequests)
+}
+
+// serveCopyMove copies or moves rel to the Destination header
+func (fs *FileServer) serveCopyMove(req *protocol.Request, conn *tcp.TCPConn, rel string, keepAlive bool, remainingRequests int) error {
+	move := req.Method == "MOVE"
+
+	dst, code, status := fs.destination(req)
+	if code != 0 {
+		return fs.sendError(conn, req, code, status, keepAlive, remainingRequests)
+	}
+	// Neither may contain the other: overwriting an ancestor would delete the source
+	if dst == rel || strings.HasPrefix(dst, rel+"/") || strings.HasPrefix(rel, dst+"/") || rel == fs.dav.mount || dst == fs.dav.mount {
+		return fs.sendError(conn, req, 403, "Forbidden", keepAlive, remainingRequests)
+	}
+
+	// COPY takes Depth 0 (the collection alone) or infinity; MOVE only infinity
+	depth := req.Headers["Depth"]
+	shallow := depth == "0"
+	if (depth != "" && !strings.EqualFold(depth, "infinity") && !shallow) || (move && shallow) {
+		return fs.sendError(conn, req, 400, "Bad Request", keepAlive, remainingRequests)
+	}
+
+	overwrite := true
+	switch strings.ToUpper(req.Headers["Overwrite"]) {
+	case "", "T":
+	case "F":
+		overwrite = false
+	default:
+		return fs.sendError(conn, req, 400, "Bad Request", keepAlive, remainingRequests)
+	}
+
+	src, srcInfo, err := fs.open(rel)
+	if err != nil {
+		code, status := openErrorStatus(err)
+		return fs.sendError(conn, req, code, status, keepAlive, remainingRequests)
+	}
+	defer src.Close()
+
+	srcParent, err := openBeneath(fs.root, path.Dir(rel), fs.access.FollowSymlinks)
+	if err != nil {
+		return fs.sendError(conn, req, 500, "Internal Server Error", keepAlive, remainingRequests)
+	}
+	defer srcParent.Close()
+
+	dstParent, err := openBeneath(fs.root, path.Dir(dst), fs.access.FollowSymlinks)
+	if err != nil {
+		return fs.sendError(conn, req, 409, "Conflict", keepAlive, remainingRequests) // Missing parent
+	}
+	defer dstParent.Close()
+	dstParentInfo, err := dstParent.Stat()
+	if err != nil || !dstParentInfo.IsDir() {
+		return fs.sendError(conn, req, 409, "Conflict", keepAlive, remainingRequests)
+	}
+
+	fs.writes.mu.Lock()
+	defer fs.writes.mu.Unlock()
+
+	targets := []lockTarget{{rel: dst, recursive: true, members: true}}
+	if move {
+		targets = append(targets, lockTarget{rel: rel, recursive: true, members: true})
+	}
+	if code, status := fs.checkLocks(req, targets...); code != 0 {
+		return fs.sendError(conn, req, code, status, keepAlive, remainingRequests)
+	}
+
+	_, _, _, err = fs.currentVersion(dst)
+	exists := err == nil
+	if exists && !overwrite {
+		return fs.sendError(conn, req, 412, "Precondition Failed", keepAlive, remainingRequests)
+	}
+
+	// Quota is checked before anything is removed or copied. A move is a rename
+	// that needs no space, unless it crosses into another file system.
+	overQuota := func() bool {
+		if fs.writes.opts.Quota <= 0 {
+			return false
+		}
+		size, err := diskUsage(filepath.Join(fs.root, filepath.FromSlash(rel)))
+		return err != nil || fs.writes.used+size > fs.writes.opts.Quota
+	}
+	checked := !move || !sameDevice(srcInfo, dstParentInfo)
+	if checked && overQuota() {
+		return fs.sendError(conn, req, 507, "Insufficient Storage", keepAlive, remainingRequests)
+	}
+
+	dstName := path.Base(dst)
+	if exists {
+		freed, err := removeAllAt(dstParent, dstName)
+		fs.writes.used -= freed
+		fs.dav.forget(dst)
+		if err != nil {
+			return fs.sendError(conn, req, 500, "Internal Server Error", keepAlive, remainingRequests)
+		}
+	}
+
+	if move {
+		err = renameAt(srcParent, path.Base(rel), dstParent, dstName)
+		if err == syscall.EXDEV {
+			// Another file system is mounted inside the root: copy, then remove
+			// (bind mounts of one file system still refuse renames between them)
+			if !checked && overQuota() {
+				return fs.sendError(conn, req, 507, "Insufficient Storage", keepAlive, remainingRequests)
+			}
+			var written, freed int64
+			if written, err = fs.copyTree(src.(*os.File), srcInfo, dstParent, dstName, dst, false); err == nil {
+				freed, err = removeAllAt(srcParent, path.Base(rel))
+			}
+			fs.writes.used += written - freed
+		}
+		if err == nil {
+			fs.dav.props.move(rel, dst)
+			fs.dav.locks.removeUnder(rel) // Locks stay with the URL, not the file
+		}
+	} else {
+		var written int64
+		written, err = fs.copyTree(src.(*os.File), srcInfo, dstParent, dstName, dst, shallow)
+		fs.writes.used += written
+		if err == nil {
+			fs.dav.props.copy(rel, dst, !shallow)
+		}
+	}
+	if err != nil {
+		return fs.sendError(conn, req, 500, "Internal Server Error", keepAlive, remainingRequests)
+	}
+
+	if exists {
+		return sendWithConnection(conn, protocol.NewResponse(204, "No Content", req.Version, ""), keepAlive, remainingRequests)
+	}
+	resp := protocol.NewResponse(201, "Created", req.Version, "")
+	resp.Headers["Location"] = davHref(dst, srcInfo.IsDir())
+	return sendWithConnection(conn, resp, keepAlive, remainingRequests)
+}
+
+// destination resolves the Destination header of COPY and MOVE to a path
+// relative to the root; it must name this server and stay under the prefix
+func (fs *FileServer) destination(req *protocol.Request) (string, int, string) {
+	header := req.Headers["Destination"]
+	if header == "" {
+		return "", 400, "Bad Request"
+	}
+	u, err := url.Parse(header)
+	if err != nil {
+		return "", 400, "Bad Request"
+	}
+	if u.Host != "" && req.Headers["Host"] != "" && !strings.EqualFold(u.Host, req.Headers["Host"]) {
+		return "", 502, "Bad Gateway" // Another server
+	}
+
+	dst, err := cleanRequestPath(u.EscapedPath())
+	if err != nil {
+		return "", 400, "Bad Request"
+	}
+	if fs.dav.mount != "" && dst != fs.dav.mount && !strings.HasPrefix(dst, fs.dav.mount+"/") {
+		return "", 403, "Forbidden"
+	}
+	if !fs.access.allowed(dst) {
+		return "", 403, "Forbidden"
+	}
+	return dst, 0, ""
+}
+
+// forget drops the locks and dead properties of rel and everything below it
+func (d *davState) forget(rel string) {
+	d.locks.removeUnder(rel)
+	d.props.remove(rel)
+}
+
+// davHref is the URL of rel; collections end in "/"
+func davHref(rel string, isDir bool) string {
+	href := (&url.URL{Path: "/" + rel}).EscapedPath()
+	if isDir && !strings.HasSuffix(href, "/") {
+		href += "/"
+	}
+	return href
+}
+
+// removeAllAt removes name in dir and everything below it without following
+// symlinks, returning the bytes of regular files freed
+func removeAllAt(dir *os.File, name string) (int64, error) {
+	file, err := openAt(dir, name, syscall.O_NOFOLLOW)
+	if err == syscall.ELOOP || err == syscall.ENXIO {
+		return 0, unlinkat(dir, name, 0) // A symlink or socket: remove the entry itself
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if !info.IsDir() {
+		var size int64
+		if info.Mode().IsRegular() {
+			size = info.Size()
+		}
+		if err := unlinkat(dir, name, 0); err != nil {
+			return 0, err
+		}
+		return size, nil
+	}
+
+	names, err := file.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+	var freed int64
+	for _, child := range names {
+		n, err := removeAllAt(file, child)
+		freed += n
+		if err != nil {
+			return freed, err
+		}
+	}
+	return freed, unlinkat(dir, name, atRemoveDir)
+}
+
+// sameDevice reports whether two files are on the same file system
+func sameDevice(a, b os.FileInfo) bool {
+	sa, okA := a.Sys().(*syscall.Stat_t)
+	sb, okB := b.Sys().(*syscall.Stat_t)
+	return okA && okB && sa.Dev == sb.Dev
+}
+
+// copyTree copies src to name in dstDir, returning the bytes written
+// Below src nothing is followed: symlinks, devices and names the access
+// policy hides are skipped. shallow copies a collection without its members.
+func (fs *FileServer) copyTree(src *os.File, info os.FileInfo, dstDir *os.File, name, rel string, shallow bool) (int64, error) {
+	if info.Mode().IsRegular() {
+		dst, err := createAt(dstDir, name)
+		if err != nil {
+			return 0, err
+		}
+		written, err := io.Copy(dst, io.NewSectionReader(src, 0, info.Size()))
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		return written, err
+	}
+	if !info.IsDir() {
+		return 0, nil
+	}
+
+	if err := mkdirAt(dstDir, name); err != nil {
+		return 0, err
+	}
+	if shallow {
+		return 0, nil
+	}
+	dst, err := openAt(dstDir, name, syscall.O_DIRECTORY|syscall.O_NOFOLLOW)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	names, err := src.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, child := range names {
+		if !fs.access.allowed(path.Join(rel, child)) {
+			continue
+		}
+		childFile, err := openAt(src, child, syscall.O_NOFOLLOW)
+		if err == syscall.ELOOP || err == syscall.ENXIO {
+			continue
+		}
+		if err != nil {
+			return total, err
+		}
+		childInfo, err := childFile.Stat()
+		if err == nil {
+			var n int64
+			n, err = fs.copyTree(childFile, childInfo, dst, child, path.Join(rel, child), false)
+			total += n
+		}
+		childFile.Close()
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,465 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp/tcptest"
+)
+
+// The tests below replay litmus-style sessions (the WebDAV conformance
+// suite's basic, copymove, props and locks groups) against a site with
+// uploads and WebDAV enabled. Each step is a raw request with the status and
+// body fragments the server must answer with; later steps see the lock
+// tokens earlier ones captured.
+
+// davStep is one recorded request of a WebDAV session
+type davStep struct {
+	method  string
+	path    string
+	headers map[string]string // "{name}" is replaced by a captured lock token
+	body    string
+
+	status   int
+	contains []string // Body fragments the response must contain
+	excludes []string // Body fragments it must not contain
+	hrefs    []string // Exact <href>s of a 207 response, sorted
+	capture  string   // Saves the Lock-Token of the response under this name
+}
+
+// davCredentials is the Authorization header every step sends unless it
+// sets its own
+var davCredentials = "Basic " + base64.StdEncoding.EncodeToString([]byte("litmus:secret"))
+
+// newDAVSite creates a WebDAV site whose static/ directory holds files
+func newDAVSite(t *testing.T, files map[string]string, opts WriteOptions) (*HTTPHandler, string) {
+	t.Helper()
+	opts.Authorize = BasicAuth("litmus", "secret")
+	return newTestSite(t, files, func(site *SiteConfig) {
+		site.Uploads = &opts
+		site.WebDAV = true
+	})
+}
+
+// runDAV replays steps in order against h
+func runDAV(t *testing.T, h *HTTPHandler, steps []davStep) {
+	t.Helper()
+	tokens := map[string]string{}
+	expand := func(s string) string {
+		for name, token := range tokens {
+			s = strings.ReplaceAll(s, "{"+name+"}", token)
+		}
+		return s
+	}
+
+	for i, step := range steps {
+		headers := map[string]string{"Authorization": davCredentials}
+		for name, value := range step.headers {
+			headers[name] = expand(value)
+		}
+		resp, body := davRequest(t, h, step.method, step.path, headers, expand(step.body))
+		where := fmt.Sprintf("step %d: %s %s", i+1, step.method, step.path)
+
+		if resp.StatusCode != step.status {
+			t.Fatalf("%s: status %d, want %d\n%s", where, resp.StatusCode, step.status, body)
+		}
+		for _, want := range step.contains {
+			if !strings.Contains(body, expand(want)) {
+				t.Errorf("%s: body lacks %q\n%s", where, expand(want), body)
+			}
+		}
+		for _, unwanted := range step.excludes {
+			if strings.Contains(body, expand(unwanted)) {
+				t.Errorf("%s: body contains %q\n%s", where, expand(unwanted), body)
+			}
+		}
+		if step.hrefs != nil {
+			if got := multistatusHrefs(t, body); strings.Join(got, " ") != strings.Join(step.hrefs, " ") {
+				t.Errorf("%s: hrefs %q, want %q", where, got, step.hrefs)
+			}
+		}
+		if step.capture != "" {
+			token := strings.Trim(resp.Header.Get("Lock-Token"), "<>")
+			if !strings.HasPrefix(token, "opaquelocktoken:") {
+				t.Fatalf("%s: Lock-Token %q", where, resp.Header.Get("Lock-Token"))
+			}
+			tokens[step.capture] = token
+		}
+	}
+}
+
+// davRequest sends one raw request through the site's router
+// A "Transfer-Encoding: chunked" header sends body in small chunks;
+// otherwise a non-empty body gets a Content-Length.
+func davRequest(t *testing.T, h *HTTPHandler, method, path string, headers map[string]string, body string) (*http.Response, string) {
+	t.Helper()
+	conn, peer := tcptest.DialPipe(t)
+
+	var raw strings.Builder
+	fmt.Fprintf(&raw, "%s %s HTTP/1.1\r\nHost: dav.test\r\n", method, path)
+	for name, value := range headers {
+		fmt.Fprintf(&raw, "%s: %s\r\n", name, value)
+	}
+	chunked := headers["Transfer-Encoding"] == "chunked"
+	if body != "" && !chunked {
+		fmt.Fprintf(&raw, "Content-Length: %d\r\n", len(body))
+	}
+	raw.WriteString("\r\n")
+	if chunked {
+		w := protocol.NewChunkedWriter(&raw)
+		for rest := body; len(rest) > 0; {
+			n := min(100, len(rest))
+			w.Write([]byte(rest[:n]))
+			rest = rest[n:]
+		}
+		w.Close()
+	} else {
+		raw.WriteString(body)
+	}
+	go peer.Write([]byte(raw.String()))
+
+	req, err := protocol.ParseRequestHead(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Serve(req, conn, false, 0); err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(peer), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(data)
+}
+
+// multistatusHrefs returns the hrefs of a 207 body, sorted (members come in
+// directory order)
+func multistatusHrefs(t *testing.T, body string) []string {
+	t.Helper()
+	var ms struct {
+		Responses []struct {
+			Href string `xml:"DAV: href"`
+		} `xml:"DAV: response"`
+	}
+	if err := xml.Unmarshal([]byte(body), &ms); err != nil {
+		t.Fatalf("multistatus: %v\n%s", err, body)
+	}
+	hrefs := []string{}
+	for _, r := range ms.Responses {
+		hrefs = append(hrefs, r.Href)
+	}
+	sort.Strings(hrefs)
+	return hrefs
+}
+
+// readStatic returns the content of static/name under root ("" if missing)
+func readStatic(t *testing.T, root, name string) (string, bool) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, "static", filepath.FromSlash(name)))
+	if os.IsNotExist(err) {
+		return "", false
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), true
+}
+
+const (
+	propContentLength = `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><D:getcontentlength/><D:nonexistent/></D:prop></D:propfind>`
+	propAuthor        = `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:prop><Z:author xmlns:Z="http://example.com/ns"/></D:prop></D:propfind>`
+	propName          = `<?xml version="1.0"?><D:propfind xmlns:D="DAV:"><D:propname/></D:propfind>`
+
+	patchSet = `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="http://example.com/ns">` +
+		`<D:set><D:prop><Z:author>Ann</Z:author><Z:color>blue</Z:color></D:prop></D:set></D:propertyupdate>`
+	patchRemove = `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="http://example.com/ns">` +
+		`<D:remove><D:prop><Z:author/></D:prop></D:remove></D:propertyupdate>`
+	patchLive = `<?xml version="1.0"?><D:propertyupdate xmlns:D="DAV:" xmlns:Z="http://example.com/ns">` +
+		`<D:set><D:prop><D:getetag>"forged"</D:getetag><Z:size>large</Z:size></D:prop></D:set></D:propertyupdate>`
+
+	lockExclusive = `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope>` +
+		`<D:locktype><D:write/></D:locktype><D:owner><D:href>litmus test suite</D:href></D:owner></D:lockinfo>`
+	lockShared = `<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:shared/></D:lockscope>` +
+		`<D:locktype><D:write/></D:locktype></D:lockinfo>`
+)
+
+func TestWebDAVPropfind(t *testing.T) {
+	h, _ := newDAVSite(t, map[string]string{
+		"docs/a.txt":     "hello",
+		"docs/sub/b.txt": "b",
+		".env":           "SECRET=1",
+	}, WriteOptions{})
+
+	runDAV(t, h, []davStep{
+		{method: "OPTIONS", path: "/static/", headers: map[string]string{"Authorization": ""}, status: 200},
+		{method: "PROPFIND", path: "/static/docs/", headers: map[string]string{"Authorization": "", "Depth": "0"}, status: 401},
+
+		// Depth 0: the collection alone, with allprop
+		{method: "PROPFIND", path: "/static/docs/", headers: map[string]string{"Depth": "0"}, status: 207,
+			hrefs:    []string{"/static/docs/"},
+			contains: []string{"<D:resourcetype><D:collection/></D:resourcetype>", "<D:supportedlock>"},
+			excludes: []string{"<D:getcontentlength>"}},
+
+		// Depth 1: the collection and its members; hidden names stay hidden
+		{method: "PROPFIND", path: "/static/docs/", headers: map[string]string{"Depth": "1"}, status: 207,
+			hrefs: []string{"/static/docs/", "/static/docs/a.txt", "/static/docs/sub/"}},
+		{method: "PROPFIND", path: "/static/", headers: map[string]string{"Depth": "1"}, status: 207,
+			hrefs: []string{"/static/", "/static/docs/"}},
+		{method: "PROPFIND", path: "/static/docs/a.txt", headers: map[string]string{"Depth": "1"}, status: 207,
+			hrefs:    []string{"/static/docs/a.txt"},
+			contains: []string{"<D:getcontentlength>5</D:getcontentlength>", "<D:getcontenttype>text/plain; charset=utf-8</D:getcontenttype>", "<D:getetag>"}},
+
+		// Depth infinity, explicit or by default, is refused
+		{method: "PROPFIND", path: "/static/docs/", headers: map[string]string{"Depth": "infinity"}, status: 403,
+			contains: []string{"<D:propfind-finite-depth/>"}},
+		{method: "PROPFIND", path: "/static/docs/", status: 403, contains: []string{"<D:propfind-finite-depth/>"}},
+
+		// Named properties: found ones with 200, unknown ones with 404
+		{method: "PROPFIND", path: "/static/docs/a.txt", headers: map[string]string{"Depth": "0"}, body: propContentLength, status: 207,
+			contains: []string{"<D:getcontentlength>5</D:getcontentlength>", "<D:nonexistent/>", "HTTP/1.1 200 OK", "HTTP/1.1 404 Not Found"}},
+		{method: "PROPFIND", path: "/static/docs/a.txt", headers: map[string]string{"Depth": "0"}, body: propName, status: 207,
+			contains: []string{"<D:getcontentlength/>", "<D:getlastmodified/>"}},
+
+		{method: "PROPFIND", path: "/static/docs/missing.txt", headers: map[string]string{"Depth": "0"}, status: 404},
+		{method: "PROPFIND", path: "/static/.env", headers: map[string]string{"Depth": "0"}, status: 403},
+		{method: "PROPFIND", path: "/static/docs/", headers: map[string]string{"Depth": "0"}, body: "<not-xml", status: 400},
+	})
+}
+
+func TestWebDAVProppatch(t *testing.T) {
+	h, _ := newDAVSite(t, map[string]string{"a.txt": "A"}, WriteOptions{})
+
+	runDAV(t, h, []davStep{
+		// Set two dead properties and read them back
+		{method: "PROPPATCH", path: "/static/a.txt", body: patchSet, status: 207,
+			contains: []string{`<R:author xmlns:R="http://example.com/ns"/>`, "HTTP/1.1 200 OK"}},
+		{method: "PROPFIND", path: "/static/a.txt", headers: map[string]string{"Depth": "0"}, body: propAuthor, status: 207,
+			contains: []string{`<R:author xmlns:R="http://example.com/ns">Ann</R:author>`, "HTTP/1.1 200 OK"},
+			excludes: []string{"404"}},
+		{method: "PROPFIND", path: "/static/a.txt", headers: map[string]string{"Depth": "0"}, status: 207,
+			contains: []string{">Ann</R:author>", ">blue</R:color>"}},
+
+		// Remove one; the other stays
+		{method: "PROPPATCH", path: "/static/a.txt", body: patchRemove, status: 207, contains: []string{"HTTP/1.1 200 OK"}},
+		{method: "PROPFIND", path: "/static/a.txt", headers: map[string]string{"Depth": "0"}, body: propAuthor, status: 207,
+			contains: []string{"HTTP/1.1 404 Not Found"}, excludes: []string{"Ann"}},
+		{method: "PROPFIND", path: "/static/a.txt", headers: map[string]string{"Depth": "0"}, status: 207,
+			contains: []string{">blue</R:color>"}},
+
+		// Live properties are protected, and the update is all or nothing
+		{method: "PROPPATCH", path: "/static/a.txt", body: patchLive, status: 207,
+			contains: []string{"<D:getetag/>", "HTTP/1.1 403 Forbidden", `<R:size xmlns:R="http://example.com/ns"/>`, "HTTP/1.1 424 Failed Dependency"}},
+		{method: "PROPFIND", path: "/static/a.txt", headers: map[string]string{"Depth": "0"}, status: 207,
+			excludes: []string{"large", "forged"}},
+
+		{method: "PROPPATCH", path: "/static/missing.txt", body: patchSet, status: 404},
+		{method: "PROPPATCH", path: "/static/a.txt", body: "<not-xml", status: 400},
+	})
+}
+
+func TestWebDAVCopyMove(t *testing.T) {
+	h, root := newDAVSite(t, map[string]string{
+		"src/a.txt":     "A",
+		"src/sub/b.txt": "B",
+		"other.txt":     "O",
+	}, WriteOptions{})
+
+	runDAV(t, h, []davStep{
+		// COPY a file, then again with and without Overwrite
+		{method: "COPY", path: "/static/src/a.txt", headers: map[string]string{"Destination": "/static/copy.txt"}, status: 201},
+		{method: "COPY", path: "/static/other.txt", headers: map[string]string{"Destination": "/static/copy.txt", "Overwrite": "F"}, status: 412},
+		{method: "COPY", path: "/static/other.txt", headers: map[string]string{"Destination": "http://dav.test/static/copy.txt", "Overwrite": "T"}, status: 204},
+
+		// COPY a collection: Depth 0 copies it empty, infinity with members
+		{method: "COPY", path: "/static/src/", headers: map[string]string{"Destination": "/static/shallow/", "Depth": "0"}, status: 201},
+		{method: "COPY", path: "/static/src/", headers: map[string]string{"Destination": "/static/deep/", "Depth": "infinity"}, status: 201},
+		{method: "COPY", path: "/static/src/", headers: map[string]string{"Destination": "/static/bad/", "Depth": "1"}, status: 400},
+		{method: "PROPFIND", path: "/static/shallow/", headers: map[string]string{"Depth": "1"}, status: 207,
+			hrefs: []string{"/static/shallow/"}},
+		{method: "PROPFIND", path: "/static/deep/", headers: map[string]string{"Depth": "1"}, status: 207,
+			hrefs: []string{"/static/deep/", "/static/deep/a.txt", "/static/deep/sub/"}},
+
+		// MOVE only takes Depth infinity
+		{method: "MOVE", path: "/static/src/", headers: map[string]string{"Destination": "/static/moved/", "Depth": "0"}, status: 400},
+		{method: "MOVE", path: "/static/src/a.txt", headers: map[string]string{"Destination": "/static/moved.txt"}, status: 201},
+		{method: "MOVE", path: "/static/other.txt", headers: map[string]string{"Destination": "/static/moved.txt", "Overwrite": "F"}, status: 412},
+		{method: "MOVE", path: "/static/other.txt", headers: map[string]string{"Destination": "/static/moved.txt", "Overwrite": "T"}, status: 204},
+		{method: "MOVE", path: "/static/src/", headers: map[string]string{"Destination": "/static/tree/", "Depth": "infinity"}, status: 201},
+
+		// Bad destinations
+		{method: "MOVE", path: "/static/moved.txt", headers: map[string]string{"Destination": "/static/nope/x.txt"}, status: 409},
+		{method: "COPY", path: "/static/moved.txt", headers: map[string]string{"Destination": "/elsewhere.txt"}, status: 403},
+		{method: "COPY", path: "/static/moved.txt", headers: map[string]string{"Destination": "http://other.test/static/x.txt"}, status: 502},
+		{method: "COPY", path: "/static/moved.txt", headers: map[string]string{"Destination": "/static/.env"}, status: 403},
+		{method: "COPY", path: "/static/deep/", headers: map[string]string{"Destination": "/static/deep/sub/loop/"}, status: 403},
+		{method: "MOVE", path: "/static/tree/sub/", headers: map[string]string{"Destination": "/static/tree/", "Overwrite": "T"}, status: 403},
+		{method: "COPY", path: "/static/deep/sub/b.txt", headers: map[string]string{"Destination": "/static/deep/", "Overwrite": "T"}, status: 403},
+		{method: "COPY", path: "/static/moved.txt", status: 400},
+		{method: "COPY", path: "/static/missing.txt", headers: map[string]string{"Destination": "/static/x.txt"}, status: 404},
+	})
+
+	for name, want := range map[string]string{
+		"copy.txt":       "O",
+		"deep/a.txt":     "A",
+		"deep/sub/b.txt": "B",
+		"moved.txt":      "O",
+		"tree/sub/b.txt": "B",
+	} {
+		if got, ok := readStatic(t, root, name); !ok || got != want {
+			t.Errorf("%s = %q (exists %v), want %q", name, got, ok, want)
+		}
+	}
+	for _, name := range []string{"src", "other.txt", "shallow/a.txt", "bad"} {
+		if _, err := os.Stat(filepath.Join(root, "static", name)); !os.IsNotExist(err) {
+			t.Errorf("%s exists, want it gone", name)
+		}
+	}
+}
+
+func TestWebDAVCopyMoveQuota(t *testing.T) {
+	h, root := newDAVSite(t, map[string]string{
+		"a.txt":     "0123456789",
+		"dir/b.txt": "0123456789",
+	}, WriteOptions{Quota: 25})
+
+	runDAV(t, h, []davStep{
+		// A copy needs room for another 10 bytes; a rename needs none
+		{method: "COPY", path: "/static/a.txt", headers: map[string]string{"Destination": "/static/c.txt"}, status: 507},
+		{method: "COPY", path: "/static/dir/", headers: map[string]string{"Destination": "/static/dir2/"}, status: 507},
+		{method: "MOVE", path: "/static/a.txt", headers: map[string]string{"Destination": "/static/dir/a.txt"}, status: 201},
+		{method: "MOVE", path: "/static/dir/", headers: map[string]string{"Destination": "/static/moved/"}, status: 201},
+	})
+
+	for _, name := range []string{"moved/a.txt", "moved/b.txt"} {
+		if got, ok := readStatic(t, root, name); !ok || got != "0123456789" {
+			t.Errorf("%s = %q (exists %v), want the moved file", name, got, ok)
+		}
+	}
+	for _, name := range []string{"c.txt", "dir2"} {
+		if _, err := os.Stat(filepath.Join(root, "static", name)); !os.IsNotExist(err) {
+			t.Errorf("%s exists, want it never created", name)
+		}
+	}
+}
+
+func TestWebDAVLocks(t *testing.T) {
+	h, root := newDAVSite(t, map[string]string{
+		"a.txt":     "A",
+		"dir/b.txt": "B",
+	}, WriteOptions{})
+
+	runDAV(t, h, []davStep{
+		// Exclusive lock on a file; writes need its token
+		{method: "LOCK", path: "/static/a.txt", headers: map[string]string{"Timeout": "Second-60"}, body: lockExclusive, status: 200,
+			contains: []string{"<D:lockdiscovery>", "<D:exclusive/>", "<D:timeout>Second-60</D:timeout>", "<D:href>litmus test suite</D:href>"},
+			capture:  "a"},
+		{method: "PROPFIND", path: "/static/a.txt", headers: map[string]string{"Depth": "0"}, status: 207,
+			contains: []string{"<D:href>{a}</D:href>"}},
+		{method: "PUT", path: "/static/a.txt", body: "no token", status: 423},
+		{method: "PROPPATCH", path: "/static/a.txt", body: patchSet, status: 423},
+		{method: "PUT", path: "/static/a.txt", headers: map[string]string{"If": "(<{a}>)"}, body: "with token", status: 204},
+
+		// The If header must hold: 412 for a lock or ETag that doesn't match
+		{method: "PUT", path: "/static/a.txt", headers: map[string]string{"If": "(<opaquelocktoken:00000000-0000-4000-8000-000000000000>)"}, body: "x", status: 412},
+		{method: "PUT", path: "/static/a.txt", headers: map[string]string{"If": `(<{a}> ["wrong-etag"])`}, body: "x", status: 412},
+		{method: "PUT", path: "/static/a.txt", headers: map[string]string{"If": "(Not <{a}>)"}, body: "x", status: 412},
+		{method: "PUT", path: "/static/a.txt", headers: map[string]string{"If": "(<{a}"}, body: "x", status: 400},
+		{method: "PUT", path: "/static/a.txt", headers: map[string]string{"If": "</static/a.txt> (<{a}>)"}, body: "tagged", status: 204},
+
+		// A second exclusive or shared lock conflicts
+		{method: "LOCK", path: "/static/a.txt", body: lockExclusive, status: 423, contains: []string{"<D:no-conflicting-lock/>"}},
+		{method: "LOCK", path: "/static/a.txt", body: lockShared, status: 423},
+
+		// Refresh: empty body, lock named in If
+		{method: "LOCK", path: "/static/a.txt", headers: map[string]string{"If": "(<{a}>)", "Timeout": "Second-120"}, status: 200,
+			contains: []string{"<D:timeout>Second-120</D:timeout>", "<D:href>{a}</D:href>"}},
+		{method: "LOCK", path: "/static/a.txt", headers: map[string]string{"If": "(<opaquelocktoken:00000000-0000-4000-8000-000000000000>)"}, status: 412},
+		{method: "LOCK", path: "/static/a.txt", status: 400},
+
+		// UNLOCK needs the right token on the right URL
+		{method: "UNLOCK", path: "/static/a.txt", headers: map[string]string{"Lock-Token": "<opaquelocktoken:00000000-0000-4000-8000-000000000000>"}, status: 409,
+			contains: []string{"<D:lock-token-matches-request-uri/>"}},
+		{method: "UNLOCK", path: "/static/dir/b.txt", headers: map[string]string{"Lock-Token": "<{a}>"}, status: 409},
+		{method: "UNLOCK", path: "/static/a.txt", headers: map[string]string{"Lock-Token": "{a}"}, status: 400},
+		{method: "UNLOCK", path: "/static/a.txt", headers: map[string]string{"Lock-Token": "<{a}>"}, status: 204},
+		{method: "PUT", path: "/static/a.txt", body: "unlocked", status: 204},
+		{method: "PUT", path: "/static/a.txt", headers: map[string]string{"If": "(<{a}>)"}, body: "x", status: 412},
+
+		// Depth infinity on a collection covers its members and new names
+		{method: "LOCK", path: "/static/dir/", headers: map[string]string{"Depth": "infinity"}, body: lockExclusive, status: 200,
+			contains: []string{"<D:depth>infinity</D:depth>"}, capture: "dir"},
+		{method: "DELETE", path: "/static/dir/b.txt", status: 423},
+		{method: "PUT", path: "/static/dir/c.txt", body: "C", status: 423},
+		{method: "MOVE", path: "/static/a.txt", headers: map[string]string{"Destination": "/static/dir/a.txt"}, status: 423},
+		{method: "PUT", path: "/static/dir/c.txt", headers: map[string]string{"If": "</static/dir/> (<{dir}>)"}, body: "C", status: 201},
+		{method: "UNLOCK", path: "/static/dir/", headers: map[string]string{"Lock-Token": "<{dir}>"}, status: 204},
+
+		// Depth 0 on a collection protects its list of members only
+		{method: "LOCK", path: "/static/dir/", headers: map[string]string{"Depth": "0"}, body: lockExclusive, status: 200,
+			contains: []string{"<D:depth>0</D:depth>"}, capture: "dir0"},
+		{method: "PUT", path: "/static/dir/b.txt", body: "B2", status: 204},
+		{method: "PUT", path: "/static/dir/d.txt", body: "D", status: 423},
+		{method: "UNLOCK", path: "/static/dir/", headers: map[string]string{"Lock-Token": "<{dir0}>"}, status: 204},
+
+		// Shared locks coexist, and each token unlocks writes
+		{method: "LOCK", path: "/static/a.txt", body: lockShared, status: 200, contains: []string{"<D:shared/>"}, capture: "s1"},
+		{method: "LOCK", path: "/static/a.txt", body: lockShared, status: 200, capture: "s2"},
+		{method: "LOCK", path: "/static/a.txt", body: lockExclusive, status: 423},
+		{method: "PUT", path: "/static/a.txt", body: "x", status: 423},
+		{method: "PUT", path: "/static/a.txt", headers: map[string]string{"If": "(<{s1}>) (<{s2}>)"}, body: "shared", status: 204},
+		{method: "UNLOCK", path: "/static/a.txt", headers: map[string]string{"Lock-Token": "<{s1}>"}, status: 204},
+		{method: "UNLOCK", path: "/static/a.txt", headers: map[string]string{"Lock-Token": "<{s2}>"}, status: 204},
+
+		// Locking an unmapped URL creates an empty file
+		{method: "LOCK", path: "/static/new.txt", body: lockExclusive, status: 201, capture: "new"},
+		{method: "UNLOCK", path: "/static/new.txt", headers: map[string]string{"Lock-Token": "<{new}>"}, status: 204},
+		{method: "LOCK", path: "/static/nope/new.txt", body: lockExclusive, status: 409},
+		{method: "LOCK", path: "/static/a.txt", body: "<not-xml", status: 400},
+	})
+
+	for name, want := range map[string]string{"a.txt": "shared", "dir/b.txt": "B2", "dir/c.txt": "C", "new.txt": ""} {
+		if got, ok := readStatic(t, root, name); !ok || got != want {
+			t.Errorf("%s = %q (exists %v), want %q", name, got, ok, want)
+		}
+	}
+}
+
+func TestWebDAVChunkedPut(t *testing.T) {
+	h, root := newDAVSite(t, nil, WriteOptions{MaxFileSize: 1000})
+	body := strings.Repeat("chunked upload\n", 50) // 750 bytes, several chunks
+
+	runDAV(t, h, []davStep{
+		{method: "PUT", path: "/static/finder.txt", headers: map[string]string{"Transfer-Encoding": "chunked"}, body: body, status: 201},
+		{method: "PUT", path: "/static/finder.txt", headers: map[string]string{"Transfer-Encoding": "chunked"}, body: "replaced", status: 204},
+		{method: "PUT", path: "/static/empty.txt", headers: map[string]string{"Transfer-Encoding": "chunked"}, status: 201},
+		{method: "PUT", path: "/static/big.txt", headers: map[string]string{"Transfer-Encoding": "chunked"}, body: body + body, status: 413},
+		{method: "PUT", path: "/static/nolength.txt", status: 411},
+		{method: "MKCOL", path: "/static/dir/", headers: map[string]string{"Transfer-Encoding": "chunked"}, body: "<x/>", status: 415},
+	})
+
+	for name, want := range map[string]string{"finder.txt": "replaced", "empty.txt": ""} {
+		if got, ok := readStatic(t, root, name); !ok || got != want {
+			t.Errorf("%s = %q (exists %v), want %q", name, got, ok, want)
+		}
+	}
+	for _, name := range []string{"big.txt", "nolength.txt", "dir"} {
+		if _, ok := readStatic(t, root, name); ok {
+			t.Errorf("%s was created", name)
+		}
+	}
+}
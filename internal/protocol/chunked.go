@@ -0,0 +1,177 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ErrChunkedWriterClosed is returned when writing after Close
+var ErrChunkedWriterClosed = errors.New("chunked writer closed")
+
+// ErrMalformedChunk is returned for a chunked body that breaks the framing
+var ErrMalformedChunk = errors.New("malformed chunked body")
+
+// ChunkedWriter encodes a body with chunked transfer coding (RFC 9112 section 7.1)
+// Used when the body length is not known before the headers are sent, such as
+// on-the-fly compressed files. Each Write becomes one chunk, so wrap it in a
+// bufio.Writer to avoid tiny chunks.
+type ChunkedWriter struct {
+	w      io.Writer
+	closed bool
+}
+
+// NewChunkedWriter returns a writer that sends chunks to w
+// The response must carry "Transfer-Encoding: chunked" and no Content-Length.
+func NewChunkedWriter(w io.Writer) *ChunkedWriter {
+	return &ChunkedWriter{w: w}
+}
+
+// Write sends p as a single chunk (empty writes are skipped: a zero-size
+// chunk would end the body)
+func (c *ChunkedWriter) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, ErrChunkedWriterClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	chunk := make([]byte, 0, len(p)+16)
+	chunk = fmt.Appendf(chunk, "%x\r\n", len(p))
+	chunk = append(chunk, p...)
+	chunk = append(chunk, "\r\n"...)
+
+	if _, err := c.w.Write(chunk); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the last (zero-size) chunk and the empty trailer section
+// It does not close the underlying writer.
+func (c *ChunkedWriter) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	_, err := io.WriteString(c.w, "0\r\n\r\n")
+	return err
+}
+
+// ChunkedReader decodes a body sent with chunked transfer coding
+// Request bodies from clients that stream without knowing the length (macOS
+// Finder, davfs2, curl -T -) arrive this way. Chunk extensions are ignored
+// and trailer fields are read and dropped; Read returns io.EOF after the
+// last chunk.
+type ChunkedReader struct {
+	r       *bufio.Reader
+	left    int64 // Data bytes left in the current chunk
+	started bool  // The first chunk header has been read
+	done    bool
+	err     error
+}
+
+// NewChunkedReader returns a reader that decodes the chunked body read from r
+// It may read past the end of the body.
+func NewChunkedReader(r io.Reader) *ChunkedReader {
+	return &ChunkedReader{r: bufio.NewReader(r)}
+}
+
+// Read returns the data of the chunks, without the framing
+func (c *ChunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.done {
+		return 0, io.EOF
+	}
+
+	if c.left == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+		if c.done {
+			return 0, io.EOF
+		}
+	}
+
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// nextChunk ends the current chunk and reads the size line of the next one;
+// after the last chunk it consumes the trailer section
+func (c *ChunkedReader) nextChunk() error {
+	if c.started {
+		if line, err := c.readLine(); err != nil {
+			return err
+		} else if len(line) != 0 {
+			return ErrMalformedChunk // Data longer than the chunk size
+		}
+	}
+	c.started = true
+
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if i := bytes.IndexByte(line, ';'); i >= 0 {
+		line = line[:i] // Chunk extensions
+	}
+	line = bytes.TrimRight(line, " \t")
+	if len(line) == 0 || len(line) > 16 {
+		return ErrMalformedChunk
+	}
+	size, err := strconv.ParseInt(string(line), 16, 64)
+	if err != nil || size < 0 {
+		return ErrMalformedChunk
+	}
+	if size > 0 {
+		c.left = size
+		return nil
+	}
+
+	// Last chunk: skip trailer fields up to the empty line
+	for total := 0; ; {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if len(line) == 0 {
+			c.done = true
+			return nil
+		}
+		if total += len(line); total > MaxHeaderSize {
+			return ErrMalformedChunk
+		}
+	}
+}
+
+// readLine reads a CRLF- (or LF-) terminated line without the terminator
+// Lines longer than the read buffer are rejected.
+func (c *ChunkedReader) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	switch {
+	case err == io.EOF:
+		return nil, io.ErrUnexpectedEOF
+	case err == bufio.ErrBufferFull:
+		return nil, ErrMalformedChunk
+	case err != nil:
+		return nil, err
+	}
+	return bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r")), nil
+}
@@ -0,0 +1,123 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"webserver/internal/tcp/tcptest"
+)
+
+func TestChunkedReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{"single chunk", "5\r\nhello\r\n0\r\n\r\n", "hello", nil},
+		{"several chunks", "3\r\nabc\r\n1\r\nd\r\nA\r\n0123456789\r\n0\r\n\r\n", "abcd0123456789", nil},
+		{"empty body", "0\r\n\r\n", "", nil},
+		{"extensions", "5;name=value\r\nhello\r\n0;last\r\n\r\n", "hello", nil},
+		{"trailers", "5\r\nhello\r\n0\r\nChecksum: abc\r\nX-Other: 1\r\n\r\n", "hello", nil},
+		{"bare LF", "5\nhello\n0\n\n", "hello", nil},
+		{"uppercase hex", "B\r\nhello world\r\n0\r\n\r\n", "hello world", nil},
+		{"truncated data", "5\r\nhel", "hel", io.ErrUnexpectedEOF},
+		{"missing last chunk", "5\r\nhello\r\n", "hello", io.ErrUnexpectedEOF},
+		{"data longer than size", "3\r\nhello\r\n0\r\n\r\n", "hel", ErrMalformedChunk},
+		{"bad size", "zz\r\nhello\r\n", "", ErrMalformedChunk},
+		{"negative size", "-5\r\nhello\r\n", "", ErrMalformedChunk},
+		{"oversized size", "11111111111111111\r\n", "", ErrMalformedChunk},
+		{"empty size line", "\r\nhello\r\n", "", ErrMalformedChunk},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := io.ReadAll(NewChunkedReader(strings.NewReader(tt.input)))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChunkedRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+
+	var encoded bytes.Buffer
+	w := NewChunkedWriter(&encoded)
+	for rest := data; len(rest) > 0; {
+		n := min(7919, len(rest))
+		w.Write(rest[:n])
+		rest = rest[n:]
+	}
+	w.Close()
+
+	got, err := io.ReadAll(NewChunkedReader(&encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("round trip mismatch: got %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestParseRequestHeadChunkedBody(t *testing.T) {
+	conn, peer := tcptest.DialPipe(t)
+	go func() {
+		// The body arrives partly with the headers, partly afterwards
+		peer.Write([]byte("PUT /static/a.txt HTTP/1.1\r\nHost: x\r\nTransfer-Encoding: chunked\r\nContent-Length: 3\r\n\r\n5\r\nhel"))
+		peer.Write([]byte("lo\r\n6\r\n world\r\n0\r\n\r\n"))
+	}()
+
+	req, err := ParseRequestHead(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !req.Chunked() {
+		t.Fatal("Chunked() = false, want true")
+	}
+	if n := req.ContentLength(); n != 0 {
+		t.Errorf("ContentLength() = %d, want 0: Transfer-Encoding overrides Content-Length", n)
+	}
+	if req.CanDiscardBody(MaxRequestSize) {
+		t.Error("CanDiscardBody = true for an unread chunked body")
+	}
+
+	body, err := io.ReadAll(req.BodyReader())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "hello world" {
+		t.Errorf("body = %q, want %q", body, "hello world")
+	}
+	if err := req.DiscardBody(MaxRequestSize); err != nil {
+		t.Errorf("DiscardBody after reading the body = %v, want nil", err)
+	}
+}
+
+func TestReadBodyLimitsChunkedBodies(t *testing.T) {
+	conn, peer := tcptest.DialPipe(t)
+	go func() {
+		peer.Write([]byte("POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\n\r\n"))
+		chunk := bytes.Repeat([]byte("x"), 64<<10)
+		w := NewChunkedWriter(peer)
+		for i := 0; i <= MaxRequestSize/len(chunk); i++ {
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+		}
+		w.Close()
+	}()
+
+	req, err := ParseRequestHead(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.ReadBody(); !errors.Is(err, ErrBodyTooLarge) {
+		t.Errorf("ReadBody = %v, want ErrBodyTooLarge", err)
+	}
+}
@@ -2,7 +2,10 @@ package protocol
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"net/textproto"
 	"strings"
 	"webserver/internal/tcp"
 )
@@ -18,9 +21,28 @@ type Request struct {
 	Version HTTPVersion
 	Headers map[string]string
 	Body    string
+	Params  map[string]string // Path parameters captured by the router ("/users/{id}")
+
+	body *bodyReader // Unread body after ParseRequestHead (nil once buffered or drained)
 }
 
+// ParseRequest reads a complete request, buffering its body in Body
 func ParseRequest(conn *tcp.TCPConn) (*Request, error) {
+	req, err := ParseRequestHead(conn)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.ReadBody(); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// ParseRequestHead reads the request line and headers only
+// The body stays on the connection: call ReadBody to buffer it in Body, or
+// BodyReader to stream it (e.g. uploads straight to disk). Any body left
+// unread must be dropped with DiscardBody before the next request is parsed.
+func ParseRequestHead(conn *tcp.TCPConn) (*Request, error) {
 	var allData []byte
 	buf := make([]byte, 4096)
 
@@ -96,30 +118,39 @@ func ParseRequest(conn *tcp.TCPConn) (*Request, error) {
 		}
 		headerParts := strings.SplitN(line, ":", 2)
 		if len(headerParts) == 2 {
-			req.Headers[strings.TrimSpace(headerParts[0])] = strings.TrimSpace(headerParts[1])
+			// Field names are case-insensitive: "host" and "HOST" are stored
+			// as "Host", so handlers can look them up by one spelling
+			name := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(headerParts[0]))
+			req.Headers[name] = strings.TrimSpace(headerParts[1])
 		}
 	}
 
-	// Handle request body
-	if contentLength, ok := req.Headers["Content-Length"]; ok {
-		var expectedLength int
+	// The body is read on demand: first the bytes that arrived with the
+	// headers, then the rest from the connection
+	if req.Chunked() {
+		// Transfer-Encoding overrides Content-Length (RFC 9112 section 6.3);
+		// dropping it keeps handlers from trusting a length that isn't the body's
+		delete(req.Headers, "Content-Length")
+		req.body = &bodyReader{
+			buffered:  bodySectionBytes,
+			conn:      conn,
+			remaining: -1,
+			expect:    strings.EqualFold(req.Headers["Expect"], "100-continue") && req.Version == HTTP11,
+			version:   req.Version,
+		}
+		req.body.chunked = NewChunkedReader(readerFunc(req.body.readRaw))
+	} else if contentLength, ok := req.Headers["Content-Length"]; ok {
+		var expectedLength int64
 		fmt.Sscanf(contentLength, "%d", &expectedLength)
 
 		if expectedLength > 0 {
-			// Calculate how many bytes we've already read from the header read
-			currentBodyBytes := bodySectionBytes
-
-			// Read loop to fetch remaining body data
-			for len(currentBodyBytes) < expectedLength {
-				n, err := conn.Read(buf)
-				if err != nil {
-					return nil, err
-				}
-				currentBodyBytes = append(currentBodyBytes, buf[:n]...)
+			req.body = &bodyReader{
+				buffered:  bodySectionBytes,
+				conn:      conn,
+				remaining: expectedLength,
+				expect:    strings.EqualFold(req.Headers["Expect"], "100-continue") && req.Version == HTTP11,
+				version:   req.Version,
 			}
-
-			// Final assignment: Trim the body to the exact expected length
-			req.Body = string(currentBodyBytes[:expectedLength])
 		}
 	} else {
 		// No Content-Length, use what we have
@@ -128,3 +159,154 @@ func ParseRequest(conn *tcp.TCPConn) (*Request, error) {
 
 	return req, nil
 }
+
+// ErrBodyTooLarge means a chunked body grew past MaxRequestSize while being
+// buffered by ReadBody
+var ErrBodyTooLarge = errors.New("request body exceeded limit")
+
+// ReadBody buffers the unread body in Body
+// Chunked bodies, whose length isn't known up front, are limited to
+// MaxRequestSize; routes taking larger uploads stream them with BodyReader.
+func (r *Request) ReadBody() error {
+	if r.body == nil {
+		return nil
+	}
+
+	var reader io.Reader = r.body
+	if r.body.chunked != nil {
+		reader = io.LimitReader(r.body, MaxRequestSize+1)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	if len(body) > MaxRequestSize {
+		return ErrBodyTooLarge
+	}
+	r.Body = string(body)
+	r.body = nil
+	return nil
+}
+
+// BodyReader returns the request body as a stream
+// After ParseRequestHead it reads straight from the connection, sending
+// "100 Continue" first if the client asked for it; after ReadBody it reads Body.
+func (r *Request) BodyReader() io.Reader {
+	if r.body == nil {
+		return strings.NewReader(r.Body)
+	}
+	return r.body
+}
+
+// Chunked reports whether the body is sent with chunked transfer coding, so
+// its length is only known once it has been read
+func (r *Request) Chunked() bool {
+	codings := strings.Split(r.Headers["Transfer-Encoding"], ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+}
+
+// ContentLength returns the declared body length (0 if none, invalid or chunked)
+func (r *Request) ContentLength() int64 {
+	var length int64
+	fmt.Sscanf(r.Headers["Content-Length"], "%d", &length)
+	if length < 0 {
+		return 0
+	}
+	return length
+}
+
+// ErrBodyNotDrained means too much of a request body was left unread to skip
+// it; the connection must be closed instead of reused
+var ErrBodyNotDrained = errors.New("request body left unread")
+
+// DiscardBody skips whatever the handler left of the body, so the connection
+// can carry the next request. Bodies larger than maxDrain, chunked bodies (of
+// unknown size), and bodies the client is still waiting to send (Expect:
+// 100-continue without a 100), are not read: ErrBodyNotDrained tells the
+// caller to close the connection.
+func (r *Request) DiscardBody(maxDrain int64) error {
+	if r.body == nil || r.body.remaining == 0 {
+		return nil
+	}
+	if !r.CanDiscardBody(maxDrain) {
+		return ErrBodyNotDrained
+	}
+
+	_, err := io.Copy(io.Discard, r.body)
+	r.body = nil
+	return err
+}
+
+// CanDiscardBody reports whether DiscardBody(maxDrain) would keep the
+// connection usable; handlers rejecting a request without reading its body
+// use it to decide between keep-alive and close
+func (r *Request) CanDiscardBody(maxDrain int64) bool {
+	if r.body == nil || r.body.remaining == 0 {
+		return true
+	}
+	return !(r.body.expect && !r.body.continued) && r.body.remaining >= 0 && r.body.remaining <= maxDrain
+}
+
+// bodyReader reads exactly Content-Length bytes of a request body, or a
+// chunked body up to its last chunk
+type bodyReader struct {
+	buffered  []byte // Body bytes read along with the headers
+	conn      *tcp.TCPConn
+	remaining int64          // Bytes still to be returned, buffered ones included (-1 = chunked, not finished)
+	chunked   *ChunkedReader // Decodes the bytes from readRaw (nil = Content-Length body)
+	expect    bool           // Client sent "Expect: 100-continue"
+	continued bool           // "100 Continue" has been sent
+	version   HTTPVersion
+}
+
+// Read returns the body: buffered bytes first, then the rest from the connection
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.chunked != nil {
+		n, err := b.chunked.Read(p)
+		if err == io.EOF {
+			b.remaining = 0
+		}
+		return n, err
+	}
+
+	if b.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.readRaw(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// readRaw reads the body as sent, without regard to its length
+func (b *bodyReader) readRaw(p []byte) (int, error) {
+	if len(b.buffered) > 0 {
+		n := copy(p, b.buffered)
+		b.buffered = b.buffered[n:]
+		return n, nil
+	}
+
+	// The client waits for our go-ahead before sending the body
+	if b.expect && !b.continued {
+		b.continued = true
+		if _, err := b.conn.Write([]byte(string(b.version) + " 100 Continue\r\n\r\n")); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := b.conn.Read(p)
+	if n > 0 {
+		return n, nil
+	}
+	if err == nil {
+		err = io.ErrUnexpectedEOF // Read returned 0 bytes: the peer closed the connection
+	}
+	return 0, err
+}
+
+// readerFunc adapts a read method to io.Reader
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
@@ -0,0 +1,34 @@
+package protocol
+
+import (
+	"testing"
+	"webserver/internal/tcp/tcptest"
+)
+
+func TestParseRequestHeadCanonicalizesHeaderNames(t *testing.T) {
+	conn, peer := tcptest.DialPipe(t)
+	go peer.Write([]byte("GET / HTTP/1.1\r\nhost: example.com\r\nIF-NONE-MATCH: \"x\"\r\naccept-encoding: gzip\r\ncontent-length: 2\r\n\r\nhi"))
+
+	req, err := ParseRequestHead(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Host":            "example.com",
+		"If-None-Match":   `"x"`,
+		"Accept-Encoding": "gzip",
+		"Content-Length":  "2",
+	}
+	for name, value := range want {
+		if got := req.Headers[name]; got != value {
+			t.Errorf("Headers[%q] = %q, want %q", name, got, value)
+		}
+	}
+
+	if err := req.ReadBody(); err != nil {
+		t.Fatal(err)
+	}
+	if req.Body != "hi" {
+		t.Errorf("Body = %q, want %q", req.Body, "hi")
+	}
+}
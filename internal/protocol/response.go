@@ -14,6 +14,10 @@ type Response struct {
 	Body       string
 }
 
+// TimeFormat is the IMF-fixdate format of HTTP dates (RFC 9110 section 5.6.7).
+// time.RFC1123 prints "UTC", which caches must treat as invalid.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
 func NewResponse(statusCode int, status string, version HTTPVersion, body string) *Response {
     resp := &Response{
         Version:    version,
@@ -28,8 +32,14 @@ func NewResponse(statusCode int, status string, version HTTPVersion, body string
 func WriteResponse(conn *tcp.TCPConn, resp *Response) error {
 	response := fmt.Sprintf("%s %d %s\r\n", resp.Version, resp.StatusCode, resp.Status)
 
-	resp.Headers["Content-Length"] = fmt.Sprintf("%d", len(resp.Body))
-	resp.Headers["Date"] = time.Now().UTC().Format(time.RFC1123)
+	// 1xx, 204 and 304 have no body. On a 304 a Content-Length would describe
+	// the cached representation, not this message, so none is sent.
+	if resp.StatusCode < 200 || resp.StatusCode == 204 || resp.StatusCode == 304 {
+		delete(resp.Headers, "Content-Length")
+	} else {
+		resp.Headers["Content-Length"] = fmt.Sprintf("%d", len(resp.Body))
+	}
+	resp.Headers["Date"] = time.Now().UTC().Format(TimeFormat)
 	resp.Headers["Server"] = "GoWebServer/1.0"
 
 	for key, value := range resp.Headers {
@@ -41,3 +51,21 @@ func WriteResponse(conn *tcp.TCPConn, resp *Response) error {
 	_, err := conn.Write([]byte(response))
 	return err
 }
+
+// WriteHeaders writes the status line and headers only, for handlers that
+// stream the body themselves. Content-Length is left to the caller (it must be
+// omitted for chunked bodies).
+func WriteHeaders(conn *tcp.TCPConn, resp *Response) error {
+	headers := fmt.Sprintf("%s %d %s\r\n", resp.Version, resp.StatusCode, resp.Status)
+
+	resp.Headers["Date"] = time.Now().UTC().Format(TimeFormat)
+	resp.Headers["Server"] = "GoWebServer/1.0"
+
+	for key, value := range resp.Headers {
+		headers += fmt.Sprintf("%s: %s\r\n", key, value)
+	}
+	headers += "\r\n"
+
+	_, err := conn.Write([]byte(headers))
+	return err
+}
@@ -0,0 +1,58 @@
+package protocol
+
+import (
+	"bufio"
+	"net/http"
+	"testing"
+	"time"
+	"webserver/internal/tcp/tcptest"
+)
+
+func TestWriteResponseContentLength(t *testing.T) {
+	tests := []struct {
+		code   int
+		status string
+		body   string
+		want   string // Content-Length header ("" = none)
+	}{
+		{200, "OK", "hello", "5"},
+		{200, "OK", "", "0"},
+		{404, "Not Found", "missing", "7"},
+		{204, "No Content", "", ""},
+		{304, "Not Modified", "", ""},
+	}
+	for _, tt := range tests {
+		conn, peer := tcptest.DialPipe(t)
+		resp := NewResponse(tt.code, tt.status, HTTP11, tt.body)
+		resp.Headers["Content-Length"] = "1234" // Left over from the 200 a 304 was made from
+		if err := WriteResponse(conn, resp); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := http.ReadResponse(bufio.NewReader(peer), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.Body.Close()
+		if cl := got.Header.Get("Content-Length"); cl != tt.want {
+			t.Errorf("%d: Content-Length = %q, want %q", tt.code, cl, tt.want)
+		}
+	}
+}
+
+func TestWriteResponseDateIsIMFFixdate(t *testing.T) {
+	conn, peer := tcptest.DialPipe(t)
+	if err := WriteResponse(conn, NewResponse(200, "OK", HTTP11, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := http.ReadResponse(bufio.NewReader(peer), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Body.Close()
+	date := got.Header.Get("Date")
+	if _, err := time.Parse(http.TimeFormat, date); err != nil {
+		t.Errorf("Date = %q, want IMF-fixdate: %v", date, err)
+	}
+}
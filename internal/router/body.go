@@ -0,0 +1,125 @@
+package router
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+)
+
+// DefaultMaxDecodedBodySize caps decompressed request bodies (zip bomb protection)
+const DefaultMaxDecodedBodySize = 8 * 1024 * 1024 // 8MB
+
+// errBodyTooLarge is returned when a decoded body exceeds the configured limit
+var errBodyTooLarge = errors.New("decoded request body too large")
+
+// errUnsupportedEncoding is returned for content codings we cannot decode
+var errUnsupportedEncoding = errors.New("unsupported request content encoding")
+
+// RawBody opts the route out of request body decompression
+// The handler then sees the body exactly as sent, with Content-Encoding intact
+// (e.g. to store uploaded .gz files or proxy them unchanged).
+func (rt *Route) RawBody() *Route {
+	rt.rawBody = true
+	return rt
+}
+
+// StreamBody leaves the request body on the connection for the handler to
+// read with req.BodyReader (e.g. uploads written straight to disk). The body
+// is neither buffered nor decompressed; whatever the handler doesn't read is
+// skipped, or the connection closed, before the next request.
+func (rt *Route) StreamBody() *Route {
+	rt.streamBody = true
+	return rt
+}
+
+// SetMaxDecodedBodySize sets the largest body a compressed request may expand to
+// Larger bodies are rejected with 413. Zero or negative disables decompression,
+// passing compressed bodies through to every handler.
+func (r *Router) SetMaxDecodedBodySize(size int64) {
+	r.maxDecodedBody = size
+}
+
+// decodeRequestBody decompresses req.Body according to its Content-Encoding
+// On success the header is removed and Content-Length updated, so handlers
+// see a plain body. Codings are undone in reverse order ("gzip, deflate" was
+// gzipped first, then deflated).
+func decodeRequestBody(req *protocol.Request, maxSize int64) error {
+	header := req.Headers["Content-Encoding"]
+	if header == "" || req.Body == "" {
+		return nil
+	}
+
+	codings := strings.Split(header, ",")
+	body := []byte(req.Body)
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		if body, err = decodeBody(strings.ToLower(strings.TrimSpace(codings[i])), body, maxSize); err != nil {
+			return err
+		}
+	}
+
+	req.Body = string(body)
+	delete(req.Headers, "Content-Encoding")
+	req.Headers["Content-Length"] = fmt.Sprintf("%d", len(body))
+	return nil
+}
+
+// decodeBody undoes a single content coding, reading at most maxSize bytes
+func decodeBody(coding string, body []byte, maxSize int64) ([]byte, error) {
+	var reader io.ReadCloser
+	var err error
+
+	switch coding {
+	case "identity", "":
+		return body, nil
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	case "deflate":
+		// HTTP "deflate" is zlib-wrapped, but some clients send raw DEFLATE
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			reader, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	default:
+		return nil, errUnsupportedEncoding
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// Read one byte past the limit to tell "exactly maxSize" from "too large"
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > maxSize {
+		return nil, errBodyTooLarge
+	}
+	return decoded, nil
+}
+
+// bodyErrorHandler answers a request whose body could not be decoded
+// 413 for bodies over the limit, 415 for unknown codings, 400 for corrupt data.
+func (r *Router) bodyErrorHandler(err error) StreamHandlerFunc {
+	return func(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+		switch {
+		case errors.Is(err, errBodyTooLarge):
+			return r.WriteError(conn, req, 413, "Content Too Large", keepAlive, remainingRequests)
+		case errors.Is(err, errUnsupportedEncoding):
+			// RFC 9110 section 15.5.16: tell the client which codings we accept
+			resp := r.Error(req, 415, "Unsupported Media Type")
+			resp.Headers["Accept-Encoding"] = "gzip, deflate"
+			return r.writeResponse(conn, resp, keepAlive, remainingRequests)
+		default:
+			return r.WriteError(conn, req, 400, "Bad Request", keepAlive, remainingRequests)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+)
+
+// ErrorHandlerFunc renders the response for an error status code
+// status is the reason phrase (e.g. "Not Found") or a more specific message
+type ErrorHandlerFunc func(req *protocol.Request, code int, status string) *protocol.Response
+
+// errorRoute is a registered error handler scoped to a path pattern and status code
+type errorRoute struct {
+	pattern string // Exact path or prefix pattern ending in "*"
+	code    int    // Status code, or 0 for every error status
+	handler ErrorHandlerFunc
+}
+
+// RegisterErrorHandler registers a custom error page for requests matching pattern
+// Patterns work like route patterns ("/api/*" matches everything under /api/).
+// A code of 0 makes the handler the fallback for every error status on that pattern.
+//
+// Example:
+//
+//	r.RegisterErrorHandler("/*", 0, htmlErrorPage)        // HTML pages everywhere
+//	r.RegisterErrorHandler("/api/*", 0, problemJSON)      // problem+json for the API
+//	r.RegisterErrorHandler("/*", 404, customNotFoundPage) // Dedicated 404 page
+func (r *Router) RegisterErrorHandler(pattern string, code int, handler ErrorHandlerFunc) {
+	for i, existing := range r.errorRoutes {
+		if existing.pattern == pattern && existing.code == code {
+			r.errorRoutes[i].handler = handler
+			return
+		}
+	}
+	r.errorRoutes = append(r.errorRoutes, errorRoute{pattern: pattern, code: code, handler: handler})
+
+	// Most specific pattern first so "/api/*" wins over "/*",
+	// and within a pattern exact codes come before the 0 fallback
+	sort.SliceStable(r.errorRoutes, func(i, j int) bool {
+		a, b := r.errorRoutes[i], r.errorRoutes[j]
+		if len(a.pattern) != len(b.pattern) {
+			return len(a.pattern) > len(b.pattern)
+		}
+		return a.code != 0 && b.code == 0
+	})
+}
+
+// Error builds the error response for a request using the registered error handlers
+// The most specific pattern wins; within a pattern an exact status code beats the
+// 0 fallback. Without a matching handler a plain-text body is returned.
+func (r *Router) Error(req *protocol.Request, code int, status string) *protocol.Response {
+	path := requestPath(req)
+
+	for _, er := range r.errorRoutes {
+		if (er.code == code || er.code == 0) && matchPattern(er.pattern, path) {
+			return er.handler(req, code, status)
+		}
+	}
+
+	return DefaultErrorHandler(req, code, status)
+}
+
+// WriteError renders an error response and writes it directly to the connection
+// Streaming handlers use this so their errors look the same as buffered ones
+func (r *Router) WriteError(conn *tcp.TCPConn, req *protocol.Request, code int, status string, keepAlive bool, remainingRequests int) error {
+	return r.writeResponse(conn, r.Error(req, code, status), keepAlive, remainingRequests)
+}
+
+// DefaultErrorHandler renders the built-in plain-text error body ("404 - Not Found")
+func DefaultErrorHandler(req *protocol.Request, code int, status string) *protocol.Response {
+	resp := protocol.NewResponse(code, status, req.Version, fmt.Sprintf("%d - %s", code, status))
+	resp.Headers["Content-Type"] = "text/plain"
+	return resp
+}
+
+// matchPattern reports whether path matches an exact or "*"-suffixed prefix pattern
+func matchPattern(pattern, path string) bool {
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(path, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == path
+}
+
+// setConnectionHeaders sets Connection/Keep-Alive headers for the response
+func setConnectionHeaders(resp *protocol.Response, keepAlive bool, remainingRequests int) {
+	if keepAlive {
+		resp.Headers["Connection"] = "keep-alive"
+		resp.Headers["Keep-Alive"] = fmt.Sprintf("timeout=30, max=%d", remainingRequests)
+	} else {
+		resp.Headers["Connection"] = "close"
+	}
+}
@@ -0,0 +1,53 @@
+package router
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+)
+
+// Middleware wraps a handler with extra behaviour (recovery, logging, auth...)
+// Buffered routes are adapted to StreamHandlerFunc before middleware runs, so
+// the same middleware applies to buffered and streaming routes alike
+type Middleware func(next StreamHandlerFunc) StreamHandlerFunc
+
+// Use appends middleware to the chain applied to every request
+// The first middleware registered is the outermost one
+func (r *Router) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// Recover returns middleware that turns handler panics into 500 responses
+// The panic value and stack trace are logged. If the handler already started
+// writing its response, a 500 can no longer be sent cleanly, so the connection
+// is closed instead. In both cases an error is returned so the server drops
+// the connection rather than reusing it in an unknown state.
+func (r *Router) Recover() Middleware {
+	return func(next StreamHandlerFunc) StreamHandlerFunc {
+		return func(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) (err error) {
+			written := conn.BytesWritten()
+
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+
+				log.Printf("panic serving %s %s: %v\n%s", req.Method, req.Path, rec, debug.Stack())
+
+				if conn.BytesWritten() == written {
+					// Nothing sent yet - respond with the 500 error page
+					if writeErr := r.WriteError(conn, req, 500, "Internal Server Error", false, 0); writeErr != nil {
+						err = writeErr
+						return
+					}
+				}
+				err = fmt.Errorf("handler panic: %v", rec)
+			}()
+
+			return next(req, conn, keepAlive, remainingRequests)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package router
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"webserver/internal/protocol"
+)
+
+// PathPolicy controls how the router canonicalizes request paths
+type PathPolicy struct {
+	// CleanPath redirects non-canonical paths ("//hello", "/a/./b/../c") to their cleaned form
+	CleanPath bool
+
+	// TrailingSlash redirects "/hello/" → "/hello" (or "/docs" → "/docs/") when
+	// no route matches the requested form but one matches the other
+	TrailingSlash bool
+}
+
+// DefaultPathPolicy enables both path cleaning and trailing-slash redirects
+func DefaultPathPolicy() PathPolicy {
+	return PathPolicy{CleanPath: true, TrailingSlash: true}
+}
+
+// Group registers routes under a common path prefix with their own PathPolicy
+//
+// Example:
+//
+//	api := r.Group("/api")
+//	api.SetPathPolicy(router.PathPolicy{CleanPath: true}) // No slash redirects for the API
+//	api.RegisterRoute("GET", "/users", handleGetUsers)   // GET /api/users
+type Group struct {
+	router *Router
+	prefix string
+	policy PathPolicy
+}
+
+// Group creates a route group for prefix, starting with the router's path policy
+func (r *Router) Group(prefix string) *Group {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	for _, g := range r.groups {
+		if g.prefix == prefix {
+			return g
+		}
+	}
+
+	g := &Group{router: r, prefix: prefix, policy: r.pathPolicy}
+	r.groups = append(r.groups, g)
+
+	// Most specific group first so "/api/v2" wins over "/api"
+	sort.SliceStable(r.groups, func(i, j int) bool {
+		return len(r.groups[i].prefix) > len(r.groups[j].prefix)
+	})
+
+	return g
+}
+
+// SetPathPolicy sets how paths under this group are canonicalized
+func (g *Group) SetPathPolicy(policy PathPolicy) *Group {
+	g.policy = policy
+	return g
+}
+
+// RegisterRoute registers a buffered handler at prefix+path
+func (g *Group) RegisterRoute(method, path string, handler HandlerFunc) *Route {
+	return g.router.RegisterRoute(method, g.prefix+path, handler)
+}
+
+// RegisterStreamRoute registers a streaming handler at prefix+path
+func (g *Group) RegisterStreamRoute(method, path string, handler StreamHandlerFunc) *Route {
+	return g.router.RegisterStreamRoute(method, g.prefix+path, handler)
+}
+
+// SetPathPolicy sets the policy for paths outside any group
+func (r *Router) SetPathPolicy(policy PathPolicy) {
+	r.pathPolicy = policy
+}
+
+// pathPolicyFor returns the policy of the most specific group containing path
+func (r *Router) pathPolicyFor(p string) PathPolicy {
+	for _, g := range r.groups {
+		if p == g.prefix || strings.HasPrefix(p, g.prefix+"/") {
+			return g.policy
+		}
+	}
+	return r.pathPolicy
+}
+
+// canonicalRedirect returns the URL a request should be redirected to, if any
+// The query string is preserved. Path cleaning is checked first, then trailing
+// slashes (only when the requested form has no route but the other form does).
+func (r *Router) canonicalRedirect(req *protocol.Request) (string, bool) {
+	p := requestPath(req)
+	query := ""
+	if idx := strings.Index(req.Path, "?"); idx != -1 {
+		query = req.Path[idx:]
+	}
+
+	// Only origin-form paths can be canonicalized ("*" and absolute URLs are left alone)
+	if !strings.HasPrefix(p, "/") {
+		return "", false
+	}
+
+	cleaned := cleanPath(p)
+	policy := r.pathPolicyFor(cleaned)
+
+	if policy.CleanPath && cleaned != p {
+		return cleaned + query, true
+	}
+
+	if policy.TrailingSlash && p != "/" && r.matchPath(req.Method, p) == nil {
+		var other string
+		if strings.HasSuffix(p, "/") {
+			other = strings.TrimSuffix(p, "/")
+		} else {
+			other = p + "/"
+		}
+		if r.matchPath(req.Method, other) != nil {
+			return other + query, true
+		}
+	}
+
+	return "", false
+}
+
+// redirect builds a redirect response to location
+// GET and HEAD use 301; other methods use 308 so the method and body are kept
+func (r *Router) redirect(req *protocol.Request, location string) *protocol.Response {
+	code, status := 301, "Moved Permanently"
+	if req.Method != "GET" && req.Method != "HEAD" {
+		code, status = 308, "Permanent Redirect"
+	}
+
+	resp := protocol.NewResponse(code, status, req.Version, fmt.Sprintf("%d - %s: %s", code, status, location))
+	resp.Headers["Location"] = location
+	resp.Headers["Content-Type"] = "text/plain"
+	return resp
+}
+
+// cleanPath returns the canonical form of an absolute URL path
+// Repeated slashes and "." / ".." segments are resolved; a trailing slash is kept
+func cleanPath(p string) string {
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
@@ -1,6 +1,7 @@
 package router
 
 import (
+	"sort"
 	"strings"
 	"webserver/internal/protocol"
 	"webserver/internal/tcp"
@@ -9,56 +10,279 @@ import (
 type HandlerFunc func(*protocol.Request) *protocol.Response
 type StreamHandlerFunc func(*protocol.Request, *tcp.TCPConn, bool, int) error
 
+// Route is a single registered endpoint
+// Exactly one of handler (buffered) or stream (writes directly to the connection) is set.
+// Register* methods return the Route so it can be named or given its own middleware:
+//
+//	r.RegisterRoute("GET", "/users/{id}", handleUser).Name("user.show")
+type Route struct {
+	method     string
+	pattern    string   // Exact path ("/hello"), "{param}" segments ("/users/{id}") or prefix ending in "*" ("/static/*")
+	segments   []string // Pattern split on "/" (only for "{param}" patterns)
+	name       string
+	handler    HandlerFunc
+	stream     StreamHandlerFunc
+	middleware []Middleware // Route-specific middleware, applied inside the global chain
+	rawBody    bool         // Skip request body decompression (see RawBody)
+	streamBody bool         // Handler reads the body from the connection (see StreamBody)
+	router     *Router
+}
+
 type Router struct {
-	routes        map[string]HandlerFunc // Key: "METHOD:PATH" for exact matches
-	streamHandler StreamHandlerFunc      // Single streaming handler for static files
+	routes      map[string]*Route // Key: "METHOD:PATH" for exact matches
+	params      []*Route          // "{param}" routes, in registration order
+	prefixes    []*Route          // Prefix routes, longest pattern first
+	named       map[string]*Route // Routes by name for URL building
+	errorRoutes []errorRoute      // Custom error handlers, most specific pattern first
+	middleware  []Middleware      // Applied to every request, outermost first
+	groups      []*Group          // Route groups with their own PathPolicy, longest prefix first
+	pathPolicy  PathPolicy        // Policy for paths outside any group
+
+	maxDecodedBody int64 // Limit for decompressed request bodies (<= 0 disables decoding)
+
+	version protocol.HTTPVersion // Status-line version of buffered responses ("" = the request's)
 }
 
 func NewRouter() *Router {
 	return &Router{
-		routes: make(map[string]HandlerFunc),
+		routes:     make(map[string]*Route),
+		named:      make(map[string]*Route),
+		pathPolicy: DefaultPathPolicy(),
+
+		maxDecodedBody: DefaultMaxDecodedBodySize,
+	}
+}
+
+// RegisterRoute registers a buffered handler that returns a *protocol.Response
+// A path ending in "*" matches every path sharing that prefix (e.g. "/api/*"),
+// and "{name}" segments capture path parameters into req.Params.
+func (r *Router) RegisterRoute(method, path string, handler HandlerFunc) *Route {
+	return r.add(&Route{method: method, pattern: path, handler: handler})
+}
+
+// RegisterStreamRoute registers a streaming handler for method and path
+// Streaming handlers write directly to the TCP connection for memory efficiency
+// (large files, uploads, CSV exports). Path patterns work as in RegisterRoute.
+func (r *Router) RegisterStreamRoute(method, path string, handler StreamHandlerFunc) *Route {
+	return r.add(&Route{method: method, pattern: path, stream: handler})
+}
+
+// Name gives the route a name for reverse URL building with Router.URL
+func (rt *Route) Name(name string) *Route {
+	if rt.name != "" {
+		delete(rt.router.named, rt.name)
+	}
+	rt.name = name
+	rt.router.named[name] = rt
+	return rt
+}
+
+// Use adds middleware that only runs for this route
+func (rt *Route) Use(middleware ...Middleware) *Route {
+	rt.middleware = append(rt.middleware, middleware...)
+	return rt
+}
+
+// add stores a route, replacing any previous route with the same method and pattern
+func (r *Router) add(rt *Route) *Route {
+	rt.router = r
+
+	switch {
+	case strings.HasSuffix(rt.pattern, "*"):
+		r.prefixes = replaceOrAppend(r.prefixes, rt)
+
+		// Keep the most specific prefix first so "/static/videos/*" wins over "/static/*"
+		sort.SliceStable(r.prefixes, func(i, j int) bool {
+			return len(r.prefixes[i].pattern) > len(r.prefixes[j].pattern)
+		})
+
+	case strings.Contains(rt.pattern, "{"):
+		rt.segments = strings.Split(rt.pattern, "/")
+		r.params = replaceOrAppend(r.params, rt)
+
+	default:
+		r.routes[rt.method+":"+rt.pattern] = rt
+	}
+
+	return rt
+}
+
+// replaceOrAppend replaces the route with the same method and pattern, or appends it
+func replaceOrAppend(routes []*Route, rt *Route) []*Route {
+	for i, existing := range routes {
+		if existing.method == rt.method && existing.pattern == rt.pattern {
+			routes[i] = rt
+			return routes
+		}
+	}
+	return append(routes, rt)
+}
+
+// match finds the route for a request and stores captured parameters in req.Params
+func (r *Router) match(req *protocol.Request) *Route {
+	rt, params := r.lookup(req.Method, requestPath(req))
+	if params != nil {
+		req.Params = params
+	}
+	return rt
+}
+
+// matchPath reports the route that would handle method and path, if any
+func (r *Router) matchPath(method, path string) *Route {
+	rt, _ := r.lookup(method, path)
+	return rt
+}
+
+// lookup finds the route for method and path: exact matches first, then
+// "{param}" routes, then the longest prefix
+func (r *Router) lookup(method, path string) (*Route, map[string]string) {
+	if rt, found := r.routes[method+":"+path]; found {
+		return rt, nil
+	}
+
+	if len(r.params) > 0 {
+		segments := strings.Split(path, "/")
+		for _, rt := range r.params {
+			if rt.method != method {
+				continue
+			}
+			if params, ok := matchSegments(rt.segments, segments); ok {
+				return rt, params
+			}
+		}
+	}
+
+	for _, rt := range r.prefixes {
+		if rt.method == method && matchPattern(rt.pattern, path) {
+			return rt, nil
+		}
 	}
+
+	return nil, nil
 }
 
-func (r *Router) RegisterRoute(method, path string, handler HandlerFunc) {
-	key := method + ":" + path
-	r.routes[key] = handler
+// matchSegments matches path segments against a "{param}" pattern
+func matchSegments(pattern, path []string) (map[string]string, bool) {
+	if len(pattern) != len(path) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	for i, seg := range pattern {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if path[i] == "" {
+				return nil, false
+			}
+			params[seg[1:len(seg)-1]] = path[i]
+			continue
+		}
+		if seg != path[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
 }
 
-// SetStreamHandler sets the streaming handler for static files (/static/*)
-// Streaming handlers write directly to TCP connection for memory efficiency
-func (r *Router) SetStreamHandler(handler StreamHandlerFunc) {
-	r.streamHandler = handler
+// NeedsStreaming reports whether the request is handled by a streaming route
+func (r *Router) NeedsStreaming(req *protocol.Request) bool {
+	rt := r.match(req)
+	return rt != nil && rt.stream != nil
 }
 
+// Route runs a buffered handler and returns its response
+// Non-canonical paths produce a redirect; streaming routes and unknown paths a 404
 func (r *Router) Route(req *protocol.Request) *protocol.Response {
-	key := req.Method + ":" + req.Path
+	if location, ok := r.canonicalRedirect(req); ok {
+		return r.redirect(req, location)
+	}
 
-	// Try exact match
-	if handler, found := r.routes[key]; found {
-		return handler(req)
+	if rt := r.match(req); rt != nil && rt.handler != nil {
+		return rt.handler(req)
 	}
 
 	// Not found
-	resp := protocol.NewResponse(404, "Not Found", req.Version, "404 - Page Not Found")
-	resp.Headers["Content-Type"] = "text/plain"
-	return resp
+	return r.Error(req, 404, "Not Found")
 }
 
-// NeedsStreaming checks if the request is for static files
-// Static files (GET /static/*) use streaming for memory efficiency
-func (r *Router) NeedsStreaming(req *protocol.Request) bool {
-	return (req.Method == "GET" || req.Method == "HEAD") && strings.HasPrefix(req.Path, "/static/")
+// Serve dispatches a request to its route and writes the response to conn
+// Streaming routes write directly to the connection; buffered routes return a
+// Response that is written here with the appropriate Connection headers.
+// Global middleware wraps route middleware, which wraps the handler.
+// Non-canonical paths are redirected before any route runs, and gzip/deflate
+// request bodies are decompressed (see SetMaxDecodedBodySize and Route.RawBody).
+func (r *Router) Serve(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+	var handler StreamHandlerFunc
+	var rt *Route
+	if _, redirect := r.canonicalRedirect(req); !redirect {
+		rt = r.match(req)
+	}
+
+	switch {
+	case rt == nil:
+		handler = r.buffered(r.Route)
+	case rt.stream != nil:
+		handler = rt.stream
+	default:
+		handler = r.buffered(rt.handler)
+	}
+
+	// Bodies are buffered in req.Body unless the route streams them itself
+	if rt == nil || !rt.streamBody {
+		if err := req.ReadBody(); err != nil {
+			return err
+		}
+	}
+
+	// Compressed request bodies are decoded before any handler sees them,
+	// unless the route asked for the raw body
+	if rt != nil && !rt.rawBody && !rt.streamBody && r.maxDecodedBody > 0 {
+		if err := decodeRequestBody(req, r.maxDecodedBody); err != nil {
+			handler = r.bodyErrorHandler(err)
+		}
+	}
+
+	if rt != nil {
+		handler = chain(handler, rt.middleware)
+	}
+	handler = chain(handler, r.middleware)
+
+	return handler(req, conn, keepAlive, remainingRequests)
+}
+
+// SetProtocolVersion makes buffered responses and error pages answer with the
+// server's protocol version instead of echoing the request's (the server sets
+// it on every site when it starts)
+func (r *Router) SetProtocolVersion(version protocol.HTTPVersion) {
+	r.version = version
 }
 
-// RouteStream routes request to the streaming handler for static files
-func (r *Router) RouteStream(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
-	if r.streamHandler != nil {
-		return r.streamHandler(req, conn, keepAlive, remainingRequests)
+// buffered adapts a HandlerFunc to the streaming signature by writing its Response
+func (r *Router) buffered(handler HandlerFunc) StreamHandlerFunc {
+	return func(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+		return r.writeResponse(conn, handler(req), keepAlive, remainingRequests)
 	}
+}
 
-	// No stream handler configured - send error
-	resp := protocol.NewResponse(500, "Internal Server Error", req.Version, "500 - Stream handler not configured")
-	resp.Headers["Content-Type"] = "text/plain"
+// writeResponse sends resp with the configured protocol version and the
+// connection headers
+func (r *Router) writeResponse(conn *tcp.TCPConn, resp *protocol.Response, keepAlive bool, remainingRequests int) error {
+	if r.version != "" {
+		resp.Version = r.version
+	}
+	setConnectionHeaders(resp, keepAlive, remainingRequests)
 	return protocol.WriteResponse(conn, resp)
 }
+
+// chain wraps handler with middleware, the first middleware being the outermost
+func chain(handler StreamHandlerFunc, middleware []Middleware) StreamHandlerFunc {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// requestPath returns the request path without its query string
+func requestPath(req *protocol.Request) string {
+	return strings.Split(req.Path, "?")[0]
+}
@@ -0,0 +1,70 @@
+package router
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp/tcptest"
+)
+
+func TestBufferedResponsesUseProtocolVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version protocol.HTTPVersion // Set with SetProtocolVersion ("" = not set)
+		path    string
+		want    string
+	}{
+		{"configured version", protocol.HTTP10, "/hello", "HTTP/1.0 200"},
+		{"configured version on errors", protocol.HTTP10, "/missing", "HTTP/1.0 404"},
+		{"echo without a configured version", "", "/hello", "HTTP/1.1 200"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRouter()
+			r.RegisterRoute("GET", "/hello", func(req *protocol.Request) *protocol.Response {
+				return protocol.NewResponse(200, "OK", req.Version, "hi")
+			})
+			if tt.version != "" {
+				r.SetProtocolVersion(tt.version)
+			}
+
+			conn, peer := tcptest.DialPipe(t)
+			req := &protocol.Request{Method: "GET", Path: tt.path, Version: protocol.HTTP11, Headers: map[string]string{}}
+			if err := r.Serve(req, conn, false, 0); err != nil {
+				t.Fatal(err)
+			}
+
+			status, err := bufio.NewReader(peer).ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(status, tt.want) {
+				t.Errorf("status line = %q, want %q", status, tt.want)
+			}
+		})
+	}
+}
+
+func TestBodyErrorsUseProtocolVersion(t *testing.T) {
+	r := NewRouter()
+	r.RegisterRoute("POST", "/upload", func(req *protocol.Request) *protocol.Response {
+		return protocol.NewResponse(200, "OK", req.Version, "")
+	})
+	r.SetProtocolVersion(protocol.HTTP10)
+
+	conn, peer := tcptest.DialPipe(t)
+	req := &protocol.Request{Method: "POST", Path: "/upload", Version: protocol.HTTP11, Body: "data",
+		Headers: map[string]string{"Content-Encoding": "br"}}
+	if err := r.Serve(req, conn, false, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := bufio.NewReader(peer).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(status, "HTTP/1.0 415") {
+		t.Errorf("status line = %q, want %q", status, "HTTP/1.0 415")
+	}
+}
@@ -0,0 +1,165 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// RouteInfo describes a registered route for introspection (e.g. /debug/routes)
+type RouteInfo struct {
+	Method     string   `json:"method"`
+	Pattern    string   `json:"pattern"`
+	Name       string   `json:"name,omitempty"`
+	Streaming  bool     `json:"streaming"`
+	Middleware []string `json:"middleware"` // Global then route-specific, outermost first
+}
+
+// Routes lists every registered route, sorted by pattern then method
+func (r *Router) Routes() []RouteInfo {
+	var all []*Route
+	for _, rt := range r.routes {
+		all = append(all, rt)
+	}
+	all = append(all, r.params...)
+	all = append(all, r.prefixes...)
+
+	infos := make([]RouteInfo, 0, len(all))
+	for _, rt := range all {
+		names := make([]string, 0, len(r.middleware)+len(rt.middleware))
+		for _, mw := range r.middleware {
+			names = append(names, middlewareName(mw))
+		}
+		for _, mw := range rt.middleware {
+			names = append(names, middlewareName(mw))
+		}
+
+		infos = append(infos, RouteInfo{
+			Method:     rt.method,
+			Pattern:    rt.pattern,
+			Name:       rt.name,
+			Streaming:  rt.stream != nil,
+			Middleware: names,
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Pattern != infos[j].Pattern {
+			return infos[i].Pattern < infos[j].Pattern
+		}
+		return infos[i].Method < infos[j].Method
+	})
+
+	return infos
+}
+
+// URL builds the path for a named route from key/value pairs
+// "{param}" segments are filled from the matching keys and the key "*" fills a
+// prefix route's wildcard; any remaining pairs become the query string.
+//
+// Example:
+//
+//	r.RegisterRoute("GET", "/users/{id}", handleUser).Name("user.show")
+//	r.URL("user.show", "id", "42")            // "/users/42"
+//	r.URL("user.show", "id", "42", "tab", "x") // "/users/42?tab=x"
+func (r *Router) URL(name string, pairs ...string) (string, error) {
+	rt, found := r.named[name]
+	if !found {
+		return "", fmt.Errorf("no route named %q", name)
+	}
+	if len(pairs)%2 != 0 {
+		return "", fmt.Errorf("route %q: odd number of key/value arguments", name)
+	}
+
+	values := make(map[string]string, len(pairs)/2)
+	var keys []string
+	for i := 0; i < len(pairs); i += 2 {
+		if _, dup := values[pairs[i]]; !dup {
+			keys = append(keys, pairs[i])
+		}
+		values[pairs[i]] = pairs[i+1]
+	}
+	used := make(map[string]bool)
+
+	path := rt.pattern
+	switch {
+	case strings.HasSuffix(path, "*"):
+		rest := values["*"]
+		used["*"] = true
+		var escaped []string
+		for _, seg := range strings.Split(strings.TrimPrefix(rest, "/"), "/") {
+			escaped = append(escaped, url.PathEscape(seg))
+		}
+		path = strings.TrimSuffix(path, "*") + strings.Join(escaped, "/")
+
+	case rt.segments != nil:
+		segments := make([]string, len(rt.segments))
+		for i, seg := range rt.segments {
+			if !strings.HasPrefix(seg, "{") || !strings.HasSuffix(seg, "}") {
+				segments[i] = seg
+				continue
+			}
+			key := seg[1 : len(seg)-1]
+			value, ok := values[key]
+			if !ok || value == "" {
+				return "", fmt.Errorf("route %q: missing parameter %q", name, key)
+			}
+			segments[i] = url.PathEscape(value)
+			used[key] = true
+		}
+		path = strings.Join(segments, "/")
+	}
+
+	// Leftover pairs become the query string, in argument order
+	query := url.Values{}
+	for _, key := range keys {
+		if !used[key] {
+			query.Set(key, values[key])
+		}
+	}
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	return path, nil
+}
+
+// middlewareName derives a readable name from a middleware's function
+// "webserver/internal/router.(*Router).Recover.func1" → "Recover"
+func middlewareName(mw Middleware) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(mw).Pointer())
+	if fn == nil {
+		return "anonymous"
+	}
+
+	name := fn.Name()
+	if idx := strings.LastIndex(name, "/"); idx != -1 {
+		name = name[idx+1:]
+	}
+
+	// Drop the package and receiver, then closure suffixes like ".func1"
+	parts := strings.Split(name, ".")
+	var kept []string
+	for _, part := range parts[1:] {
+		if isClosureSuffix(part) || strings.HasPrefix(part, "(") {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	if len(kept) == 0 {
+		return name
+	}
+	return strings.Join(kept, ".")
+}
+
+// isClosureSuffix reports whether a symbol part is a compiler closure name ("func1")
+func isClosureSuffix(part string) bool {
+	digits := strings.TrimPrefix(part, "func")
+	if digits == part || digits == "" {
+		return false
+	}
+	return strings.Trim(digits, "0123456789") == ""
+}
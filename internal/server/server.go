@@ -3,35 +3,45 @@ package server
 import (
 	"fmt"
 	"log"
+	"runtime/debug"
 	"strings"
 	"time"
 	"webserver/internal/handler"
 	"webserver/internal/protocol"
+	"webserver/internal/router"
 	"webserver/internal/tcp"
 )
 
 type Server struct {
-	addr    string
-	handler *handler.HTTPHandler
-	config  *protocol.ProtocolConfig
+	addr   string
+	hosts  *VirtualHosts // Per-Host sites; the bundled site is the default host
+	config *protocol.ProtocolConfig
 }
 
 func NewServer(addr string) *Server {
-	return &Server{
-		addr:    addr,
-		handler: handler.NewHTTPHandler(),
-		config:  protocol.NewHTTP11Config(),
-	}
+	return NewServerWithVersion(addr, protocol.NewHTTP11Config())
 }
 
 func NewServerWithVersion(addr string, config *protocol.ProtocolConfig) *Server {
+	hosts := NewVirtualHosts()
+	hosts.SetDefault(handler.NewHTTPHandler())
+	return NewServerWithHosts(addr, config, hosts)
+}
+
+// NewServerWithHosts creates a server that dispatches requests by Host header
+func NewServerWithHosts(addr string, config *protocol.ProtocolConfig, hosts *VirtualHosts) *Server {
 	return &Server{
-		addr:    addr,
-		handler: handler.NewHTTPHandler(),
-		config:  config,
+		addr:   addr,
+		hosts:  hosts,
+		config: config,
 	}
 }
 
+// Hosts returns the virtual host table so sites can be added before Start
+func (s *Server) Hosts() *VirtualHosts {
+	return s.hosts
+}
+
 func (s *Server) Start() error {
 	listener, err := tcp.Listen("tcp", s.addr)
 	if err != nil {
@@ -41,6 +51,11 @@ func (s *Server) Start() error {
 
 	log.Printf("Server running with %s protocol", s.config.Version)
 
+	// Buffered responses carry the configured version, not the client's
+	s.hosts.each(func(site *handler.HTTPHandler) {
+		site.Router().SetProtocolVersion(s.config.Version)
+	})
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -54,6 +69,14 @@ func (s *Server) Start() error {
 func (s *Server) handleConnection(conn *tcp.TCPConn) {
 	defer conn.Close()
 
+	// Last line of defence: a panic outside the router's recovery middleware
+	// (e.g. while parsing) must not crash the whole process
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("panic on connection %s: %v\n%s", conn.RemoteAddr(), rec, debug.Stack())
+		}
+	}()
+
 	// Set initial read deadline
 	conn.SetReadDeadline(time.Now().Add(30 * time.Second))
 
@@ -62,7 +85,9 @@ func (s *Server) handleConnection(conn *tcp.TCPConn) {
 	maxRequests := 100
 
 	for {
-		request, err := protocol.ParseRequest(conn)
+		// Headers only: the router buffers the body, or leaves it to a
+		// streaming upload handler
+		request, err := protocol.ParseRequestHead(conn)
 		if err != nil {
 			return
 		}
@@ -84,39 +109,32 @@ func (s *Server) handleConnection(conn *tcp.TCPConn) {
 			}
 		}
 
-		// Check if route needs streaming (for large files)
-		if s.handler.NeedsStreaming(request) {
-			// Use streaming handler (writes directly to connection)
-			// Pass keepAlive flag to set appropriate Connection headers
-			shouldKeepAlive := keepAlive && requestCount < maxRequests
-			remainingRequests := maxRequests - requestCount
-			err = s.handler.HandleStream(request, conn, shouldKeepAlive, remainingRequests)
-			if err != nil {
-				return
+		// Force close once the per-connection request limit is reached
+		if requestCount >= maxRequests {
+			keepAlive = false
+		}
+
+		// Pick the site for this request's Host header
+		site, code, status := s.resolveHost(request)
+		if site == nil {
+			// 400 leaves the connection in doubt, so close it; 421 is safe to reuse
+			if code == 400 {
+				keepAlive = false
 			}
-			// Close connection if not keep-alive
-			if !shouldKeepAlive {
+			if err := s.writeHostError(conn, request, code, status, keepAlive, maxRequests-requestCount); err != nil {
 				return
 			}
-		} else {
-			// Use regular handler (returns Response object)
-			response := s.handler.Handle(request)
-			response.Version = s.config.Version
-
-			// Set response Connection header
-			if keepAlive && requestCount < maxRequests {
-				response.Headers["Connection"] = "keep-alive"
-				remainingRequests := maxRequests - requestCount
-				response.Headers["Keep-Alive"] = fmt.Sprintf("timeout=30, max=%d", remainingRequests)
-			} else {
-				response.Headers["Connection"] = "close"
-				keepAlive = false // Force close if max reached
-			}
-
-			err = protocol.WriteResponse(conn, response)
-			if err != nil {
+			if !keepAlive || request.DiscardBody(protocol.MaxRequestSize) != nil {
 				return
 			}
+			conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+			continue
+		}
+
+		// Router decides whether the route is buffered or streaming
+		err = site.Serve(request, conn, keepAlive, maxRequests-requestCount)
+		if err != nil {
+			return
 		}
 
 		// Close connection if not keep-alive
@@ -124,7 +142,52 @@ func (s *Server) handleConnection(conn *tcp.TCPConn) {
 			return
 		}
 
+		// Skip any body the handler didn't read; a large leftover (e.g. a
+		// rejected upload) closes the connection instead
+		if err := request.DiscardBody(protocol.MaxRequestSize); err != nil {
+			return
+		}
+
 		// Reset read deadline for next request
 		conn.SetReadDeadline(time.Now().Add(30 * time.Second))
 	}
 }
+
+// resolveHost finds the site for a request
+// HTTP/1.1 requires a Host header (400 if missing); a Host that matches no site
+// and no default host gets 421 Misdirected Request (RFC 9110 section 15.5.20)
+func (s *Server) resolveHost(req *protocol.Request) (*handler.HTTPHandler, int, string) {
+	host, ok := req.Headers["Host"]
+	if !ok || strings.TrimSpace(host) == "" {
+		if req.Version == protocol.HTTP10 {
+			// HTTP/1.0 clients may omit Host - serve the default site
+			if site := s.hosts.Default(); site != nil {
+				return site, 0, ""
+			}
+			return nil, 421, "Misdirected Request"
+		}
+		return nil, 400, "Bad Request"
+	}
+
+	if site := s.hosts.Lookup(host); site != nil {
+		return site, 0, ""
+	}
+	return nil, 421, "Misdirected Request"
+}
+
+// writeHostError writes a 400/421 using the default site's error pages when available
+func (s *Server) writeHostError(conn *tcp.TCPConn, req *protocol.Request, code int, status string, keepAlive bool, remainingRequests int) error {
+	if site := s.hosts.Default(); site != nil {
+		return site.Router().WriteError(conn, req, code, status, keepAlive, remainingRequests)
+	}
+
+	resp := router.DefaultErrorHandler(req, code, status)
+	resp.Version = s.config.Version
+	if keepAlive {
+		resp.Headers["Connection"] = "keep-alive"
+		resp.Headers["Keep-Alive"] = fmt.Sprintf("timeout=30, max=%d", remainingRequests)
+	} else {
+		resp.Headers["Connection"] = "close"
+	}
+	return protocol.WriteResponse(conn, resp)
+}
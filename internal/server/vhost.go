@@ -0,0 +1,119 @@
+package server
+
+import (
+	"sort"
+	"strings"
+	"webserver/internal/handler"
+)
+
+// wildcardHost is a "*.example.test" pattern stored as its ".example.test" suffix
+type wildcardHost struct {
+	suffix  string
+	handler *handler.HTTPHandler
+}
+
+// VirtualHosts maps Host header values to per-site handlers
+// Each site has its own router, static root and template directory.
+//
+// Lookup order:
+//  1. Exact host ("blog.example.test")
+//  2. Wildcard host ("*.example.test"), longest suffix first
+//  3. Default host (if configured), otherwise the host is unknown (421)
+type VirtualHosts struct {
+	exact       map[string]*handler.HTTPHandler
+	wildcards   []wildcardHost
+	defaultHost *handler.HTTPHandler
+}
+
+// NewVirtualHosts creates an empty host table
+func NewVirtualHosts() *VirtualHosts {
+	return &VirtualHosts{
+		exact: make(map[string]*handler.HTTPHandler),
+	}
+}
+
+// Add registers a site for an exact host name or a "*.example.test" wildcard
+// Wildcards match any subdomain depth but not the bare domain itself.
+func (v *VirtualHosts) Add(pattern string, h *handler.HTTPHandler) {
+	pattern = normalizeHost(pattern)
+
+	if !strings.HasPrefix(pattern, "*.") {
+		v.exact[pattern] = h
+		return
+	}
+
+	suffix := strings.TrimPrefix(pattern, "*")
+	for i, w := range v.wildcards {
+		if w.suffix == suffix {
+			v.wildcards[i].handler = h
+			return
+		}
+	}
+	v.wildcards = append(v.wildcards, wildcardHost{suffix: suffix, handler: h})
+
+	// Most specific wildcard first so "*.api.example.test" wins over "*.example.test"
+	sort.SliceStable(v.wildcards, func(i, j int) bool {
+		return len(v.wildcards[i].suffix) > len(v.wildcards[j].suffix)
+	})
+}
+
+// SetDefault sets the site used when no host pattern matches
+// Pass nil to answer unknown hosts with 421 Misdirected Request
+func (v *VirtualHosts) SetDefault(h *handler.HTTPHandler) {
+	v.defaultHost = h
+}
+
+// Default returns the site used for unknown hosts (may be nil)
+func (v *VirtualHosts) Default() *handler.HTTPHandler {
+	return v.defaultHost
+}
+
+// each calls fn for every registered site, the default included
+func (v *VirtualHosts) each(fn func(*handler.HTTPHandler)) {
+	for _, h := range v.exact {
+		fn(h)
+	}
+	for _, w := range v.wildcards {
+		fn(w.handler)
+	}
+	if v.defaultHost != nil {
+		fn(v.defaultHost)
+	}
+}
+
+// Lookup returns the site for a Host header value, or nil if none matches
+func (v *VirtualHosts) Lookup(host string) *handler.HTTPHandler {
+	host = normalizeHost(host)
+
+	if h, found := v.exact[host]; found {
+		return h
+	}
+
+	for _, w := range v.wildcards {
+		if strings.HasSuffix(host, w.suffix) && len(host) > len(w.suffix) {
+			return w.handler
+		}
+	}
+
+	return v.defaultHost
+}
+
+// normalizeHost lowercases a host, strips the port and any trailing dot
+// "Example.TEST:8080" → "example.test", "[::1]:8080" → "[::1]"
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+
+	if strings.HasPrefix(host, "[") {
+		// IPv6 literal: keep the brackets, drop the port
+		if end := strings.Index(host, "]"); end != -1 {
+			return host[:end+1]
+		}
+		return host
+	}
+
+	if idx := strings.LastIndex(host, ":"); idx != -1 {
+		host = host[:idx]
+	}
+
+	return strings.TrimSuffix(host, ".")
+}
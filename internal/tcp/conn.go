@@ -1,11 +1,25 @@
 package tcp
 
 import (
+	"io"
 	"net"
+	"os"
 	"syscall"
 	"time"
 )
 
+// maxSendfileChunk is the most one sendfile(2) call is asked to send
+const maxSendfileChunk = 1 << 30 // 1GB
+
+// Throttle paces the data a connection sends (see SetThrottle).
+// Take blocks until some of the n bytes about to be sent may go, and returns
+// how many (between 1 and n). Give hands back the part of an allowance that
+// wasn't sent (a short write, an error), so it isn't lost.
+type Throttle interface {
+	Take(n int) int
+	Give(n int)
+}
+
 // TCPConn represents an established TCP connection.
 // It wraps a file descriptor and provides read/write operations with timeout support.
 // This struct implements the net.Conn interface for compatibility with standard Go networking.
@@ -15,6 +29,8 @@ type TCPConn struct {
 	raddr         *TCPAddr  // Remote address (connected peer's IP and port)
 	readDeadline  time.Time // Deadline for read operations (zero = no timeout)
 	writeDeadline time.Time // Deadline for write operations (zero = no timeout)
+	bytesWritten  int64     // Total bytes written over the connection's lifetime
+	throttle      Throttle  // Paces Write and SendFile (nil = as fast as the network allows)
 }
 
 // Read reads data from the TCP connection into the provided byte slice.
@@ -68,8 +84,128 @@ func (c *TCPConn) Read(b []byte) (int, error) {
 //	    // Handle partial write (rare)
 //	}
 func (c *TCPConn) Write(b []byte) (int, error) {
-	n, err := syscall.Write(c.fd, b)
-	return n, err
+	if c.throttle == nil {
+		n, err := syscall.Write(c.fd, b)
+		if n > 0 {
+			c.bytesWritten += int64(n)
+		}
+		return n, err
+	}
+
+	// Throttled: send as much as the throttle allows at a time
+	written := 0
+	for written < len(b) {
+		allowed := c.throttle.Take(len(b) - written)
+		n, err := syscall.Write(c.fd, b[written:written+allowed])
+		if unused := allowed - max(n, 0); unused > 0 {
+			c.throttle.Give(unused)
+		}
+		if n > 0 {
+			written += n
+			c.bytesWritten += int64(n)
+		}
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// SendFile sends count bytes of f, starting at offset, to the connection.
+//
+// Parameters:
+//   - f: An open regular file
+//   - offset: Position in f of the first byte to send
+//   - count: Number of bytes to send
+//
+// Returns:
+//   - int64: Number of bytes sent
+//   - error: Error if sending fails; io.ErrUnexpectedEOF if f ends early
+//
+// The data is copied with sendfile(2): the kernel moves it from the page cache
+// to the socket without passing it through user space. A throttle set with
+// SetThrottle applies here too, one sendfile call per allowance. Files the
+// kernel can't sendfile from are copied with Read and Write instead.
+//
+// Example:
+//
+//	// Send bytes 1000-1999 of a video
+//	n, err := conn.SendFile(file, 1000, 1000)
+func (c *TCPConn) SendFile(f *os.File, offset, count int64) (int64, error) {
+	fileFd := int(f.Fd())
+
+	var sent int64
+	for sent < count {
+		chunk := count - sent
+		if chunk > maxSendfileChunk {
+			chunk = maxSendfileChunk
+		}
+		if c.throttle != nil {
+			chunk = int64(c.throttle.Take(int(chunk)))
+		}
+
+		n, err := syscall.Sendfile(c.fd, fileFd, &offset, int(chunk))
+		if unused := chunk - int64(max(n, 0)); c.throttle != nil && unused > 0 {
+			c.throttle.Give(int(unused))
+		}
+		if n > 0 {
+			sent += int64(n)
+			c.bytesWritten += int64(n)
+		}
+		switch {
+		case err == syscall.EINTR:
+			continue
+		case (err == syscall.EINVAL || err == syscall.ENOSYS) && sent == 0:
+			// sendfile unsupported for this file: copy through user space
+			return io.Copy(c, io.NewSectionReader(f, offset, count))
+		case err != nil:
+			return sent, err
+		case n == 0:
+			return sent, io.ErrUnexpectedEOF // The file shrank
+		}
+	}
+	return sent, nil
+}
+
+// SetThrottle paces everything the connection sends from now on.
+//
+// Parameters:
+//   - t: The throttle to consult before each write (nil = no limit)
+//
+// Bandwidth limits install a throttle for one response and remove it after.
+//
+// Example:
+//
+//	previous := conn.Throttle()
+//	conn.SetThrottle(limiter)
+//	defer conn.SetThrottle(previous)
+func (c *TCPConn) SetThrottle(t Throttle) {
+	c.throttle = t
+}
+
+// Throttle returns the throttle set with SetThrottle (nil if none).
+func (c *TCPConn) Throttle() Throttle {
+	return c.throttle
+}
+
+// BytesWritten returns the total number of bytes written to the connection.
+//
+// Returns:
+//   - int64: Bytes successfully written since the connection was accepted
+//
+// Comparing the value before and after a handler runs tells whether the handler
+// has started sending a response. Panic recovery uses this to decide between
+// sending a 500 page and simply closing the connection.
+//
+// Example:
+//
+//	before := conn.BytesWritten()
+//	handler(req, conn)
+//	if conn.BytesWritten() == before {
+//	    // Nothing was sent yet - safe to write an error response
+//	}
+func (c *TCPConn) BytesWritten() int64 {
+	return c.bytesWritten
 }
 
 // Close closes the TCP connection, releasing the file descriptor.
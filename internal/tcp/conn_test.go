@@ -0,0 +1,128 @@
+package tcp_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"webserver/internal/tcp"
+	"webserver/internal/tcp/tcptest"
+)
+
+// countingThrottle grants at most limit bytes per Take and records the
+// allowances handed out and given back
+type countingThrottle struct {
+	limit int
+	takes int
+	taken int
+	given int
+}
+
+func (c *countingThrottle) Take(n int) int {
+	c.takes++
+	n = min(n, c.limit)
+	c.taken += n
+	return n
+}
+
+func (c *countingThrottle) Give(n int) {
+	c.given += n
+}
+
+func TestWriteThrottled(t *testing.T) {
+	conn, peer := tcptest.DialPipe(t)
+	throttle := &countingThrottle{limit: 7}
+	conn.SetThrottle(throttle)
+
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	go conn.Write(data)
+
+	got := make([]byte, len(data))
+	if _, err := io.ReadFull(peer, got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("peer read %q, want %q", got, data)
+	}
+	if throttle.takes != 15 || throttle.taken != 100 || throttle.given != 0 {
+		t.Errorf("throttle: %d takes, %d taken, %d given; want 15, 100, 0", throttle.takes, throttle.taken, throttle.given)
+	}
+}
+
+func TestWriteGivesBackUnsentAllowance(t *testing.T) {
+	conn, _ := tcptest.DialPipe(t)
+	throttle := &countingThrottle{limit: 1000}
+	conn.SetThrottle(throttle)
+
+	// Writes fail once the sending side is shut down
+	if err := syscall.Shutdown(tcp.Fd(conn), syscall.SHUT_WR); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := conn.Write(make([]byte, 500)); err == nil || n != 0 {
+		t.Fatalf("Write after shutdown = %d, %v; want an error", n, err)
+	}
+	if throttle.taken != 500 || throttle.given != 500 {
+		t.Errorf("throttle: %d taken, %d given; want the whole allowance back", throttle.taken, throttle.given)
+	}
+}
+
+func TestSendFileThrottled(t *testing.T) {
+	data := bytes.Repeat([]byte("sendfile "), 1000)
+	f, err := os.Create(filepath.Join(t.TempDir(), "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, peer := tcptest.DialPipe(t)
+	throttle := &countingThrottle{limit: 1000}
+	conn.SetThrottle(throttle)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.SendFile(f, 100, 5000)
+		errc <- err
+	}()
+	got := make([]byte, 5000)
+	if _, err := io.ReadFull(peer, got); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data[100:5100]) {
+		t.Error("peer read the wrong bytes")
+	}
+	if throttle.taken-throttle.given != 5000 || throttle.takes < 5 {
+		t.Errorf("throttle: %d takes, %d taken, %d given; want 5000 bytes net in 1000-byte allowances", throttle.takes, throttle.taken, throttle.given)
+	}
+}
+
+func TestSendFileGivesBackUnsentAllowance(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "short"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, _ := tcptest.DialPipe(t)
+	throttle := &countingThrottle{limit: 1000}
+	conn.SetThrottle(throttle)
+
+	// The file is shorter than asked: only 10 bytes go out
+	n, err := conn.SendFile(f, 0, 100)
+	if n != 10 || err != io.ErrUnexpectedEOF {
+		t.Fatalf("SendFile = %d, %v; want 10, io.ErrUnexpectedEOF", n, err)
+	}
+	if throttle.taken-throttle.given != 10 {
+		t.Errorf("throttle: %d taken, %d given; want only the 10 sent bytes kept", throttle.taken, throttle.given)
+	}
+}
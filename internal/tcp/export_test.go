@@ -0,0 +1,4 @@
+package tcp
+
+// Fd exposes the socket of c to the external tests
+func Fd(c *TCPConn) int { return c.fd }
@@ -0,0 +1,34 @@
+// Package tcptest provides test fixtures for code that writes to a *tcp.TCPConn
+package tcptest
+
+import (
+	"net"
+	"testing"
+	"webserver/internal/tcp"
+)
+
+// DialPipe connects a *tcp.TCPConn to a standard library listener; what is
+// written to conn is read from peer. Both are closed when the test ends, so
+// callers must not close conn themselves.
+func DialPipe(t testing.TB) (*tcp.TCPConn, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	conn, err := tcp.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		peer.Close()
+	})
+	return conn, peer
+}
@@ -0,0 +1,320 @@
+// Package throttle limits download bandwidth with token buckets.
+//
+// A Limiter is installed as router middleware on the routes it should slow
+// down. While a response is being written, the connection asks it before each
+// write (and each sendfile call) how many bytes may go; the answer is the
+// smallest allowance of the response's own bucket, the client IP's bucket and
+// an optional Bucket shared by every route and site that is given it.
+package throttle
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"webserver/internal/protocol"
+	"webserver/internal/router"
+	"webserver/internal/tcp"
+)
+
+const (
+	// minGrant is the smallest allowance handed out while more is wanted, so
+	// a slow bucket produces a few full-size writes instead of many tiny ones
+	minGrant = 16 * 1024 // 16KB
+
+	// maxGrant caps one allowance so connections sharing a bucket take turns
+	maxGrant = 256 * 1024 // 256KB
+
+	// clientIdleTime is how long an idle client's bucket is kept, so that
+	// reconnecting doesn't refill its burst
+	clientIdleTime = time.Minute
+)
+
+// Rate is a sustained speed with a burst allowance
+type Rate struct {
+	BytesPerSecond int64 // 0 = unlimited
+	Burst          int64 // Bytes that may be sent at full speed after an idle period (0 = one second's worth)
+}
+
+// Bucket is a token bucket: it fills at the rate and holds up to the burst
+// One Bucket may be shared by several Limiters to cap their combined speed.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64 // Tokens (bytes) per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket for the rate (nil if the rate is unlimited)
+func NewBucket(rate Rate) *Bucket {
+	if rate.BytesPerSecond <= 0 {
+		return nil
+	}
+	burst := rate.Burst
+	if burst <= 0 {
+		burst = rate.BytesPerSecond
+	}
+	return &Bucket{
+		rate:   float64(rate.BytesPerSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// refillLocked adds the tokens earned since the last call; b.mu must be held
+func (b *Bucket) refillLocked(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Config describes the limits of a Limiter
+type Config struct {
+	PerConnection Rate    // Each response on its own
+	PerClient     Rate    // All responses to one client IP together
+	Global        *Bucket // Shared with every Limiter given the same Bucket (nil = none)
+
+	// Range requests asking for fewer bytes than this are sent at full speed,
+	// so seeking in a video starts playing at once; a response that turns out
+	// larger than it asked for is throttled after this many bytes (0 = none)
+	ExemptRangesBelow int64
+}
+
+// Limiter throttles the responses of the routes it is installed on
+type Limiter struct {
+	config Config
+
+	mu          sync.Mutex
+	clients     map[string]*client // By IP
+	lastCleanup time.Time
+}
+
+// client is the shared bucket of one IP
+type client struct {
+	bucket   *Bucket
+	active   int // Responses being sent
+	lastUsed time.Time
+}
+
+// New creates a Limiter
+func New(config Config) *Limiter {
+	return &Limiter{
+		config:      config,
+		clients:     make(map[string]*client),
+		lastCleanup: time.Now(),
+	}
+}
+
+// Middleware throttles everything the wrapped handler writes, sendfile
+// included. Install it on a route with Route.Use, or on a whole router;
+// when Limiters are nested, the innermost one applies.
+//
+// Example:
+//
+//	downloads := throttle.New(throttle.Config{PerConnection: throttle.Rate{BytesPerSecond: 2 << 20}})
+//	r.RegisterStreamRoute("GET", "/static/videos/*", static.ServeFileStream).Use(downloads.Middleware())
+func (l *Limiter) Middleware() router.Middleware {
+	return func(next router.StreamHandlerFunc) router.StreamHandlerFunc {
+		return func(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+			ip := clientIP(conn)
+			t := &connThrottle{}
+			if b := NewBucket(l.config.PerConnection); b != nil {
+				t.buckets = append(t.buckets, b)
+			}
+			if b := l.acquire(ip); b != nil {
+				t.buckets = append(t.buckets, b)
+				defer l.release(ip)
+			}
+			if l.config.Global != nil {
+				t.buckets = append(t.buckets, l.config.Global)
+			}
+			if l.config.ExemptRangesBelow > 0 {
+				if size, ok := rangeSize(req.Headers["Range"]); ok && size < l.config.ExemptRangesBelow {
+					t.free = l.config.ExemptRangesBelow
+				}
+			}
+
+			if len(t.buckets) == 0 {
+				return next(req, conn, keepAlive, remainingRequests)
+			}
+			previous := conn.Throttle()
+			conn.SetThrottle(t)
+			defer conn.SetThrottle(previous)
+			return next(req, conn, keepAlive, remainingRequests)
+		}
+	}
+}
+
+// acquire returns the bucket of ip (nil without a per-client limit)
+func (l *Limiter) acquire(ip string) *Bucket {
+	if l.config.PerClient.BytesPerSecond <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastCleanup) > clientIdleTime {
+		for key, c := range l.clients {
+			if c.active == 0 && now.Sub(c.lastUsed) > clientIdleTime {
+				delete(l.clients, key)
+			}
+		}
+		l.lastCleanup = now
+	}
+
+	c := l.clients[ip]
+	if c == nil {
+		c = &client{bucket: NewBucket(l.config.PerClient)}
+		l.clients[ip] = c
+	}
+	c.active++
+	c.lastUsed = now
+	return c.bucket
+}
+
+// release marks the end of a response to ip
+func (l *Limiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if c := l.clients[ip]; c != nil {
+		c.active--
+		c.lastUsed = time.Now()
+	}
+}
+
+// connThrottle paces one response by all of its buckets
+// Buckets are always listed (and locked) in the same order: the response's,
+// the client's, then the global one, so concurrent responses can't deadlock.
+type connThrottle struct {
+	buckets  []*Bucket
+	free     int64 // Bytes still exempt from throttling
+	lastFree bool  // The last allowance came from free, not the buckets
+}
+
+// Take implements tcp.Throttle
+func (t *connThrottle) Take(n int) int {
+	if n <= 0 {
+		return n
+	}
+	if t.free > 0 {
+		if int64(n) > t.free {
+			n = int(t.free)
+		}
+		t.free -= int64(n)
+		t.lastFree = true
+		return n
+	}
+	t.lastFree = false
+	if n > maxGrant {
+		n = maxGrant
+	}
+
+	for {
+		now := time.Now()
+		for _, b := range t.buckets {
+			b.mu.Lock()
+			b.refillLocked(now)
+		}
+
+		// Wait for a worthwhile allowance, but never more than a bucket can hold
+		need := float64(n)
+		if need > minGrant {
+			need = minGrant
+		}
+		available := float64(n)
+		var wait time.Duration
+		for _, b := range t.buckets {
+			if need > b.burst {
+				need = b.burst
+			}
+			if b.tokens < available {
+				available = b.tokens
+			}
+		}
+		if available >= need {
+			for _, b := range t.buckets {
+				b.tokens -= float64(int(available))
+			}
+		} else {
+			for _, b := range t.buckets {
+				if missing := need - b.tokens; missing > 0 {
+					if d := time.Duration(missing / b.rate * float64(time.Second)); d > wait {
+						wait = d
+					}
+				}
+			}
+		}
+
+		for i := len(t.buckets) - 1; i >= 0; i-- {
+			t.buckets[i].mu.Unlock()
+		}
+		if available >= need && int(available) > 0 {
+			return int(available)
+		}
+		time.Sleep(wait + time.Millisecond)
+	}
+}
+
+// Give implements tcp.Throttle: unsent bytes go back where they came from
+func (t *connThrottle) Give(n int) {
+	if n <= 0 {
+		return
+	}
+	if t.lastFree {
+		t.free += int64(n)
+		return
+	}
+	for _, b := range t.buckets {
+		b.mu.Lock()
+		b.tokens = min(b.tokens+float64(n), b.burst)
+		b.mu.Unlock()
+	}
+}
+
+// clientIP returns the peer address of conn without its port
+func clientIP(conn *tcp.TCPConn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// rangeSize adds up the bytes a Range header asks for
+// Open-ended ranges ("bytes=500-") have no size known in advance.
+func rangeSize(header string) (int64, bool) {
+	unit, spec, found := strings.Cut(header, "=")
+	if !found || !strings.EqualFold(strings.TrimSpace(unit), "bytes") {
+		return 0, false
+	}
+
+	var total int64
+	for _, part := range strings.Split(spec, ",") {
+		first, last, found := strings.Cut(strings.TrimSpace(part), "-")
+		if !found || last == "" {
+			return 0, false
+		}
+		end, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || end < 0 {
+			return 0, false
+		}
+		if first == "" {
+			total += end // Suffix range: the last end bytes
+			continue
+		}
+		start, err := strconv.ParseInt(first, 10, 64)
+		if err != nil || start > end {
+			return 0, false
+		}
+		total += end - start + 1
+	}
+	return total, true
+}
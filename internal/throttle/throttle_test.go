@@ -0,0 +1,214 @@
+package throttle
+
+import (
+	"sync"
+	"testing"
+	"time"
+	"webserver/internal/protocol"
+	"webserver/internal/tcp"
+	"webserver/internal/tcp/tcptest"
+)
+
+// timed returns what fn returns and how long it took
+func timed(fn func() int) (int, time.Duration) {
+	start := time.Now()
+	n := fn()
+	return n, time.Since(start)
+}
+
+func TestTakeBurst(t *testing.T) {
+	th := &connThrottle{buckets: []*Bucket{NewBucket(Rate{BytesPerSecond: 100 << 10, Burst: 64 << 10})}}
+
+	// The burst goes at once, capped by the request and by maxGrant
+	if n, d := timed(func() int { return th.Take(1000) }); n != 1000 || d > 50*time.Millisecond {
+		t.Errorf("Take(1000) = %d after %v, want 1000 at once", n, d)
+	}
+	if n, d := timed(func() int { return th.Take(1 << 20) }); n != 64<<10-1000 || d > 50*time.Millisecond {
+		t.Errorf("Take(1MB) = %d after %v, want the rest of the burst at once", n, d)
+	}
+
+	// Empty: the next allowance is minGrant, after minGrant/rate (160ms)
+	n, d := timed(func() int { return th.Take(1 << 20) })
+	if n < minGrant || n > minGrant+4<<10 {
+		t.Errorf("Take on an empty bucket = %d, want about minGrant (%d)", n, minGrant)
+	}
+	if d < 120*time.Millisecond {
+		t.Errorf("Take on an empty bucket returned after %v, want about 160ms", d)
+	}
+
+	// Requests smaller than minGrant wait only for what they ask
+	if _, d := timed(func() int { return th.Take(1024) }); d > 60*time.Millisecond {
+		t.Errorf("Take(1024) on an empty bucket waited %v, want about 10ms", d)
+	}
+}
+
+func TestTakeCapsGrantAtBurstAndMaxGrant(t *testing.T) {
+	small := &connThrottle{buckets: []*Bucket{NewBucket(Rate{BytesPerSecond: 1 << 30, Burst: 4096})}}
+	if n := small.Take(1 << 20); n != 4096 {
+		t.Errorf("Take with a 4KB burst = %d, want 4096", n)
+	}
+
+	large := &connThrottle{buckets: []*Bucket{NewBucket(Rate{BytesPerSecond: 1 << 30, Burst: 10 << 20})}}
+	if n := large.Take(1 << 30); n != maxGrant {
+		t.Errorf("Take with a 10MB burst = %d, want maxGrant (%d)", n, maxGrant)
+	}
+}
+
+func TestTakeSharedGlobalBucket(t *testing.T) {
+	global := NewBucket(Rate{BytesPerSecond: 200 << 10, Burst: 32 << 10})
+	connRate := Rate{BytesPerSecond: 100 << 20}
+	a := &connThrottle{buckets: []*Bucket{NewBucket(connRate), global}}
+	b := &connThrottle{buckets: []*Bucket{NewBucket(connRate), global}}
+
+	// a drains the shared bucket, although its own is nearly full
+	if n := a.Take(1 << 20); n != 32<<10 {
+		t.Fatalf("first Take = %d, want the global burst (%d)", n, 32<<10)
+	}
+	n, d := timed(func() int { return b.Take(1 << 20) })
+	if d < 50*time.Millisecond {
+		t.Errorf("second connection got %d bytes after %v, want it to wait for the global bucket", n, d)
+	}
+
+	// Together they can't beat the global rate: 32KB burst + 200KB/s
+	var mu sync.Mutex
+	total := 0
+	deadline := time.Now().Add(300 * time.Millisecond)
+	var wg sync.WaitGroup
+	for _, th := range []*connThrottle{a, b} {
+		wg.Add(1)
+		go func(th *connThrottle) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				n := th.Take(8 << 10)
+				mu.Lock()
+				total += n
+				mu.Unlock()
+			}
+		}(th)
+	}
+	wg.Wait()
+	if max := 32<<10 + 200<<10*4/10 + 2*(8<<10); total > max {
+		t.Errorf("sent %d bytes in 300ms through a 200KB/s global bucket, want at most %d", total, max)
+	}
+}
+
+func TestGiveReturnsUnusedAllowance(t *testing.T) {
+	th := &connThrottle{buckets: []*Bucket{NewBucket(Rate{BytesPerSecond: 10 << 10, Burst: 64 << 10})}}
+	if n := th.Take(64 << 10); n != 64<<10 {
+		t.Fatalf("Take = %d, want the burst", n)
+	}
+
+	// Only 1000 bytes were sent: the rest can go at once
+	th.Give(64<<10 - 1000)
+	if n, d := timed(func() int { return th.Take(1 << 20) }); n != 64<<10-1000 || d > 50*time.Millisecond {
+		t.Errorf("Take after Give = %d after %v, want %d at once", n, d, 64<<10-1000)
+	}
+
+	// Giving back never overfills the bucket
+	th.Give(1 << 30)
+	if n := th.Take(1 << 30); n != 64<<10 {
+		t.Errorf("Take after an oversized Give = %d, want the burst", n)
+	}
+
+	// Exempt bytes go back to the exemption
+	exempt := &connThrottle{buckets: []*Bucket{NewBucket(Rate{BytesPerSecond: 1, Burst: 1})}, free: 1000}
+	if n := exempt.Take(600); n != 600 {
+		t.Fatalf("exempt Take = %d, want 600", n)
+	}
+	exempt.Give(500)
+	if n := exempt.Take(5000); n != 900 {
+		t.Errorf("exempt Take after Give = %d, want 900", n)
+	}
+}
+
+func TestMiddlewareExemptsSmallRanges(t *testing.T) {
+	limiter := New(Config{
+		PerConnection:     Rate{BytesPerSecond: 1000, Burst: 500},
+		ExemptRangesBelow: 10000,
+	})
+
+	tests := []struct {
+		rangeHeader string
+		want        int // First allowance for Take(1MB)
+	}{
+		{"bytes=0-99", 10000},         // Exempt: the whole exemption, then throttled
+		{"bytes=0-99,200-299", 10000}, // Exempt
+		{"bytes=-500", 10000},         // Suffix range, exempt
+		{"bytes=0-19999", 500},        // Too large: the burst
+		{"bytes=0-", 500},             // Open-ended: size unknown
+		{"", 500},                     // Not a range request
+	}
+	for _, tt := range tests {
+		conn, _ := tcptest.DialPipe(t)
+		var got int
+		handler := limiter.Middleware()(func(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+			got = conn.Throttle().Take(1 << 20)
+			return nil
+		})
+
+		req := &protocol.Request{Method: "GET", Path: "/video.mp4", Version: protocol.HTTP11, Headers: map[string]string{}}
+		if tt.rangeHeader != "" {
+			req.Headers["Range"] = tt.rangeHeader
+		}
+		if err := handler(req, conn, false, 0); err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Range %q: first allowance %d, want %d", tt.rangeHeader, got, tt.want)
+		}
+		if conn.Throttle() != nil {
+			t.Errorf("Range %q: throttle left on the connection", tt.rangeHeader)
+		}
+	}
+}
+
+func TestMiddlewareSharesClientBucket(t *testing.T) {
+	limiter := New(Config{PerClient: Rate{BytesPerSecond: 10000, Burst: 3000}})
+	take := func() int {
+		conn, _ := tcptest.DialPipe(t)
+		var got int
+		limiter.Middleware()(func(req *protocol.Request, conn *tcp.TCPConn, keepAlive bool, remainingRequests int) error {
+			got = conn.Throttle().Take(2000)
+			return nil
+		})(&protocol.Request{Headers: map[string]string{}}, conn, false, 0)
+		return got
+	}
+
+	// Both responses come from 127.0.0.1: the second waits for the 1000
+	// bytes the first left it to grow back to 2000
+	if n, d := timed(take); n != 2000 || d > 50*time.Millisecond {
+		t.Errorf("first response: %d after %v, want 2000 at once", n, d)
+	}
+	if n, d := timed(take); n != 2000 || d < 70*time.Millisecond {
+		t.Errorf("second response: %d after %v, want 2000 after about 100ms", n, d)
+	}
+}
+
+func TestRangeSize(t *testing.T) {
+	tests := []struct {
+		header string
+		size   int64
+		ok     bool
+	}{
+		{"bytes=0-99", 100, true},
+		{"bytes=100-100", 1, true},
+		{"bytes=0-99, 200-299", 200, true},
+		{"bytes=-500", 500, true},
+		{"BYTES = 0-9", 10, true},
+		{"bytes=500-", 0, false}, // Open-ended
+		{"bytes=0-99,500-", 0, false},
+		{"bytes=10-5", 0, false},
+		{"bytes=a-b", 0, false},
+		{"bytes=0-x", 0, false},
+		{"bytes=5", 0, false},
+		{"items=0-9", 0, false},
+		{"0-9", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		size, ok := rangeSize(tt.header)
+		if size != tt.size || ok != tt.ok {
+			t.Errorf("rangeSize(%q) = %d, %v, want %d, %v", tt.header, size, ok, tt.size, tt.ok)
+		}
+	}
+}
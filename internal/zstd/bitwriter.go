@@ -0,0 +1,36 @@
+package zstd
+
+// bitWriter accumulates a little-endian bitstream
+// zstd entropy-coded streams (FSE sequences, Huffman literals) are read backwards
+// by the decoder, so encoders write symbols in reverse order and finish the
+// stream with a single 1 bit marking where the data ends.
+type bitWriter struct {
+	out   []byte
+	bits  uint64 // Pending bits, lowest bit first
+	nBits uint   // Number of pending bits (always < 8 between calls)
+}
+
+// addBits appends the low n bits of value (n <= 32)
+func (b *bitWriter) addBits(value uint64, n uint) {
+	if n == 0 {
+		return
+	}
+	b.bits |= (value & (1<<n - 1)) << b.nBits
+	b.nBits += n
+	for b.nBits >= 8 {
+		b.out = append(b.out, byte(b.bits))
+		b.bits >>= 8
+		b.nBits -= 8
+	}
+}
+
+// close writes the end-of-stream marker bit and pads to a full byte
+func (b *bitWriter) close() []byte {
+	b.addBits(1, 1)
+	if b.nBits > 0 {
+		b.out = append(b.out, byte(b.bits))
+		b.bits = 0
+		b.nBits = 0
+	}
+	return b.out
+}
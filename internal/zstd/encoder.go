@@ -0,0 +1,319 @@
+// Package zstd implements a small pure-Go Zstandard (RFC 8878) encoder.
+//
+// The encoder favours simplicity over ratio: greedy LZ77 matching inside each
+// 128KB block, Huffman-coded literals, and sequences coded with the predefined
+// FSE tables. Output is a standard zstd frame that any conforming decoder
+// (browsers, curl, the zstd CLI) can read.
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+const (
+	frameMagic   = 0xFD2FB528
+	blockMaxSize = 128 * 1024 // Block_Maximum_Size, also the window size we declare
+	windowLog    = 17         // 1 << 17 = 128KB window
+
+	minMatch  = 4
+	hashLog   = 15
+	maxOffset = blockMaxSize
+
+	blockTypeRaw        = 0
+	blockTypeCompressed = 2
+)
+
+// ErrClosed is returned when writing to a closed Writer
+var ErrClosed = errors.New("zstd: write to closed writer")
+
+// Writer compresses data written to it into a single zstd frame
+// Data is buffered into 128KB blocks; each block is compressed on its own, so
+// memory use stays constant regardless of the total size.
+type Writer struct {
+	w           io.Writer
+	buf         []byte
+	wroteHeader bool
+	closed      bool
+	err         error
+	table       []int32 // Hash table reused across blocks
+}
+
+// NewWriter returns a Writer that writes a zstd frame to w
+// Close must be called to write the final block.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:     w,
+		buf:   make([]byte, 0, blockMaxSize),
+		table: make([]int32, 1<<hashLog),
+	}
+}
+
+// Write buffers p and emits full blocks as they fill up
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, ErrClosed
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := blockMaxSize - len(z.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		z.buf = append(z.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		// Only flush a full block once more data arrives, so the last block
+		// can carry the Last_Block flag when Close is called
+		if len(z.buf) == blockMaxSize && len(p) > 0 {
+			if err := z.writeBlock(false); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Flush compresses any buffered data as a non-final block
+// Used by streaming responses to push data to the client without ending the frame.
+func (z *Writer) Flush() error {
+	if z.closed {
+		return ErrClosed
+	}
+	if z.err != nil {
+		return z.err
+	}
+	if len(z.buf) == 0 {
+		return nil
+	}
+	return z.writeBlock(false)
+}
+
+// Close writes the remaining data as the last block of the frame
+// It does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	z.closed = true
+	if z.err != nil {
+		return z.err
+	}
+	return z.writeBlock(true)
+}
+
+// writeHeader writes the frame header: magic number and a window descriptor
+// The content size is not declared, which lets the same code path serve both
+// one-shot and streaming compression.
+func (z *Writer) writeHeader() error {
+	header := binary.LittleEndian.AppendUint32(nil, frameMagic)
+	header = append(header,
+		0x00,                  // Frame_Header_Descriptor: no FCS, not single segment, no checksum, no dictionary
+		byte(windowLog-10)<<3, // Window_Descriptor: exponent only, mantissa 0
+	)
+	_, err := z.w.Write(header)
+	return err
+}
+
+// writeBlock compresses the buffered data into one block and writes it
+func (z *Writer) writeBlock(last bool) error {
+	if !z.wroteHeader {
+		if z.err = z.writeHeader(); z.err != nil {
+			return z.err
+		}
+		z.wroteHeader = true
+	}
+
+	src := z.buf
+	blockType, body := blockTypeRaw, src
+	if compressed := z.compressBlock(src); compressed != nil && len(compressed) < len(src) {
+		blockType, body = blockTypeCompressed, compressed
+	}
+
+	// Block_Header: Last_Block (1 bit), Block_Type (2 bits), Block_Size (21 bits)
+	header := uint32(len(body))<<3 | uint32(blockType)<<1
+	if last {
+		header |= 1
+	}
+	out := make([]byte, 0, 3+len(body))
+	out = append(out, byte(header), byte(header>>8), byte(header>>16))
+	out = append(out, body...)
+
+	if _, z.err = z.w.Write(out); z.err != nil {
+		return z.err
+	}
+	z.buf = z.buf[:0]
+	return nil
+}
+
+// sequence is one LZ77 step: copy litLen literals, then matchLen bytes from offset back
+type sequence struct {
+	litLen   uint32
+	matchLen uint32
+	offset   uint32
+}
+
+// compressBlock returns a Compressed_Block body, or nil if it should be stored raw
+func (z *Writer) compressBlock(src []byte) []byte {
+	if len(src) < minMatch*2 {
+		return nil
+	}
+
+	for i := range z.table {
+		z.table[i] = -1
+	}
+
+	var seqs []sequence
+	literals := make([]byte, 0, len(src))
+	anchor := 0
+	limit := len(src) - minMatch
+
+	for i := 0; i <= limit; {
+		h := hash4(src[i:])
+		candidate := int(z.table[h])
+		z.table[h] = int32(i)
+
+		if candidate < 0 || i-candidate > maxOffset ||
+			binary.LittleEndian.Uint32(src[candidate:]) != binary.LittleEndian.Uint32(src[i:]) {
+			i++
+			continue
+		}
+
+		// Extend the match backwards over pending literals, then forwards
+		for i > anchor && candidate > 0 && src[i-1] == src[candidate-1] {
+			i--
+			candidate--
+		}
+		length := minMatch
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+
+		literals = append(literals, src[anchor:i]...)
+		seqs = append(seqs, sequence{
+			litLen:   uint32(i - anchor),
+			matchLen: uint32(length),
+			offset:   uint32(i - candidate),
+		})
+
+		// Index a few positions inside the match to find later repeats
+		end := i + length
+		for j := i + 1; j < end && j <= limit; j += 2 {
+			z.table[hash4(src[j:])] = int32(j)
+		}
+		i = end
+		anchor = end
+	}
+	literals = append(literals, src[anchor:]...)
+
+	out := encodeLiterals(literals)
+	if out == nil {
+		out = rawLiterals(literals)
+	}
+	return append(out, encodeSequences(seqs)...)
+}
+
+// hash4 hashes the next four bytes for the match finder
+func hash4(b []byte) uint32 {
+	return (binary.LittleEndian.Uint32(b) * 2654435761) >> (32 - hashLog)
+}
+
+// Baselines and extra bits for literal length codes (RFC 8878 section 3.1.1.3.2.1.1)
+var (
+	literalLengthBase = []uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	literalLengthBits = []uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	matchLengthBase = []uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	matchLengthBits = []uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// lengthCode returns the largest code whose baseline does not exceed value
+func lengthCode(base []uint32, value uint32) byte {
+	return byte(sort.Search(len(base), func(i int) bool { return base[i] > value }) - 1)
+}
+
+// encodeSequences builds the Sequences_Section using the predefined FSE tables
+func encodeSequences(seqs []sequence) []byte {
+	n := len(seqs)
+	if n == 0 {
+		return []byte{0} // Literals only: no modes byte, no bitstream
+	}
+
+	var out []byte
+	switch {
+	case n < 128:
+		out = append(out, byte(n))
+	case n < 0x7F00:
+		out = append(out, byte(n>>8)+0x80, byte(n))
+	default:
+		out = append(out, 0xFF, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	out = append(out, 0x00) // Symbol_Compression_Modes: all predefined
+
+	llCodes := make([]byte, n)
+	mlCodes := make([]byte, n)
+	ofCodes := make([]byte, n)
+	ofValues := make([]uint32, n)
+	for i, s := range seqs {
+		llCodes[i] = lengthCode(literalLengthBase, s.litLen)
+		mlCodes[i] = lengthCode(matchLengthBase, s.matchLen)
+		ofValues[i] = s.offset + 3 // Offset_Value > 3 means a literal offset (no repeat codes)
+		ofCodes[i] = byte(highBit(ofValues[i]))
+	}
+
+	var bw bitWriter
+	var ll, ml, of fseState
+
+	// Sequences are written last to first; the decoder reads them first to last
+	last := n - 1
+	ml.init(matchLengthTable, mlCodes[last])
+	of.init(offsetTable, ofCodes[last])
+	ll.init(literalLengthTable, llCodes[last])
+	addSequenceBits(&bw, seqs[last], llCodes[last], mlCodes[last], ofCodes[last], ofValues[last])
+
+	for i := n - 2; i >= 0; i-- {
+		of.encode(&bw, ofCodes[i])
+		ml.encode(&bw, mlCodes[i])
+		ll.encode(&bw, llCodes[i])
+		addSequenceBits(&bw, seqs[i], llCodes[i], mlCodes[i], ofCodes[i], ofValues[i])
+	}
+
+	ml.flush(&bw)
+	of.flush(&bw)
+	ll.flush(&bw)
+
+	return append(out, bw.close()...)
+}
+
+// addSequenceBits writes the extra bits of one sequence: literal length,
+// match length, then offset (the decoder reads them in reverse)
+func addSequenceBits(bw *bitWriter, s sequence, llCode, mlCode, ofCode byte, ofValue uint32) {
+	bw.addBits(uint64(s.litLen-literalLengthBase[llCode]), uint(literalLengthBits[llCode]))
+	bw.addBits(uint64(s.matchLen-matchLengthBase[mlCode]), uint(matchLengthBits[mlCode]))
+	bw.addBits(uint64(ofValue-(1<<ofCode)), uint(ofCode))
+}
@@ -0,0 +1,157 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os/exec"
+	"testing"
+)
+
+// decodeCLI decompresses frame with the reference zstd CLI
+// Tests are skipped when it isn't installed.
+func decodeCLI(t testing.TB, frame []byte) []byte {
+	t.Helper()
+	path, err := exec.LookPath("zstd")
+	if err != nil {
+		t.Skip("zstd CLI not found; install it to check the bitstream against the reference decoder")
+	}
+
+	cmd := exec.Command(path, "-d", "-c", "-q")
+	cmd.Stdin = bytes.NewReader(frame)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("zstd -d: %v: %s", err, stderr.String())
+	}
+	return stdout.Bytes()
+}
+
+// compress encodes data, writing it in pieces of chunk bytes (0 = all at
+// once) and flushing after every piece when flush is set
+func compress(t testing.TB, data []byte, chunk int, flush bool) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	z := NewWriter(&out)
+	if chunk <= 0 {
+		chunk = len(data) + 1
+	}
+	for rest := data; len(rest) > 0; {
+		n := min(chunk, len(rest))
+		if _, err := z.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		if flush {
+			if err := z.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		rest = rest[n:]
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// testInputs covers the block types and coding paths of the encoder
+func testInputs() map[string][]byte {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 300*1024)
+	rng.Read(random)
+
+	var text bytes.Buffer
+	words := []string{"static", "file", "server", "range", "request", "header", "zstd", "block", "frame", "literal"}
+	for text.Len() < 400*1024 {
+		fmt.Fprintf(&text, "%s %s %d\n", words[rng.Intn(len(words))], words[rng.Intn(len(words))], rng.Intn(1000))
+	}
+
+	// Long repeats with a short period, at offsets up to a whole block back
+	var mixed bytes.Buffer
+	for mixed.Len() < 3*blockMaxSize {
+		mixed.Write(random[:rng.Intn(512)])
+		mixed.Write(bytes.Repeat([]byte("abc"), rng.Intn(200)))
+		mixed.Write(text.Bytes()[:rng.Intn(4096)])
+	}
+
+	allBytes := make([]byte, 256)
+	for i := range allBytes {
+		allBytes[i] = byte(i)
+	}
+
+	return map[string][]byte{
+		"empty":                    {},
+		"single byte":              {'x'},
+		"short text":               []byte("hello, hello, hello world"),
+		"incompressible":           random,
+		"highly repetitive":        bytes.Repeat([]byte{'a'}, 1<<20),
+		"repeated pattern":         bytes.Repeat([]byte("0123456789abcdef"), 50000),
+		"text over several blocks": text.Bytes(),
+		"mixed":                    mixed.Bytes(),
+		"exactly one block":        text.Bytes()[:blockMaxSize],
+		"one block plus one byte":  text.Bytes()[:blockMaxSize+1],
+		"all byte values":          bytes.Repeat(allBytes, 64),
+	}
+}
+
+func TestRoundTripReferenceDecoder(t *testing.T) {
+	for name, data := range testInputs() {
+		t.Run(name, func(t *testing.T) {
+			got := decodeCLI(t, compress(t, data, 0, false))
+			if !bytes.Equal(got, data) {
+				t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestRoundTripStreaming(t *testing.T) {
+	data := testInputs()["mixed"]
+	for _, tc := range []struct {
+		chunk int
+		flush bool
+	}{
+		{1000, false},
+		{7919, true}, // Flushed blocks smaller than the maximum, odd sizes
+		{blockMaxSize, true},
+	} {
+		t.Run(fmt.Sprintf("chunk=%d flush=%v", tc.chunk, tc.flush), func(t *testing.T) {
+			got := decodeCLI(t, compress(t, data, tc.chunk, tc.flush))
+			if !bytes.Equal(got, data) {
+				t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestCompresses(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 50000)
+	if frame := compress(t, data, 0, false); len(frame) > len(data)/20 {
+		t.Errorf("repetitive input compressed to %d of %d bytes", len(frame), len(data))
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	z := NewWriter(&bytes.Buffer{})
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := z.Write([]byte("x")); !errors.Is(err, ErrClosed) {
+		t.Errorf("Write after Close = %v, want ErrClosed", err)
+	}
+}
+
+func FuzzRoundTrip(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte("hello, hello, hello world"))
+	f.Add(bytes.Repeat([]byte("ab"), 1000))
+	f.Add([]byte{0, 0, 0, 0, 1, 0, 0, 0, 0, 1, 255, 255, 255, 255})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		got := decodeCLI(t, compress(t, data, 0, false))
+		if !bytes.Equal(got, data) {
+			t.Fatalf("round trip mismatch: got %d bytes, want %d", len(got), len(data))
+		}
+	})
+}
@@ -0,0 +1,313 @@
+package zstd
+
+// Predefined FSE distributions from RFC 8878 section 3.1.1.3.2.2
+// Using the predefined tables means no table description has to be sent,
+// which keeps the encoder simple while still entropy-coding every sequence.
+var (
+	literalLengthDefaultNorm = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}
+	matchLengthDefaultNorm = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}
+	offsetDefaultNorm = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}
+)
+
+const (
+	literalLengthDefaultLog = 6
+	matchLengthDefaultLog   = 6
+	offsetDefaultLog        = 5
+)
+
+// Predefined encoding tables, built once
+var (
+	literalLengthTable = newFSETable(literalLengthDefaultNorm, literalLengthDefaultLog)
+	matchLengthTable   = newFSETable(matchLengthDefaultNorm, matchLengthDefaultLog)
+	offsetTable        = newFSETable(offsetDefaultNorm, offsetDefaultLog)
+)
+
+// fseSymbolTransform holds the per-symbol encoding parameters
+type fseSymbolTransform struct {
+	deltaNbBits    uint32
+	deltaFindState int32
+}
+
+// fseTable is an FSE compression table (same construction as the reference encoder)
+type fseTable struct {
+	tableLog   uint
+	stateTable []uint16
+	symbolTT   []fseSymbolTransform
+}
+
+// newFSETable builds the encoding table for a normalized distribution
+// Symbols are spread exactly as the decoder does, so both sides agree on states
+func newFSETable(norm []int16, tableLog uint) *fseTable {
+	tableSize := 1 << tableLog
+	highThreshold := tableSize - 1
+	tableSymbol := make([]byte, tableSize)
+	cumul := make([]int, len(norm)+1)
+
+	// Low-probability (-1) symbols take single cells at the end of the table
+	for s, count := range norm {
+		if count == -1 {
+			cumul[s+1] = cumul[s] + 1
+			tableSymbol[highThreshold] = byte(s)
+			highThreshold--
+		} else {
+			cumul[s+1] = cumul[s] + int(count)
+		}
+	}
+
+	// Spread the remaining symbols across the table
+	step := (tableSize >> 1) + (tableSize >> 3) + 3
+	mask := tableSize - 1
+	position := 0
+	for s, count := range norm {
+		for i := 0; i < int(count); i++ {
+			tableSymbol[position] = byte(s)
+			position = (position + step) & mask
+			for position > highThreshold {
+				position = (position + step) & mask
+			}
+		}
+	}
+
+	// Build the state table, sorted by symbol
+	stateTable := make([]uint16, tableSize)
+	next := make([]int, len(norm))
+	copy(next, cumul[:len(norm)])
+	for u := 0; u < tableSize; u++ {
+		s := tableSymbol[u]
+		stateTable[next[s]] = uint16(tableSize + u)
+		next[s]++
+	}
+
+	// Per-symbol transforms
+	symbolTT := make([]fseSymbolTransform, len(norm))
+	total := int32(0)
+	for s, count := range norm {
+		switch count {
+		case 0:
+			symbolTT[s].deltaNbBits = uint32((tableLog+1)<<16) - uint32(tableSize)
+		case -1, 1:
+			symbolTT[s].deltaNbBits = uint32(tableLog<<16) - uint32(tableSize)
+			symbolTT[s].deltaFindState = total - 1
+			total++
+		default:
+			maxBitsOut := tableLog - highBit(uint32(count-1))
+			minStatePlus := uint32(count) << maxBitsOut
+			symbolTT[s].deltaNbBits = uint32(maxBitsOut<<16) - minStatePlus
+			symbolTT[s].deltaFindState = total - int32(count)
+			total += int32(count)
+		}
+	}
+
+	return &fseTable{tableLog: tableLog, stateTable: stateTable, symbolTT: symbolTT}
+}
+
+// fseState is the running state of one FSE encoder
+type fseState struct {
+	value uint32
+	table *fseTable
+}
+
+// init sets the initial state for the first symbol to be encoded (no bits emitted)
+func (st *fseState) init(table *fseTable, symbol byte) {
+	st.table = table
+	tt := table.symbolTT[symbol]
+	nbBitsOut := (tt.deltaNbBits + (1 << 15)) >> 16
+	value := (nbBitsOut << 16) - tt.deltaNbBits
+	st.value = uint32(table.stateTable[int32(value>>nbBitsOut)+tt.deltaFindState])
+}
+
+// encode emits the bits for the transition to symbol
+func (st *fseState) encode(bw *bitWriter, symbol byte) {
+	tt := st.table.symbolTT[symbol]
+	nbBitsOut := (st.value + tt.deltaNbBits) >> 16
+	bw.addBits(uint64(st.value), uint(nbBitsOut))
+	st.value = uint32(st.table.stateTable[int32(st.value>>nbBitsOut)+tt.deltaFindState])
+}
+
+// flush writes the final state so the decoder can start from it
+func (st *fseState) flush(bw *bitWriter) {
+	bw.addBits(uint64(st.value), st.table.tableLog)
+}
+
+// highBit returns the index of the highest set bit (v > 0)
+func highBit(v uint32) uint {
+	n := uint(0)
+	for v > 1 {
+		v >>= 1
+		n++
+	}
+	return n
+}
+
+// weightsTableLog is the accuracy used for FSE-compressed Huffman weights (max 6)
+const weightsTableLog = 6
+
+// compressWeights FSE-compresses Huffman weights with two interleaved states
+// Returns nil when the weights cannot be usefully FSE-coded (e.g. every weight
+// is the same, which would leave the decoder no bits to detect the end).
+func compressWeights(weights []byte) []byte {
+	if len(weights) < 2 {
+		return nil
+	}
+
+	var counts [huffmanMaxBits + 2]int
+	maxWeight, maxCount := 0, 0
+	for _, w := range weights {
+		counts[w]++
+		if int(w) > maxWeight {
+			maxWeight = int(w)
+		}
+		if counts[w] > maxCount {
+			maxCount = counts[w]
+		}
+	}
+	if maxCount == len(weights) || maxCount == 1 {
+		return nil
+	}
+
+	norm := normalizeCounts(counts[:maxWeight+1], len(weights), weightsTableLog)
+	table := newFSETable(norm, weightsTableLog)
+	out := writeNormalizedCounts(norm, weightsTableLog)
+
+	// Even-indexed weights belong to state 1, odd-indexed to state 2.
+	// Encode backwards: each state starts from the last weight it owns.
+	var bw bitWriter
+	var states [2]fseState
+	n := len(weights)
+	states[(n-1)%2].init(table, weights[n-1])
+	states[(n-2)%2].init(table, weights[n-2])
+	for i := n - 3; i >= 0; i-- {
+		states[i%2].encode(&bw, weights[i])
+	}
+	states[1].flush(&bw)
+	states[0].flush(&bw)
+
+	return append(out, bw.close()...)
+}
+
+// normalizeCounts scales counts so they sum to 1<<tableLog
+// Every present symbol keeps a probability of at least 1.
+func normalizeCounts(counts []int, total int, tableLog uint) []int16 {
+	tableSize := 1 << tableLog
+	norm := make([]int16, len(counts))
+	sum := 0
+	for s, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := (c*tableSize + total/2) / total
+		if p < 1 {
+			p = 1
+		}
+		norm[s] = int16(p)
+		sum += p
+	}
+
+	// Fix rounding error on the most probable symbols
+	for sum != tableSize {
+		largest := 0
+		for s := range norm {
+			if norm[s] > norm[largest] {
+				largest = s
+			}
+		}
+		if sum > tableSize {
+			// Take from the largest symbol that can spare it
+			for s := range norm {
+				if norm[s] > 1 && (norm[largest] <= 1 || norm[s] > norm[largest]) {
+					largest = s
+				}
+			}
+			norm[largest]--
+			sum--
+		} else {
+			norm[largest]++
+			sum++
+		}
+	}
+
+	return norm
+}
+
+// writeNormalizedCounts serializes an FSE table description (RFC 8878 section 4.1.1)
+func writeNormalizedCounts(norm []int16, tableLog uint) []byte {
+	var out []byte
+	var bitStream uint64
+	var bitCount uint
+	flush := func() {
+		for bitCount >= 8 {
+			out = append(out, byte(bitStream))
+			bitStream >>= 8
+			bitCount -= 8
+		}
+	}
+
+	tableSize := 1 << tableLog
+	bitStream |= uint64(tableLog-5) << bitCount
+	bitCount += 4
+
+	remaining := tableSize + 1
+	threshold := tableSize
+	nbBits := tableLog + 1
+	previousIs0 := false
+
+	for symbol := 0; symbol < len(norm) && remaining > 1; {
+		if previousIs0 {
+			// Run of zero-probability symbols: 2-bit repeat flags (3 = three more zeros)
+			start := symbol
+			for symbol < len(norm) && norm[symbol] == 0 {
+				symbol++
+			}
+			for symbol >= start+3 {
+				start += 3
+				bitStream |= 3 << bitCount
+				bitCount += 2
+				flush()
+			}
+			bitStream |= uint64(symbol-start) << bitCount
+			bitCount += 2
+			flush()
+		}
+
+		count := int(norm[symbol])
+		symbol++
+		max := (2*threshold - 1) - remaining
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		count++ // +1 so that -1 ("less than 1") is representable
+		if count >= threshold {
+			count += max
+		}
+		bitStream |= uint64(count) << bitCount
+		bitCount += nbBits
+		if count < max {
+			bitCount--
+		}
+		previousIs0 = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+		flush()
+	}
+
+	if bitCount > 0 {
+		out = append(out, byte(bitStream))
+	}
+	return out
+}
@@ -0,0 +1,278 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+const (
+	huffmanMaxBits    = 11  // Maximum prefix code length allowed by zstd
+	huffmanMaxDirect  = 128 // Largest literal usable with direct weight headers
+	huffmanMinLiteral = 64  // Below this, raw literals are cheaper than a tree header
+)
+
+// huffmanTable holds prefix codes for literal bytes
+type huffmanTable struct {
+	maxSymbol int
+	maxBits   uint
+	nbBits    [256]uint8
+	code      [256]uint16
+}
+
+// buildHuffmanTable builds length-limited canonical codes for the literals
+// Returns nil when there are fewer than two distinct bytes.
+func buildHuffmanTable(literals []byte) *huffmanTable {
+	var counts [256]int
+	maxSymbol, distinct := 0, 0
+	for _, b := range literals {
+		if counts[b] == 0 {
+			distinct++
+		}
+		counts[b]++
+		if int(b) > maxSymbol {
+			maxSymbol = int(b)
+		}
+	}
+	if distinct < 2 {
+		return nil
+	}
+
+	lengths := huffmanLengths(counts[:maxSymbol+1])
+
+	t := &huffmanTable{maxSymbol: maxSymbol}
+	for s, l := range lengths {
+		t.nbBits[s] = l
+		if uint(l) > t.maxBits {
+			t.maxBits = uint(l)
+		}
+	}
+
+	// Canonical code assignment used by zstd: longest codes get the lowest
+	// values, and symbols of equal length are ordered by byte value
+	var perRank [huffmanMaxBits + 2]uint16
+	for s := 0; s <= maxSymbol; s++ {
+		perRank[t.nbBits[s]]++
+	}
+	var valPerRank [huffmanMaxBits + 2]uint16
+	min := uint16(0)
+	for n := t.maxBits; n > 0; n-- {
+		valPerRank[n] = min
+		min += perRank[n]
+		min >>= 1
+	}
+	for s := 0; s <= maxSymbol; s++ {
+		if t.nbBits[s] > 0 {
+			t.code[s] = valPerRank[t.nbBits[s]]
+			valPerRank[t.nbBits[s]]++
+		}
+	}
+
+	return t
+}
+
+// huffmanLengths computes code lengths no longer than huffmanMaxBits
+// Counts are flattened and the tree rebuilt until the depth limit holds.
+func huffmanLengths(counts []int) []uint8 {
+	work := make([]int, len(counts))
+	copy(work, counts)
+
+	for {
+		lengths := huffmanTreeLengths(work)
+		longest := uint8(0)
+		for _, l := range lengths {
+			if l > longest {
+				longest = l
+			}
+		}
+		if longest <= huffmanMaxBits {
+			return lengths
+		}
+		for i, c := range work {
+			if c > 0 {
+				work[i] = (c + 1) / 2
+			}
+		}
+	}
+}
+
+// huffmanTreeLengths returns plain Huffman code lengths for non-zero counts
+func huffmanTreeLengths(counts []int) []uint8 {
+	type node struct {
+		weight      int
+		left, right int // Child indices, -1 for leaves
+		symbol      int
+	}
+
+	var nodes []node
+	var queue []int
+	for s, c := range counts {
+		if c > 0 {
+			nodes = append(nodes, node{weight: c, left: -1, right: -1, symbol: s})
+			queue = append(queue, len(nodes)-1)
+		}
+	}
+
+	// Repeatedly merge the two lightest nodes (small alphabets, so sorting is fine)
+	for len(queue) > 1 {
+		sort.SliceStable(queue, func(i, j int) bool {
+			return nodes[queue[i]].weight < nodes[queue[j]].weight
+		})
+		a, b := queue[0], queue[1]
+		nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, left: a, right: b, symbol: -1})
+		queue = append(queue[2:], len(nodes)-1)
+	}
+
+	lengths := make([]uint8, len(counts))
+	var walk func(idx int, depth uint8)
+	walk = func(idx int, depth uint8) {
+		n := nodes[idx]
+		if n.left == -1 {
+			lengths[n.symbol] = depth
+			return
+		}
+		walk(n.left, depth+1)
+		walk(n.right, depth+1)
+	}
+	walk(queue[0], 0)
+
+	return lengths
+}
+
+// header returns the Huffman tree description
+// Weights for symbols 0..maxSymbol-1 are sent (the last one is implied), either
+// FSE-compressed or as direct 4-bit values. Returns nil if neither fits.
+func (t *huffmanTable) header() []byte {
+	weights := make([]byte, t.maxSymbol)
+	for s := range weights {
+		weights[s] = t.weight(s)
+	}
+
+	// FSE-compressed weights: headerByte < 128 is the compressed size
+	compressed := compressWeights(weights)
+	if compressed != nil && len(compressed) < 128 &&
+		(len(compressed) < (len(weights)+1)/2 || t.maxSymbol > huffmanMaxDirect) {
+		return append([]byte{byte(len(compressed))}, compressed...)
+	}
+
+	if t.maxSymbol > huffmanMaxDirect {
+		return nil
+	}
+
+	// Direct representation: headerByte = 127 + count, two weights per byte
+	out := make([]byte, 1+(len(weights)+1)/2)
+	out[0] = byte(127 + len(weights))
+	for s, w := range weights {
+		if s%2 == 0 {
+			out[1+s/2] = w << 4
+		} else {
+			out[1+s/2] |= w
+		}
+	}
+	return out
+}
+
+// weight converts a code length to a zstd weight (0 = symbol absent)
+func (t *huffmanTable) weight(s int) byte {
+	if t.nbBits[s] == 0 {
+		return 0
+	}
+	return byte(t.maxBits + 1 - uint(t.nbBits[s]))
+}
+
+// encodeStream Huffman-codes one literal stream (written backwards)
+func (t *huffmanTable) encodeStream(literals []byte) []byte {
+	var bw bitWriter
+	bw.out = make([]byte, 0, len(literals))
+	for i := len(literals) - 1; i >= 0; i-- {
+		s := literals[i]
+		bw.addBits(uint64(t.code[s]), uint(t.nbBits[s]))
+	}
+	return bw.close()
+}
+
+// encodeLiterals builds a compressed literals section
+// Returns nil if Huffman coding is impossible or would not save space.
+func encodeLiterals(literals []byte) []byte {
+	if len(literals) < huffmanMinLiteral {
+		return nil
+	}
+
+	t := buildHuffmanTable(literals)
+	if t == nil {
+		return nil
+	}
+
+	payload := t.header()
+	if payload == nil {
+		return nil
+	}
+	single := len(literals) <= 1023
+	if single {
+		payload = append(payload, t.encodeStream(literals)...)
+	} else {
+		// Four streams with a jump table holding the first three sizes
+		segment := (len(literals) + 3) / 4
+		var streams [4][]byte
+		for i := 0; i < 4; i++ {
+			start := i * segment
+			end := start + segment
+			if i == 3 || end > len(literals) {
+				end = len(literals)
+			}
+			streams[i] = t.encodeStream(literals[start:end])
+		}
+		for i := 0; i < 3; i++ {
+			if len(streams[i]) > 0xFFFF {
+				return nil
+			}
+			payload = binary.LittleEndian.AppendUint16(payload, uint16(len(streams[i])))
+		}
+		for _, s := range streams {
+			payload = append(payload, s...)
+		}
+	}
+
+	regenerated, compressed := len(literals), len(payload)
+	if compressed >= regenerated {
+		return nil
+	}
+
+	// Literals_Section_Header for Compressed_Literals_Block (type 2)
+	const blockType = 2
+	var header []byte
+	switch {
+	case single && regenerated <= 1023 && compressed <= 1023:
+		v := uint32(blockType) | 0<<2 | uint32(regenerated)<<4 | uint32(compressed)<<14
+		header = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	case single:
+		return nil
+	case regenerated <= 1023 && compressed <= 1023:
+		v := uint32(blockType) | 1<<2 | uint32(regenerated)<<4 | uint32(compressed)<<14
+		header = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	case regenerated <= 16383 && compressed <= 16383:
+		v := uint32(blockType) | 2<<2 | uint32(regenerated)<<4 | uint32(compressed)<<18
+		header = binary.LittleEndian.AppendUint32(nil, v)
+	default:
+		v := uint64(blockType) | 3<<2 | uint64(regenerated)<<4 | uint64(compressed)<<22
+		header = []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24), byte(v >> 32)}
+	}
+
+	return append(header, payload...)
+}
+
+// rawLiterals builds a Raw_Literals_Block section (type 0)
+func rawLiterals(literals []byte) []byte {
+	size := len(literals)
+	var header []byte
+	switch {
+	case size < 32:
+		header = []byte{byte(size << 3)}
+	case size < 4096:
+		v := uint32(1<<2) | uint32(size)<<4
+		header = []byte{byte(v), byte(v >> 8)}
+	default:
+		v := uint32(3<<2) | uint32(size)<<4
+		header = []byte{byte(v), byte(v >> 8), byte(v >> 16)}
+	}
+	return append(header, literals...)
+}
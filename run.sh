@@ -2,6 +2,7 @@
 
 # run.sh - Build and run the web server
 # Always runs from project root to ensure correct file paths
+# (bin/server -embedded serves the files built into the binary from anywhere)
 
 set -e  # Exit on error
 
@@ -40,7 +41,7 @@ echo ""
 
 # Build the server
 echo -e "${GREEN}Building server...${NC}"
-go build -o bin/server cmd/main.go
+go build -o bin/server ./cmd
 
 if [ $? -eq 0 ]; then
     echo -e "${GREEN}✓ Build successful${NC}"